@@ -0,0 +1,603 @@
+package main
+
+import (
+	"github.com/jinzhu/gorm"
+	log "github.com/sirupsen/logrus"
+	"option-kline/audit"
+	"option-kline/chain"
+	"option-kline/common"
+	"option-kline/configlog"
+	"option-kline/forex"
+	"option-kline/kline"
+	"option-kline/migrations"
+	"option-kline/monitor"
+	"option-kline/regulator"
+	"option-kline/settlement"
+	"option-kline/sink"
+	"option-kline/store"
+	"sync"
+	"time"
+)
+
+const (
+	taskRestartDelay = time.Second // 任务panic后的重启间隔
+)
+
+// metrics
+var (
+	persistMetrics = common.NewMetrics("persist")
+	publishMetrics = common.NewMetrics("publish")
+)
+
+// 应用配置
+type AppConfig struct {
+	CoinTypes      []string          // 支持的币种
+	PipelineMode   string            // K线处理模式: serial, parallel
+	PriceModes     map[string]string // 各币种的报价模式, 未配置的币种为passthrough
+	PersistRetry   int               // parallel模式下保存失败的重试次数
+	ReloadInterval time.Duration     // 从数据库重新加载配置的间隔, 0表示不加载
+
+	RetentionInterval  time.Duration         // 保留策略执行间隔, 0表示不执行
+	Retention          store.RetentionConfig // 保留策略
+	AuditInterval      time.Duration         // 数据检查执行间隔, 0表示不执行
+	Audit              audit.JobConfig       // 数据检查
+	MonitorEnabled     bool                  // 是否检查推送报价与行情源报价的偏差
+	Monitor            monitor.Config        // 偏差监控
+	ChainEnabled       bool                  // 是否为保存的秒级K线计算哈希链
+	CheckpointInterval time.Duration         // 哈希链检查点写入间隔, 0表示不写入
+	Chain              chain.Config          // 哈希链检查点
+	SettlementEnabled  bool                  // 是否在每期开奖时刻记录结算报价
+	SettlementPeriod   int64                 // 每期时长, 单位:秒
+}
+
+// 根据已加载的配置生成应用配置
+func NewAppConfig() AppConfig {
+	coinTypes := common.CoinSupported.Load().([]string)
+	return AppConfig{
+		CoinTypes:      coinTypes,
+		PipelineMode:   common.PipelineMode,
+		PriceModes:     priceModes(coinTypes),
+		PersistRetry:   common.PersistRetry,
+		ReloadInterval: 5 * time.Second,
+
+		RetentionInterval:  time.Duration(common.RetentionInterval) * time.Second,
+		Retention:          store.NewRetentionConfig(),
+		AuditInterval:      time.Duration(common.AuditInterval) * time.Second,
+		Audit:              audit.NewJobConfig(),
+		MonitorEnabled:     common.MonitorEnabled,
+		Monitor:            monitor.NewConfig(),
+		ChainEnabled:       common.ChainEnabled,
+		CheckpointInterval: time.Duration(common.ChainCheckpointInterval) * time.Second,
+		Chain:              chain.NewConfig(),
+		SettlementEnabled:  common.SettlementEnabled,
+		SettlementPeriod:   common.SettlementPeriod,
+	}
+}
+
+// 根据已加载的配置获取各币种的报价模式
+func priceModes(coinTypes []string) map[string]string {
+	modes := make(map[string]string, len(coinTypes))
+	for _, coinType := range coinTypes {
+		modes[coinType] = common.GetPriceMode(coinType)
+	}
+	return modes
+}
+
+// 币种的报价模式
+func (c AppConfig) PriceMode(coinType string) string {
+	if mode, ok := c.PriceModes[coinType]; ok {
+		return mode
+	}
+	return common.PRICE_MODE_PASSTHROUGH
+}
+
+// 行情数据源
+type Source interface {
+	Run(tickQueue *kline.Queue)
+	Stop()
+}
+
+// 应用依赖的外部资源构造函数, 测试时可替换为本地实现
+type Dependencies struct {
+	NewDB     func() (*gorm.DB, error)                                                // 读写库(订单数据), 返回nil表示不使用MySQL
+	NewRDB    func() (*gorm.DB, error)                                                // 只读库, 为nil时不从数据库加载配置
+	NewStore  func(db *gorm.DB) (store.CandleStore, error)                            // K线存储
+	NewCache  func() (*kline.RedisCache, error)                                       // redis K线缓存, 返回nil表示不使用redis
+	NewSink   func(name, coinType string, cache *kline.RedisCache) (sink.Sink, error) // 推送目标
+	NewSource func(clock common.Clock) Source                                         // 行情数据源
+	NewEvents func() (sink.EventPublisher, error)                                     // 事件推送目标, 为nil或返回nil时不推送事件
+	Clock     common.Clock                                                            // 时钟, 回放历史行情时使用ReplayClock
+}
+
+// 根据已加载的配置创建外部资源
+func DefaultDependencies() Dependencies {
+	return Dependencies{
+		NewDB: func() (*gorm.DB, error) {
+			// 非mysql存储且未配置数据库时, 不连接MySQL, 报价只按行情数据处理
+			if common.StoreDriver != common.STORE_MYSQL && common.DBCONF.Host == "" {
+				return nil, nil
+			}
+			return common.NewGormDB(common.DBCONF)
+		},
+		NewRDB: func() (*gorm.DB, error) {
+			if common.StoreDriver != common.STORE_MYSQL && common.RDBCONF.Host == "" {
+				return nil, nil
+			}
+			return common.NewGormDB(common.RDBCONF)
+		},
+		NewStore: store.NewStoreFromConfig,
+		NewCache: func() (*kline.RedisCache, error) {
+			if common.RedisCacheSize <= 0 && !common.IsPublishSinkUsed(sink.SinkRedis) {
+				return nil, nil
+			}
+			return kline.NewRedisCacheFromConfig(common.NewRedisPool(common.REDISCONF))
+		},
+		NewSink: sink.NewSink,
+		NewSource: func(clock common.Clock) Source {
+			return forex.NewClient(common.ForexAddr, clock)
+		},
+		NewEvents: func() (sink.EventPublisher, error) {
+			if !common.ConfigEvent {
+				return nil, nil
+			}
+			return sink.NewRabbitMqSink(common.RabbitMqUrl, common.PushExchange, common.PushRoutineKeyList), nil
+		},
+		Clock: common.NewClock(common.ClockMode),
+	}
+}
+
+// 应用: 持有所有外部资源及处理协程, 由NewApp创建, Start启动, Stop停止
+type App struct {
+	conf       AppConfig
+	db         *gorm.DB
+	rdb        *gorm.DB
+	store      store.CandleStore
+	retention  *store.Retention
+	audit      *audit.Job
+	monitor    *monitor.Monitor
+	chain      *chain.Chain
+	checkpoint *chain.Checkpointer
+	settlement settlement.Store
+	settler    *settlement.Recorder
+	events     sink.EventPublisher
+	configLog  *configlog.Log
+	cache      *kline.RedisCache
+	source     Source
+	regulators map[string]*regulator.Regulator
+	dispatcher *sink.Dispatcher
+
+	tickQueue       *kline.Queue
+	klineQueueMap   map[string]*kline.Queue
+	persistQueueMap map[string]*kline.Queue
+	workerMap       map[string]*kline.Worker
+
+	clock     common.Clock
+	stopping  chan struct{} // Stop开始时关闭, 保存重试不再等待
+	quit      chan struct{}
+	routeWg   sync.WaitGroup
+	saveWg    sync.WaitGroup
+	persistWg sync.WaitGroup
+	reloadWg  sync.WaitGroup
+	jobWg     sync.WaitGroup
+}
+
+// 创建应用及其依赖的外部资源, 失败时释放已创建的资源
+func NewApp(conf AppConfig, deps Dependencies) (app *App, err error) {
+	a := &App{
+		conf:            conf,
+		dispatcher:      sink.NewDispatcher(),
+		klineQueueMap:   make(map[string]*kline.Queue, len(conf.CoinTypes)),
+		persistQueueMap: make(map[string]*kline.Queue, len(conf.CoinTypes)),
+		workerMap:       make(map[string]*kline.Worker, len(conf.CoinTypes)),
+		stopping:        make(chan struct{}),
+		quit:            make(chan struct{}),
+	}
+	defer func() {
+		if err != nil {
+			a.close()
+		}
+	}()
+	if a.db, err = deps.NewDB(); err != nil {
+		return nil, err
+	}
+	// 表结构版本与代码不一致时拒绝启动
+	if a.db != nil {
+		if err = migrations.Check(a.db); err != nil {
+			return nil, err
+		}
+	}
+	if deps.NewRDB != nil {
+		if a.rdb, err = deps.NewRDB(); err != nil {
+			return nil, err
+		}
+	}
+	if a.store, err = deps.NewStore(a.db); err != nil {
+		return nil, err
+	}
+	if deps.NewCache != nil {
+		if a.cache, err = deps.NewCache(); err != nil {
+			return nil, err
+		}
+	}
+	err = a.dispatcher.AddFromConfig(conf.CoinTypes, func(name, coinType string) (sink.Sink, error) {
+		return deps.NewSink(name, coinType, a.cache)
+	})
+	if err != nil {
+		return nil, err
+	}
+	// redis推送目标推送前写入缓存, 未配置redis推送目标的币种单独写入缓存
+	if a.cache != nil {
+		for _, coinType := range conf.CoinTypes {
+			if !common.IsInList(sink.SinkRedis, common.GetPublishSinks(coinType)) {
+				a.dispatcher.Add(coinType, sink.NewRedisCacheSink(a.cache))
+			}
+		}
+	}
+
+	clock := deps.Clock
+	if clock == nil {
+		clock = common.RealClock
+	}
+	a.clock = clock
+	if conf.RetentionInterval > 0 {
+		if a.retention, err = store.NewRetention(a.store, conf.CoinTypes, conf.Retention, clock); err != nil {
+			return nil, err
+		}
+	}
+	if conf.AuditInterval > 0 {
+		if a.audit, err = audit.NewJob(a.store, conf.CoinTypes, conf.Audit, clock); err != nil {
+			return nil, err
+		}
+	}
+	if conf.MonitorEnabled {
+		a.monitor = monitor.New(conf.Monitor, clock)
+	}
+	if deps.NewEvents != nil {
+		if a.events, err = deps.NewEvents(); err != nil {
+			return nil, err
+		}
+	}
+	a.configLog = configlog.New(configlog.NewStoreFor(a.store), a.events, clock)
+	a.settlement = settlement.NewStoreFor(a.store)
+	if conf.SettlementEnabled {
+		a.settler = settlement.NewRecorder(a.settlement, conf.SettlementPeriod, clock)
+	}
+	if conf.ChainEnabled {
+		if a.chain, err = chain.NewChain(a.store, conf.CoinTypes); err != nil {
+			return nil, err
+		}
+		if conf.CheckpointInterval > 0 {
+			if a.checkpoint, err = chain.NewCheckpointer(a.chain, conf.Chain, clock); err != nil {
+				return nil, err
+			}
+		}
+	}
+	// 只为adjust模式的币种启动报价调节器
+	adjustCoinTypes := []string{}
+	for _, coinType := range conf.CoinTypes {
+		if conf.PriceMode(coinType) == common.PRICE_MODE_ADJUST {
+			adjustCoinTypes = append(adjustCoinTypes, coinType)
+		}
+	}
+	a.regulators = regulator.NewRegulatorMap(adjustCoinTypes, clock)
+	adjuster := kline.NewPriceAdjuster(a.regulators)
+	a.tickQueue = kline.NewQueue("tick")
+	for _, coinType := range conf.CoinTypes {
+		// passthrough模式不干预报价, 推送及保存的K线与行情源一致
+		var coinAdjuster kline.Adjuster
+		if conf.PriceMode(coinType) == common.PRICE_MODE_ADJUST {
+			coinAdjuster = adjuster
+		}
+		a.klineQueueMap[coinType] = kline.NewQueue("kline." + coinType)
+		a.workerMap[coinType] = kline.NewWorker(coinType, coinAdjuster, clock, a.klineQueueMap[coinType])
+		if conf.PipelineMode == common.PIPELINE_PARALLEL {
+			a.persistQueueMap[coinType] = kline.NewQueue("persist." + coinType)
+		}
+	}
+	a.source = deps.NewSource(clock)
+	return a, nil
+}
+
+// 启动处理协程及行情数据源
+func (a *App) Start() {
+	log.Infof("[App]pipeline mode: %s, coin types: %v", a.conf.PipelineMode, a.conf.CoinTypes)
+	for _, coinType := range a.conf.CoinTypes {
+		log.Infof("[App][%s]price mode: %s", coinType, a.conf.PriceMode(coinType))
+	}
+	if a.rdb != nil && a.conf.ReloadInterval > 0 {
+		// 启动时以数据库中的配置为基准, 与配置文件的差异不是配置变更; 与最后记录的值不同时记录停止期间的变更
+		logged, err := a.configLog.LastValues()
+		if err != nil {
+			log.Errorf("[App]failed to load the config log, changes made while stopped are not recorded: %s", err)
+		}
+		changes := common.SeedConfigFromDB(a.rdb, logged)
+		log.Infof("[App]loaded settings from option_setting as baseline, %d changed while stopped", len(changes))
+		if err := a.configLog.Record(changes); err != nil {
+			log.Errorf("[App]failed to record config changes, retry on reload: %s", err)
+		}
+		a.reloadWg.Add(1)
+		go a.ReloadConfigTask()
+	}
+	if a.retention != nil {
+		goTask(&a.jobWg, "RetentionTask", a.RetentionTask)
+	}
+	if a.audit != nil {
+		goTask(&a.jobWg, "AuditTask", a.AuditTask)
+	}
+	if a.checkpoint != nil {
+		goTask(&a.jobWg, "CheckpointTask", a.CheckpointTask)
+	}
+	// 1. wait msg and send it to sinks
+	for _, q := range a.persistQueueMap {
+		q := q
+		goTask(&a.persistWg, "PersistKlineTask", func() { a.PersistKlineTask(q) })
+	}
+	for _, q := range a.klineQueueMap {
+		q := q
+		goTask(&a.saveWg, "SaveKlineTask", func() { a.SaveKlineTask(q) })
+	}
+
+	// 2. deal kline data
+	for _, w := range a.workerMap {
+		go w.Run()
+	}
+	goTask(&a.routeWg, "RouteTickTask", a.RouteTickTask)
+
+	// 3. get kline
+	go a.source.Run(a.tickQueue)
+}
+
+// K线存储, 供导出接口等只读查询使用
+func (a *App) Store() store.CandleStore {
+	return a.store
+}
+
+// 配置变更记录存储, 供查询接口使用
+func (a *App) ConfigLogStore() configlog.Store {
+	return a.configLog.Store()
+}
+
+// 结算报价存储, 供查询接口使用
+func (a *App) SettlementStore() settlement.Store {
+	return a.settlement
+}
+
+// 停止行情数据源, 处理完已接收的数据后释放所有资源
+func (a *App) Stop() {
+	close(a.stopping)
+	a.source.Stop()
+	a.tickQueue.Close()
+	a.routeWg.Wait()
+	for _, w := range a.workerMap {
+		w.Stop()
+	}
+	for _, q := range a.klineQueueMap {
+		q.Close()
+	}
+	a.saveWg.Wait()
+	for _, q := range a.persistQueueMap {
+		q.Close()
+	}
+	a.persistWg.Wait()
+	close(a.quit)
+	a.reloadWg.Wait()
+	a.jobWg.Wait()
+	a.close()
+}
+
+// 释放外部资源
+func (a *App) close() {
+	a.dispatcher.Close()
+	for _, reg := range a.regulators {
+		reg.Stop()
+	}
+	if err := a.cache.Close(); err != nil {
+		log.Errorf("[App]failed to close redis cache: %s", err)
+	}
+	if a.events != nil {
+		if err := a.events.Close(); err != nil {
+			log.Errorf("[App]failed to close event publisher: %s", err)
+		}
+	}
+	if a.store != nil {
+		if err := a.store.Close(); err != nil {
+			log.Errorf("[App]failed to close kline store: %s", err)
+		}
+	}
+	for _, db := range []*gorm.DB{a.db, a.rdb} {
+		if db != nil {
+			if err := db.Close(); err != nil {
+				log.Errorf("[App]failed to close db: %s", err)
+			}
+		}
+	}
+}
+
+// 运行任务, panic后重新运行, 任务正常返回后退出
+func goTask(wg *sync.WaitGroup, fn string, task func()) {
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for !runTask(fn, task) {
+			time.Sleep(taskRestartDelay)
+		}
+	}()
+}
+
+func runTask(fn string, task func()) (done bool) {
+	defer common.CheckPanic(fn, nil)
+	task()
+	return true
+}
+
+// 定期从数据库加载可动态修改的配置, 启动时已加载
+func (a *App) ReloadConfigTask() {
+	defer a.reloadWg.Done()
+	ticker := time.NewTicker(a.conf.ReloadInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			a.ReloadConfig()
+		case <-a.quit:
+			return
+		}
+	}
+}
+
+// 从数据库加载可动态修改的配置, 记录与当前值相比生效的变更, 保存失败的变更在下次加载时重试
+func (a *App) ReloadConfig() {
+	if err := a.configLog.Record(common.LoadConfigFromDB(a.rdb)); err != nil {
+		log.Errorf("[App]failed to record config changes: %s", err)
+	}
+}
+
+// 定期执行保留策略: 聚合, 删除过期K线, 维护分区
+func (a *App) RetentionTask() {
+	ticker := time.NewTicker(a.conf.RetentionInterval)
+	defer ticker.Stop()
+	for {
+		a.retention.Run()
+		select {
+		case <-ticker.C:
+		case <-a.quit:
+			return
+		}
+	}
+}
+
+// 定期检查最近的K线数据
+func (a *App) AuditTask() {
+	ticker := time.NewTicker(a.conf.AuditInterval)
+	defer ticker.Stop()
+	for {
+		a.audit.Run()
+		select {
+		case <-ticker.C:
+		case <-a.quit:
+			return
+		}
+	}
+}
+
+// 定期写入哈希链检查点, 停止时写入最后一个
+func (a *App) CheckpointTask() {
+	ticker := time.NewTicker(a.conf.CheckpointInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			a.checkpoint.Run()
+		case <-a.quit:
+			a.checkpoint.Run()
+			return
+		}
+	}
+}
+
+// 将行情数据分发给各币种的处理协程
+func (a *App) RouteTickTask() {
+	fn := "RouteTickTask"
+	for klineData := range a.tickQueue.C() {
+		if w, ok := a.workerMap[klineData.CoinType]; ok {
+			// 处理协程会修改行情数据, 先由偏差监控记录原始报价
+			if a.monitor != nil {
+				a.monitor.ObserveTick(klineData)
+			}
+			if !w.Push(klineData) {
+				log.Errorf("[%s]worker queue is full, drop kline: %v", fn, klineData)
+			}
+		}
+	}
+}
+
+func (a *App) SaveKlineTask(klineQueue *kline.Queue) {
+	for klineData := range klineQueue.C() {
+		//klineData.Time = time.Now().Unix()
+		a.SaveKline(klineData)
+		// <1s, 防止时间不连续
+		//time.Sleep(900 * time.Millisecond)
+	}
+}
+
+// 检查偏差后保存并推送K线. 偏差过大且配置了阻止推送的K线不保存、不记录为结算报价, 也不推送
+func (a *App) SaveKline(klineData *kline.OptionKline) {
+	fn := "SaveKline"
+	if a.monitor != nil && !a.monitor.Check(klineData) {
+		publishMetrics.Add(klineData.CoinType+".blocked", 1)
+		return
+	}
+	if a.conf.PipelineMode == common.PIPELINE_PARALLEL {
+		// 推送不等待数据库保存, 数据库由PersistKlineTask独立保存
+		a.PublishKline(klineData)
+		if persistQueue, ok := a.persistQueueMap[klineData.CoinType]; ok {
+			if !persistQueue.Push(klineData) {
+				log.Errorf("[%s]persist queue is full, drop kline: %v", fn, klineData)
+			}
+		}
+		return
+	}
+	if err := a.PersistKline(klineData); err != nil {
+		log.Errorf("Failed to save KLine to db, err: %s, data: %v", err, klineData)
+	} else {
+		a.PublishKline(klineData)
+	}
+}
+
+// 保存K线到存储, 并记录保存延迟. 开启哈希链时保存成功后才更新链头, 开奖时刻的K线记录为结算报价
+func (a *App) PersistKline(klineData *kline.OptionKline) error {
+	row := klineData
+	if a.chain != nil {
+		// parallel模式下推送目标可能仍在读取该K线, 在副本上计算哈希
+		linked := *klineData
+		a.chain.Link(&linked)
+		row = &linked
+	}
+	if err := a.store.Append(kline.Interval1s, row); err != nil {
+		persistMetrics.Add(klineData.CoinType+".failed", 1)
+		return err
+	}
+	if a.chain != nil {
+		a.chain.Commit(row)
+	}
+	if a.settler != nil {
+		a.settler.Record(row)
+	}
+	persistMetrics.Add(klineData.CoinType+".saved", 1)
+	common.SetMetric(persistMetrics, klineData.CoinType+".last_time", klineData.Time)
+	common.SetMetric(persistMetrics, klineData.CoinType+".lag", a.clock.Now().Unix()-klineData.Time)
+	return nil
+}
+
+// 推送K线, 调用前已检查偏差. redis缓存由推送目标写入, 不阻塞调用方
+func (a *App) PublishKline(klineData *kline.OptionKline) {
+	a.dispatcher.Publish(klineData)
+	publishMetrics.Add(klineData.CoinType+".published", 1)
+	common.SetMetric(publishMetrics, klineData.CoinType+".last_time", klineData.Time)
+}
+
+// parallel模式下独立保存K线, 数据库异常时重试, 不影响推送
+func (a *App) PersistKlineTask(persistQueue *kline.Queue) {
+	fn := "PersistKlineTask"
+	for klineData := range persistQueue.C() {
+		common.SetMetric(persistMetrics, klineData.CoinType+".queue", int64(persistQueue.Len()))
+		for retry := 0; ; retry++ {
+			err := a.PersistKline(klineData)
+			if err == nil {
+				break
+			}
+			if retry >= a.conf.PersistRetry {
+				log.Errorf("[%s]Failed to save KLine to db after %d retries, err: %s, data: %v", fn, retry, err, klineData)
+				break
+			}
+			log.Errorf("[%s]Failed to save KLine to db, retry: %d, err: %s, data: %v", fn, retry, err, klineData)
+			select {
+			case <-a.stopping:
+				log.Errorf("[%s]Stopping, give up saving KLine, err: %s, data: %v", fn, err, klineData)
+			case <-a.clock.After(time.Second):
+				continue
+			}
+			break
+		}
+	}
+}
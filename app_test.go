@@ -0,0 +1,576 @@
+package main
+
+import (
+	"fmt"
+	"github.com/gomodule/redigo/redis"
+	"github.com/jinzhu/gorm"
+	_ "github.com/jinzhu/gorm/dialects/sqlite"
+	"io/ioutil"
+	"option-kline/chain"
+	"option-kline/common"
+	"option-kline/configlog"
+	"option-kline/forex"
+	"option-kline/forex/simulator"
+	"option-kline/kline"
+	"option-kline/migrations"
+	"option-kline/monitor"
+	"option-kline/settlement"
+	"option-kline/sink"
+	"option-kline/store"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// 端到端测试环境: 模拟行情服务器 -> forex客户端 -> 处理协程 -> sqlite -> 内存推送目标
+type harness struct {
+	app   *App
+	db    *gorm.DB
+	sim   *simulator.Simulator
+	mq    *sink.MemorySink // 代替rabbitmq
+	clock *common.ReplayClock
+}
+
+// 以sqlite临时文件作为数据库, 服务停止时会关闭其连接, 测试使用独立的连接检查数据
+func newTestDB(t *testing.T) (db *gorm.DB, open func() (*gorm.DB, error)) {
+	dir, err := ioutil.TempDir("", "kline-e2e")
+	if err != nil {
+		t.Fatal(err)
+	}
+	open = func() (*gorm.DB, error) {
+		db, err := gorm.Open("sqlite3", dir+"/kline.db")
+		if err != nil {
+			return nil, err
+		}
+		db.SingularTable(true)
+		return db, nil
+	}
+	if db, err = open(); err != nil {
+		t.Fatalf("failed to open sqlite: %s", err)
+	}
+	t.Cleanup(func() {
+		db.Close()
+		os.RemoveAll(dir)
+	})
+	if _, err := migrations.Up(db); err != nil {
+		t.Fatalf("failed to migrate: %s", err)
+	}
+	return db, open
+}
+
+// 按回放文件格式写入脚本化的行情: 货币对,时间(unix秒),价格
+func writeTicks(t *testing.T, lines []string) string {
+	f, err := ioutil.TempFile("", "ticks-*.csv")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	f.WriteString(strings.Join(lines, "\n"))
+	t.Cleanup(func() { os.Remove(f.Name()) })
+	return f.Name()
+}
+
+func startHarness(t *testing.T, pipelineMode string, ticks []string) *harness {
+	simConf := simulator.DefaultConfig()
+	simConf.Addr = "127.0.0.1:0"
+	simConf.Interval = 50 * time.Millisecond
+	simConf.Precision = 2
+	simConf.ReplayFile = writeTicks(t, ticks)
+	sim, err := simulator.New(simConf)
+	if err != nil {
+		t.Fatalf("failed to create simulator: %s", err)
+	}
+	if err := sim.Start(); err != nil {
+		t.Fatalf("failed to start simulator: %s", err)
+	}
+
+	db, openDB := newTestDB(t)
+	h := &harness{
+		db:    db,
+		sim:   sim,
+		mq:    sink.NewMemorySink(),
+		clock: common.NewReplayClock(time.Time{}),
+	}
+	deps := Dependencies{
+		NewDB: openDB,
+		NewStore: func(db *gorm.DB) (store.CandleStore, error) {
+			return store.NewSQLStore(db), nil
+		},
+		NewSink: func(name, coinType string, cache *kline.RedisCache) (sink.Sink, error) {
+			if name != sink.SinkRabbitMq {
+				return nil, fmt.Errorf("unexpected sink: %s", name)
+			}
+			return h.mq, nil
+		},
+		NewSource: func(clock common.Clock) Source {
+			return forex.NewClient(sim.Addr(), clock)
+		},
+		Clock: h.clock,
+	}
+	// passthrough模式下推送的报价与行情源一致, 不允许任何偏差
+	conf := AppConfig{
+		CoinTypes:      []string{"GT", "USDT"},
+		PipelineMode:   pipelineMode,
+		MonitorEnabled: true,
+		Monitor:        monitor.Config{Tolerance: 0, Block: true, Window: 10 * time.Second},
+		ChainEnabled:   true,
+		// 缩短每期时长, 测试期间产生多期结算报价
+		SettlementEnabled: true,
+		SettlementPeriod:  5,
+	}
+	if h.app, err = NewApp(conf, deps); err != nil {
+		t.Fatalf("failed to create app: %s", err)
+	}
+	h.app.Start()
+	return h
+}
+
+// 停止服务, 返回数据库中各币种的K线
+func (h *harness) stop(t *testing.T) map[string][]*kline.OptionKline {
+	h.app.Stop()
+	h.sim.Close()
+	klineList := []*kline.OptionKline{}
+	if err := h.db.Order("time").Find(&klineList).Error; err != nil {
+		t.Fatalf("failed to query klines: %s", err)
+	}
+	klineMap := make(map[string][]*kline.OptionKline, 2)
+	for _, k := range klineList {
+		klineMap[k.CoinType] = append(klineMap[k.CoinType], k)
+	}
+	return klineMap
+}
+
+// 检查每个币种每秒有且只有一根K线, 且OHLC合法
+func checkCandles(t *testing.T, coinType string, klineList []*kline.OptionKline, begin, end int64) {
+	if len(klineList) != int(end-begin+1) {
+		t.Fatalf("[%s]there should be %d klines, actual: %d", coinType, end-begin+1, len(klineList))
+	}
+	for idx, k := range klineList {
+		if k.Time != begin+int64(idx) {
+			t.Errorf("[%s]unexpected kline time at %d: %s", coinType, idx, k)
+		}
+		open, _ := strconv.ParseFloat(k.Open, 64)
+		high, _ := strconv.ParseFloat(k.High, 64)
+		low, _ := strconv.ParseFloat(k.Low, 64)
+		if open <= 0 || high < open || low > open {
+			t.Errorf("[%s]invalid OHLC: %s", coinType, k)
+		}
+	}
+}
+
+func TestAppEndToEnd(t *testing.T) {
+	begin := int64(1546065000)
+	ticks := []string{}
+	for i := int64(0); i < 10; i++ {
+		ticks = append(ticks,
+			fmt.Sprintf("XAUUSD,%d,%.2f", begin+i, 1280+float64(i)/10),
+			fmt.Sprintf("USDCNH,%d,%.2f", begin+i, 6.88),
+			// 不支持的货币对不会被处理
+			fmt.Sprintf("EURUSD,%d,1.13", begin+i),
+		)
+	}
+
+	for _, mode := range []string{common.PIPELINE_SERIAL, common.PIPELINE_PARALLEL} {
+		t.Run(mode, func(t *testing.T) {
+			h := startHarness(t, mode, ticks)
+			if !h.mq.Wait(20, 5*time.Second) {
+				t.Fatalf("all klines should be published, actual: %d", len(h.mq.Klines()))
+			}
+			if now := h.clock.Now().Unix(); now != begin+9 {
+				t.Errorf("replay clock should follow tick time, expected: %d, actual: %d", begin+9, now)
+			}
+			klineMap := h.stop(t)
+
+			if len(klineMap) != 2 {
+				t.Errorf("only supported coin types should be persisted, actual: %v", klineMap)
+			}
+			for _, coinType := range []string{"GT", "USDT"} {
+				checkCandles(t, coinType, klineMap[coinType], begin, begin+9)
+			}
+			// 默认passthrough模式, 保存的K线为行情源的原始报价(补充的K线为上一秒的报价)
+			for _, k := range klineMap["GT"] {
+				open, _ := strconv.ParseFloat(k.Open, 64)
+				expected := 1280 + float64(k.Time-begin)/10
+				if k.Origin == 0 {
+					expected -= 0.1
+				}
+				if fmt.Sprintf("%.2f", open) != fmt.Sprintf("%.2f", expected) || k.High != k.Open || k.Low != k.Open || k.Close != k.Open {
+					t.Errorf("passthrough kline should equal source price %.2f: %s", expected, k)
+				}
+				if k.RawOpen != k.Open || k.RawClose != k.Close || k.Source != kline.SourceForex || k.VendorTime == 0 {
+					t.Errorf("raw price and provenance should be persisted: %+v", k)
+				}
+			}
+			// 保存的K线构成完整的哈希链
+			for _, coinType := range []string{"GT", "USDT"} {
+				summary, err := chain.Verify(store.NewSQLStore(h.db), coinType, begin, begin+10, nil, nil)
+				if err != nil || summary.Unchained != 0 || len(summary.Issues) != 0 {
+					t.Errorf("[%s]hash chain should verify: %+v, %v", coinType, summary, err)
+				}
+			}
+			// 每期开奖时刻记录保存的K线及行情源证据
+			prices, err := settlement.NewSQLStore(h.db).Range("GT", begin, begin+10)
+			if err != nil || len(prices) != 2 || prices[0].IssueTime != begin || prices[1].IssueTime != begin+5 {
+				t.Fatalf("settlement price should be recorded at each issue time: %v, %v", prices, err)
+			}
+			for _, p := range prices {
+				k := klineMap["GT"][p.IssueTime-begin]
+				if p.Price != k.Open || p.RawPrice != k.RawOpen || p.VendorTime != k.VendorTime || p.CandleHash != k.Hash || p.TickIds == "" {
+					t.Errorf("settlement price should match the persisted kline: %+v, %+v", p, k)
+				}
+			}
+			// 推送的K线与保存的K线一致
+			published := make(map[string]string, 20)
+			for _, k := range h.mq.Klines() {
+				published[fmt.Sprintf("%s.%d", k.CoinType, k.Time)] = k.Open
+			}
+			for coinType, klineList := range klineMap {
+				for _, k := range klineList {
+					if open, ok := published[fmt.Sprintf("%s.%d", coinType, k.Time)]; !ok || open != k.Open {
+						t.Errorf("persisted kline was not published: %s", k)
+					}
+				}
+			}
+		})
+	}
+}
+
+// 保存总是失败的存储, 用于测试重试
+type failingStore struct {
+	store.CandleStore
+	mutex   sync.Mutex
+	appends int
+}
+
+func (s *failingStore) Append(interval kline.Interval, klineList ...*kline.OptionKline) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.appends++
+	return fmt.Errorf("db is down")
+}
+
+func (s *failingStore) count() int {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.appends
+}
+
+// 保存失败时按时钟间隔重试, 停止时不再等待
+func TestPersistRetryStopsOnQuit(t *testing.T) {
+	s := &failingStore{}
+	clock := common.NewFakeClock(time.Unix(1546065000, 0))
+	a := &App{
+		conf:     AppConfig{PersistRetry: 30},
+		store:    s,
+		clock:    clock,
+		stopping: make(chan struct{}),
+	}
+	q := kline.NewQueueWithPolicy("persist.test", common.QUEUE_POLICY_BLOCK, 10, 0)
+	for i := int64(0); i < 3; i++ {
+		q.Push(&kline.OptionKline{CoinType: "GT", Time: 1546065000 + i, Open: "1", High: "1", Low: "1", Close: "1"})
+	}
+	q.Close()
+	done := make(chan struct{})
+	go func() {
+		a.PersistKlineTask(q)
+		close(done)
+	}()
+
+	waitFor := func(n int) {
+		deadline := time.Now().Add(2 * time.Second)
+		for s.count() < n && time.Now().Before(deadline) {
+			time.Sleep(5 * time.Millisecond)
+		}
+		if s.count() != n {
+			t.Fatalf("expected %d appends, actual: %d", n, s.count())
+		}
+	}
+	waitFor(1)
+	clock.Advance(time.Second)
+	waitFor(2)
+
+	close(a.stopping)
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("persist task should not wait for retries after stop")
+	}
+	// 停止后剩余的K线各尝试一次
+	if s.count() != 4 {
+		t.Errorf("expected 4 appends, actual: %d", s.count())
+	}
+}
+
+// passthrough模式的币种不创建报价调节器
+func TestRegulatorsOnlyForAdjustMode(t *testing.T) {
+	deps := Dependencies{
+		NewDB: func() (*gorm.DB, error) { return nil, nil },
+		NewStore: func(db *gorm.DB) (store.CandleStore, error) {
+			return store.NewMemoryStore(), nil
+		},
+		NewSink: func(name, coinType string, cache *kline.RedisCache) (sink.Sink, error) {
+			return sink.NewMemorySink(), nil
+		},
+		NewSource: func(clock common.Clock) Source {
+			return forex.NewClient("127.0.0.1:0", clock)
+		},
+	}
+	conf := AppConfig{
+		CoinTypes:  []string{"GT", "USDT", "BTC"},
+		PriceModes: map[string]string{"GT": common.PRICE_MODE_ADJUST},
+	}
+	app, err := NewApp(conf, deps)
+	if err != nil {
+		t.Fatalf("failed to create app: %s", err)
+	}
+	defer app.close()
+	if _, ok := app.regulators["GT"]; !ok || len(app.regulators) != 1 {
+		t.Errorf("only adjust mode coin types should have regulators: %v", app.regulators)
+	}
+}
+
+// 重启时数据库中的配置作为基准, 不记录为变更; 运行期间的修改才记录
+func TestReloadConfigBaseline(t *testing.T) {
+	db, openDB := newTestDB(t)
+	if err := db.Create(&common.OptionSetting{KeyName: "kline_sample_number", Value: "200", UpdateTime: 1000}).Error; err != nil {
+		t.Fatal(err)
+	}
+	sampleNum := common.KlineSampleNum.Load()
+	defer common.KlineSampleNum.Store(sampleNum)
+	events := sink.NewMemorySink()
+	newApp := func() *App {
+		deps := Dependencies{
+			NewDB:  openDB,
+			NewRDB: openDB,
+			NewStore: func(db *gorm.DB) (store.CandleStore, error) {
+				return store.NewSQLStore(db), nil
+			},
+			NewSink: func(name, coinType string, cache *kline.RedisCache) (sink.Sink, error) {
+				return sink.NewMemorySink(), nil
+			},
+			NewSource: func(clock common.Clock) Source {
+				return forex.NewClient("127.0.0.1:1", clock)
+			},
+			NewEvents: func() (sink.EventPublisher, error) { return events, nil },
+		}
+		app, err := NewApp(AppConfig{CoinTypes: []string{"GT"}, ReloadInterval: time.Hour}, deps)
+		if err != nil {
+			t.Fatalf("failed to create app: %s", err)
+		}
+		return app
+	}
+	entries := func() []*configlog.Entry {
+		list, err := configlog.NewSQLStore(db).List("", 0, 1<<40, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return list
+	}
+
+	for i := 0; i < 2; i++ {
+		// 重启后配置恢复为配置文件中的值
+		common.KlineSampleNum.Store(300)
+		app := newApp()
+		app.Start()
+		if n := common.KlineSampleNum.Load().(int); n != 200 {
+			t.Errorf("setting in db should be loaded at start, actual: %d", n)
+		}
+		app.Stop()
+	}
+	if list := entries(); len(list) != 0 || len(events.Events()) != 0 {
+		t.Fatalf("restarts should not record config changes: %+v, %+v", list, events.Events())
+	}
+
+	common.KlineSampleNum.Store(300)
+	app := newApp()
+	app.Start()
+	if err := db.Table("option_setting").Where("keyName = ?", "kline_sample_number").
+		Updates(map[string]interface{}{"value": "250", "updateTime": 2000}).Error; err != nil {
+		t.Fatal(err)
+	}
+	app.ReloadConfig()
+	app.Stop()
+	if list := entries(); len(list) != 1 || list[0].OldValue != "200" || list[0].NewValue != "250" || len(events.Events()) != 1 {
+		t.Errorf("change after start should be recorded: %+v", list)
+	}
+
+	// 停止期间的变更在启动时以最后记录的值为旧值记录
+	if err := db.Table("option_setting").Where("keyName = ?", "kline_sample_number").
+		Updates(map[string]interface{}{"value": "280", "updateTime": 3000}).Error; err != nil {
+		t.Fatal(err)
+	}
+	common.KlineSampleNum.Store(300)
+	app = newApp()
+	app.Start()
+	app.Stop()
+	list := entries()
+	if len(list) != 2 || list[1].OldValue != "250" || list[1].NewValue != "280" || list[1].UpdateTime != 3000 || len(events.Events()) != 2 {
+		t.Errorf("change while stopped should be recorded at start: %+v", list)
+	}
+	if err := configlog.Verify(configlog.NewSQLStore(db)); err != nil {
+		t.Errorf("config log should be chained: %s", err)
+	}
+}
+
+// 偏差过大被阻止推送的K线不保存, 也不作为开奖时刻的结算报价
+func TestBlockedKlineNotSettled(t *testing.T) {
+	for _, mode := range []string{common.PIPELINE_SERIAL, common.PIPELINE_PARALLEL} {
+		mq := sink.NewMemorySink()
+		clock := common.NewFakeClock(time.Unix(1000, 0))
+		deps := Dependencies{
+			NewDB: func() (*gorm.DB, error) { return nil, nil },
+			NewStore: func(db *gorm.DB) (store.CandleStore, error) {
+				return store.NewMemoryStore(), nil
+			},
+			NewSink: func(name, coinType string, cache *kline.RedisCache) (sink.Sink, error) {
+				return mq, nil
+			},
+			NewSource: func(clock common.Clock) Source {
+				return forex.NewClient("127.0.0.1:0", clock)
+			},
+			Clock: clock,
+		}
+		conf := AppConfig{
+			CoinTypes:         []string{"GT"},
+			PipelineMode:      mode,
+			MonitorEnabled:    true,
+			Monitor:           monitor.Config{Tolerance: 0.1, Block: true, Window: 10 * time.Second},
+			SettlementEnabled: true,
+			SettlementPeriod:  5,
+		}
+		app, err := NewApp(conf, deps)
+		if err != nil {
+			t.Fatalf("failed to create app: %s", err)
+		}
+		candle := func(tm int64, price string) *kline.OptionKline {
+			return &kline.OptionKline{CoinType: "GT", Time: tm, Open: price, High: price, Low: price, Close: price, Origin: 1}
+		}
+		app.monitor.ObserveTick(candle(1000, "100"))
+		app.SaveKline(candle(1000, "105"))
+
+		prices, err := app.SettlementStore().Range("GT", 0, 2000)
+		if err != nil || len(prices) != 0 {
+			t.Errorf("[%s]blocked kline should not be settled: %v, %v", mode, prices, err)
+		}
+		if klineList, _ := app.Store().Range(kline.Interval1s, "GT", 0, 2000); len(klineList) != 0 {
+			t.Errorf("[%s]blocked kline should not be saved: %v", mode, klineList)
+		}
+		if q := app.persistQueueMap["GT"]; q != nil && q.Len() != 0 {
+			t.Errorf("[%s]blocked kline should not be queued for saving", mode)
+		}
+		if mode == common.PIPELINE_SERIAL {
+			app.SaveKline(candle(1005, "100"))
+			if prices, _ = app.SettlementStore().Range("GT", 0, 2000); len(prices) != 1 || prices[0].IssueTime != 1005 {
+				t.Errorf("[%s]kline within tolerance should be settled: %v", mode, prices)
+			}
+		}
+		app.close()
+		for _, k := range mq.Klines() {
+			if k.Time == 1000 {
+				t.Errorf("[%s]blocked kline should not be published: %v", mode, k)
+			}
+		}
+	}
+}
+
+// redis缓慢时保存及推送K线不被阻塞
+func TestSlowCacheDoesNotBlockSave(t *testing.T) {
+	unblock := make(chan struct{})
+	pool := &redis.Pool{Dial: func() (redis.Conn, error) {
+		<-unblock
+		return nil, fmt.Errorf("redis unavailable")
+	}}
+	mq := sink.NewMemorySink()
+	deps := Dependencies{
+		NewDB: func() (*gorm.DB, error) { return nil, nil },
+		NewStore: func(db *gorm.DB) (store.CandleStore, error) {
+			return store.NewMemoryStore(), nil
+		},
+		NewCache: func() (*kline.RedisCache, error) {
+			return kline.NewRedisCache(pool, 10, []kline.Interval{kline.Interval1m}, "kline"), nil
+		},
+		NewSink: func(name, coinType string, cache *kline.RedisCache) (sink.Sink, error) {
+			return mq, nil
+		},
+		NewSource: func(clock common.Clock) Source {
+			return forex.NewClient("127.0.0.1:0", clock)
+		},
+	}
+	app, err := NewApp(AppConfig{CoinTypes: []string{"GT"}, PipelineMode: common.PIPELINE_SERIAL}, deps)
+	if err != nil {
+		t.Fatalf("failed to create app: %s", err)
+	}
+	done := make(chan struct{})
+	go func() {
+		for tm := int64(1000); tm < 1010; tm++ {
+			app.SaveKline(&kline.OptionKline{CoinType: "GT", Time: tm, Open: "1", High: "1", Low: "1", Close: "1"})
+		}
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(3 * time.Second):
+		t.Error("saving klines should not wait for redis")
+	}
+	close(unblock)
+	<-done
+	app.close()
+	if klineList, _ := app.Store().Range(kline.Interval1s, "GT", 0, 2000); len(klineList) != 10 {
+		t.Errorf("klines should be saved, actual: %d", len(klineList))
+	}
+}
+
+// 只读命令不创建数据库也不执行表结构变更, 版本不符时失败
+func TestOpenReadOnlyStore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "kline-readonly")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	driver, path := common.StoreDriver, common.StoreSQLitePath
+	defer func() { common.StoreDriver, common.StoreSQLitePath = driver, path }()
+	common.StoreDriver, common.StoreSQLitePath = common.STORE_SQLITE, dir+"/kline.db"
+
+	if _, _, err := openReadOnlyStore(); err == nil {
+		t.Error("missing database should fail")
+	}
+	if _, err := os.Stat(common.StoreSQLitePath); !os.IsNotExist(err) {
+		t.Errorf("database should not be created: %v", err)
+	}
+
+	db, err := store.OpenSQLite(common.StoreSQLitePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	if _, err := migrations.Up(db); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := migrations.Down(db, 1); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := openReadOnlyStore(); err == nil || !strings.Contains(err.Error(), "schema version mismatch") {
+		t.Errorf("outdated schema should fail, err: %v", err)
+	}
+	if current, _ := migrations.Current(db); current != migrations.Latest()-1 {
+		t.Errorf("schema should not be migrated, actual version: %d", current)
+	}
+
+	if _, err := migrations.Up(db); err != nil {
+		t.Fatal(err)
+	}
+	s, closeStore, err := openReadOnlyStore()
+	if err != nil {
+		t.Fatalf("failed to open store: %s", err)
+	}
+	defer closeStore()
+	if _, err := s.Range(kline.Interval1s, "GT", 0, 1<<40); err != nil {
+		t.Errorf("failed to read: %s", err)
+	}
+	if err := s.Append(kline.Interval1s, &kline.OptionKline{CoinType: "GT", Time: 100}); err == nil {
+		t.Error("read-only store should not be writable")
+	}
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"option-kline/common"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+const modulePath = "option-kline"
+
+// App的装配代码, 其引用的本项目包即报价链路: 接收行情、生成K线、报价干预、保存、推送及开奖价记录
+const appFile = "app.go"
+
+// 只包含建表语句, 允许出现option_order
+var ddlPackages = map[string]bool{"migrations": true}
+
+// 报价链路及其依赖的本项目包都不能引用订单数据
+func TestPricePathIndependentOfOrders(t *testing.T) {
+	roots := checkFile(t, appFile, nil, false)
+	for _, dir := range []string{"forex", "kline", "regulator", "sink", "store"} {
+		if !common.IsInList(dir, roots) {
+			t.Fatalf("%s should be wired by %s, actual: %v", dir, appFile, roots)
+		}
+	}
+	visited := map[string]bool{}
+	queue := append([]string{}, roots...)
+	for len(queue) > 0 {
+		dir := queue[0]
+		queue = queue[1:]
+		if visited[dir] {
+			continue
+		}
+		visited[dir] = true
+		queue = append(queue, checkPackage(t, dir)...)
+	}
+
+	var checked []string
+	for dir := range visited {
+		checked = append(checked, dir)
+	}
+	sort.Strings(checked)
+	t.Logf("checked packages: %s", strings.Join(checked, ", "))
+}
+
+// 直接查询option_order及引用OptionOrder同样会被发现, 建表语句除外
+func TestPricePathCheckFindsOrderQueries(t *testing.T) {
+	src := `package kline
+
+func countOrders(db *gorm.DB) {
+	db.Raw("SELECT count(*) FROM option_order WHERE status = 0")
+	var o OptionOrder
+	_ = o
+}
+`
+	for _, c := range []struct {
+		ddl      bool
+		problems int
+	}{{false, 2}, {true, 1}} {
+		problems := orderProblems(t, "kline/fixture.go", src, c.ddl)
+		if len(problems) != c.problems {
+			t.Errorf("ddl: %v, expected %d problems, actual: %v", c.ddl, c.problems, problems)
+		}
+	}
+}
+
+// 检查包内非测试文件, 返回引用的本项目包目录
+func checkPackage(t *testing.T, dir string) []string {
+	files, err := filepath.Glob(filepath.Join(filepath.FromSlash(dir), "*.go"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) == 0 {
+		t.Fatalf("package %s not found", dir)
+	}
+	var imports []string
+	for _, file := range files {
+		if !strings.HasSuffix(file, "_test.go") {
+			imports = append(imports, checkFile(t, file, nil, ddlPackages[dir])...)
+		}
+	}
+	return imports
+}
+
+// 检查文件, 返回引用的本项目包目录; src为nil时读取文件
+func checkFile(t *testing.T, file string, src interface{}, ddl bool) []string {
+	for _, problem := range orderProblems(t, file, src, ddl) {
+		t.Error(problem)
+	}
+	fset := token.NewFileSet()
+	f, err := parser.ParseFile(fset, file, src, parser.ImportsOnly)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var imports []string
+	for _, spec := range f.Imports {
+		path, _ := strconv.Unquote(spec.Path.Value)
+		if strings.HasPrefix(path, modulePath+"/") {
+			imports = append(imports, strings.TrimPrefix(path, modulePath+"/"))
+		}
+	}
+	return imports
+}
+
+// 引用订单数据之处: 引用订单包, 引用OptionOrder, 字符串中出现option_order(ddl为true时允许)
+func orderProblems(t *testing.T, file string, src interface{}, ddl bool) []string {
+	fset := token.NewFileSet()
+	f, err := parser.ParseFile(fset, file, src, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var problems []string
+	for _, spec := range f.Imports {
+		if path, _ := strconv.Unquote(spec.Path.Value); path == modulePath+"/order" {
+			problems = append(problems, fmt.Sprintf("%s: imports %s", fset.Position(spec.Pos()), path))
+		}
+	}
+	ast.Inspect(f, func(node ast.Node) bool {
+		switch n := node.(type) {
+		case *ast.ImportSpec:
+			return false
+		case *ast.Ident:
+			if n.Name == "OptionOrder" {
+				problems = append(problems, fmt.Sprintf("%s: references OptionOrder", fset.Position(n.Pos())))
+			}
+		case *ast.BasicLit:
+			if n.Kind == token.STRING && !ddl && strings.Contains(n.Value, "option_order") {
+				problems = append(problems, fmt.Sprintf("%s: queries option_order: %s", fset.Position(n.Pos()), n.Value))
+			}
+		}
+		return true
+	})
+	return problems
+}
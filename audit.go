@@ -0,0 +1,78 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"option-kline/audit"
+	"option-kline/common"
+	"option-kline/kline"
+	"os"
+	"strings"
+)
+
+// 检查K线数据: option-kline audit -from 时间 -to 时间 [选项]
+func runAudit(args []string) error {
+	fs := flag.NewFlagSet("audit", flag.ContinueOnError)
+	from := fs.String("from", "", "begin time, unix seconds or RFC3339")
+	to := fs.String("to", "", "end time (exclusive), unix seconds or RFC3339")
+	coins := fs.String("coin", "", "coin types, comma separated, default: all supported")
+	intervals := fs.String("interval", kline.Interval1s.Name, "kline intervals, comma separated")
+	maxSynthetic := fs.String("max-synthetic", "60s", "max duration of synthetic-fill runs (s/m/h/d), 0s to skip")
+	format := fs.String("format", "json", "report format: json, csv")
+	output := fs.String("o", "", "output file, default: stdout")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *from == "" || *to == "" {
+		fs.Usage()
+		return fmt.Errorf("usage: option-kline audit -from time -to time [options]")
+	}
+	if *format != "json" && *format != "csv" {
+		return fmt.Errorf("format not supported: %s", *format)
+	}
+
+	opts := audit.Options{CoinTypes: common.CoinSupported.Load().([]string)}
+	var err error
+	if opts.Begin, err = common.ParseTime(*from); err != nil {
+		return err
+	}
+	if opts.End, err = common.ParseTime(*to); err != nil {
+		return err
+	}
+	if *coins != "" {
+		opts.CoinTypes = strings.Split(strings.Replace(*coins, " ", "", -1), ",")
+	}
+	if opts.Intervals, err = kline.ParseIntervalList(strings.Split(*intervals, ",")); err != nil {
+		return err
+	}
+	if opts.MaxSynthetic, err = common.ParseSeconds(*maxSynthetic); err != nil {
+		return err
+	}
+
+	s, closeStore, err := openStore()
+	if err != nil {
+		return err
+	}
+	defer closeStore()
+	report, err := audit.Run(s, opts, common.RealClock)
+	if err != nil {
+		return err
+	}
+	out := os.Stdout
+	if *output != "" {
+		if out, err = os.Create(*output); err != nil {
+			return err
+		}
+		defer out.Close()
+	}
+	if *format == "csv" {
+		err = report.WriteCSV(out)
+	} else {
+		err = report.WriteJSON(out)
+	}
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "%d issues found\n", report.IssueCount())
+	return nil
+}
@@ -0,0 +1,376 @@
+package audit
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"io"
+	"option-kline/common"
+	"option-kline/kline"
+	"option-kline/store"
+	"os"
+	"path/filepath"
+	"strconv"
+)
+
+// 问题类型
+const (
+	IssueGap        = "gap"             // 缺失的K线
+	IssueDuplicate  = "duplicate"       // 相同币种及时间的多条K线
+	IssueOHLC       = "ohlc"            // 价格无效或高低价与开收盘价不一致
+	IssueSynthetic  = "synthetic_run"   // 连续补充(非原始数据)的K线超过上限
+	IssueRegression = "time_regression" // 行情时间(lastupdate)早于前一条K线
+)
+
+const (
+	scanRows  = 10000 // 每次查询的K线数量上限
+	maxIssues = 1000  // 每个币种每个周期最多记录的问题数量, 统计数量不受限制
+	saveDelay = 60    // 定期检查时等待K线保存完成的时间, 单位:秒
+)
+
+// 检查配置
+type Options struct {
+	CoinTypes    []string
+	Intervals    []kline.Interval
+	Begin        int64 // 检查[Begin, End)内的K线
+	End          int64
+	MaxSynthetic int64 // 连续补充K线的时长上限, 单位:秒, 只检查秒级K线, 0表示不检查
+}
+
+// 一个问题, [Begin, End)为问题涉及的时间范围
+type Issue struct {
+	Kind     string `json:"kind"`
+	CoinType string `json:"coinType"`
+	Interval string `json:"interval"`
+	Begin    int64  `json:"begin"`
+	End      int64  `json:"end"`
+	Count    int    `json:"count"`
+	Detail   string `json:"detail,omitempty"`
+}
+
+// 每个币种每个周期的统计
+type Summary struct {
+	CoinType      string `json:"coinType"`
+	Interval      string `json:"interval"`
+	Expected      int    `json:"expected"`      // 应有的K线数量
+	Found         int    `json:"found"`         // 读取的K线数量, 包括重复的K线
+	Missing       int    `json:"missing"`       // 缺失的K线数量
+	Duplicates    int    `json:"duplicates"`    // 重复的K线数量(不含第一条)
+	InvalidOHLC   int    `json:"invalidOHLC"`   // 价格不一致的K线数量
+	SyntheticRuns int    `json:"syntheticRuns"` // 超过上限的连续补充次数
+	Regressions   int    `json:"regressions"`   // 行情时间倒退的次数
+	Truncated     bool   `json:"truncated"`     // 问题数量超过上限, 只记录了前maxIssues个
+}
+
+// 问题总数
+func (s Summary) Issues() int {
+	return s.Missing + s.Duplicates + s.InvalidOHLC + s.SyntheticRuns + s.Regressions
+}
+
+// 检查报告
+type Report struct {
+	Begin       int64     `json:"begin"`
+	End         int64     `json:"end"`
+	GeneratedAt int64     `json:"generatedAt"`
+	Summaries   []Summary `json:"summaries"`
+	Issues      []Issue   `json:"issues"`
+}
+
+// 检查K线存储中的数据
+func Run(s store.CandleStore, opts Options, clock common.Clock) (*Report, error) {
+	if opts.Begin >= opts.End {
+		return nil, fmt.Errorf("invalid time range: [%d, %d)", opts.Begin, opts.End)
+	}
+	report := &Report{Begin: opts.Begin, End: opts.End, GeneratedAt: clock.Now().Unix(), Summaries: []Summary{}, Issues: []Issue{}}
+	for _, coinType := range opts.CoinTypes {
+		for _, interval := range opts.Intervals {
+			c := newChecker(coinType, interval, opts)
+			if err := c.scan(s); err != nil {
+				return nil, fmt.Errorf("failed to audit %s klines of %s: %s", interval, coinType, err)
+			}
+			report.Summaries = append(report.Summaries, c.summary)
+			report.Issues = append(report.Issues, c.issues...)
+		}
+	}
+	return report, nil
+}
+
+// 单个币种单个周期的检查状态, 跨查询窗口保持
+type checker struct {
+	interval kline.Interval
+	opts     Options
+	summary  Summary
+	issues   []Issue
+	next     int64              // 下一条K线应有的时间
+	prev     *kline.OptionKline // 上一条K线
+	dupCount int                // 当前时间的重复数量
+	synBegin int64              // 当前连续补充的开始时间
+	synCount int                // 当前连续补充的K线数量
+
+	checkSynthetic bool
+}
+
+func newChecker(coinType string, interval kline.Interval, opts Options) *checker {
+	first := interval.Begin(opts.Begin)
+	if first < opts.Begin {
+		first += interval.Seconds
+	}
+	return &checker{
+		interval:       interval,
+		opts:           opts,
+		summary:        Summary{CoinType: coinType, Interval: interval.Name},
+		next:           first,
+		checkSynthetic: opts.MaxSynthetic > 0 && interval.Name == kline.Interval1s.Name,
+	}
+}
+
+func (c *checker) addIssue(kind string, begin, end int64, count int, detail string) {
+	if len(c.issues) >= maxIssues {
+		c.summary.Truncated = true
+		return
+	}
+	c.issues = append(c.issues, Issue{Kind: kind, CoinType: c.summary.CoinType, Interval: c.summary.Interval,
+		Begin: begin, End: end, Count: count, Detail: detail})
+}
+
+func (c *checker) scan(s store.CandleStore) error {
+	window := scanRows * c.interval.Seconds
+	for begin := c.opts.Begin; begin < c.opts.End; begin += window {
+		end := begin + window
+		if end > c.opts.End {
+			end = c.opts.End
+		}
+		klineList, err := s.Range(c.interval, c.summary.CoinType, begin, end)
+		if err != nil {
+			return err
+		}
+		for _, k := range klineList {
+			c.check(k)
+		}
+	}
+	c.finish()
+	return nil
+}
+
+func (c *checker) check(k *kline.OptionKline) {
+	c.summary.Found++
+	sec := c.interval.Seconds
+	if c.prev != nil && k.Time == c.prev.Time {
+		c.dupCount++
+		c.summary.Duplicates++
+		c.checkOHLC(k)
+		return
+	}
+	c.flushDuplicates()
+
+	if k.Time > c.next {
+		missing := int((k.Time - c.next + sec - 1) / sec)
+		c.summary.Missing += missing
+		c.addIssue(IssueGap, c.next, k.Time, missing, "")
+		c.flushSynthetic()
+	}
+	if c.prev != nil && k.LastUpdate < c.prev.LastUpdate {
+		c.summary.Regressions++
+		c.addIssue(IssueRegression, c.prev.Time, k.Time+sec, 1,
+			fmt.Sprintf("lastupdate %d after %d", k.LastUpdate, c.prev.LastUpdate))
+	}
+	c.checkOHLC(k)
+
+	if c.checkSynthetic {
+		if k.Origin == 0 {
+			if c.synCount == 0 {
+				c.synBegin = k.Time
+			}
+			c.synCount++
+		} else {
+			c.flushSynthetic()
+		}
+	}
+	c.prev = k
+	c.next = k.Time - k.Time%sec + sec
+}
+
+func (c *checker) finish() {
+	c.flushDuplicates()
+	c.flushSynthetic()
+	sec := c.interval.Seconds
+	if c.next < c.opts.End {
+		missing := int((c.opts.End - c.next + sec - 1) / sec)
+		c.summary.Missing += missing
+		c.addIssue(IssueGap, c.next, c.opts.End, missing, "")
+	}
+	first := c.interval.Begin(c.opts.Begin)
+	if first < c.opts.Begin {
+		first += sec
+	}
+	if first < c.opts.End {
+		c.summary.Expected = int((c.opts.End - first + sec - 1) / sec)
+	}
+}
+
+func (c *checker) flushDuplicates() {
+	if c.dupCount > 0 {
+		c.addIssue(IssueDuplicate, c.prev.Time, c.prev.Time+c.interval.Seconds, c.dupCount+1, "")
+	}
+	c.dupCount = 0
+}
+
+func (c *checker) flushSynthetic() {
+	if c.synCount > 0 && int64(c.synCount) > c.opts.MaxSynthetic {
+		c.summary.SyntheticRuns++
+		c.addIssue(IssueSynthetic, c.synBegin, c.synBegin+int64(c.synCount), c.synCount, "")
+	}
+	c.synCount = 0
+}
+
+// 检查价格: 均为正数, 最高价不低于开收盘价及最低价, 最低价不高于开收盘价
+func (c *checker) checkOHLC(k *kline.OptionKline) {
+	prices := make([]float64, 4)
+	for idx, str := range []string{k.Open, k.High, k.Low, k.Close} {
+		price, err := strconv.ParseFloat(str, 64)
+		if err != nil || price <= 0 {
+			c.summary.InvalidOHLC++
+			c.addIssue(IssueOHLC, k.Time, k.Time+c.interval.Seconds, 1, fmt.Sprintf("invalid price %q", str))
+			return
+		}
+		prices[idx] = price
+	}
+	open, high, low, close := prices[0], prices[1], prices[2], prices[3]
+	if high < open || high < close || high < low || low > open || low > close {
+		c.summary.InvalidOHLC++
+		c.addIssue(IssueOHLC, k.Time, k.Time+c.interval.Seconds, 1,
+			fmt.Sprintf("open %s high %s low %s close %s", k.Open, k.High, k.Low, k.Close))
+	}
+}
+
+// 输出JSON格式的报告
+func (r *Report) WriteJSON(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(r)
+}
+
+// 输出CSV格式的报告, 每行一个问题
+func (r *Report) WriteCSV(w io.Writer) error {
+	cw := csv.NewWriter(w)
+	cw.Write([]string{"kind", "coinType", "interval", "begin", "end", "count", "detail"})
+	for _, issue := range r.Issues {
+		cw.Write([]string{issue.Kind, issue.CoinType, issue.Interval, strconv.FormatInt(issue.Begin, 10),
+			strconv.FormatInt(issue.End, 10), strconv.Itoa(issue.Count), issue.Detail})
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// 问题总数
+func (r *Report) IssueCount() int {
+	count := 0
+	for _, s := range r.Summaries {
+		count += s.Issues()
+	}
+	return count
+}
+
+// 更新运行指标: audit.{币种}.{周期}.{统计项}, 通过/debug/vars查看
+func (r *Report) UpdateMetrics() {
+	m := common.NewMetrics("audit")
+	for _, s := range r.Summaries {
+		prefix := s.CoinType + "." + s.Interval + "."
+		common.SetMetric(m, prefix+"missing", int64(s.Missing))
+		common.SetMetric(m, prefix+"duplicates", int64(s.Duplicates))
+		common.SetMetric(m, prefix+"invalid_ohlc", int64(s.InvalidOHLC))
+		common.SetMetric(m, prefix+"synthetic_runs", int64(s.SyntheticRuns))
+		common.SetMetric(m, prefix+"regressions", int64(s.Regressions))
+	}
+	common.SetMetric(m, "issues", int64(r.IssueCount()))
+	common.SetMetric(m, "last_run", r.GeneratedAt)
+}
+
+// 定期检查配置
+type JobConfig struct {
+	Window       int64    // 每次检查最近的时长, 单位:秒
+	Intervals    []string // 检查的周期
+	MaxSynthetic int64    // 连续补充K线的时长上限, 单位:秒
+	ReportDir    string   // 报告文件目录, 为空时不保存报告
+}
+
+// 根据已加载的配置生成定期检查配置
+func NewJobConfig() JobConfig {
+	return JobConfig{
+		Window:       common.AuditWindow,
+		Intervals:    common.AuditIntervals,
+		MaxSynthetic: common.AuditMaxSynthetic,
+		ReportDir:    common.AuditReportDir,
+	}
+}
+
+// 定期检查最近Window内的K线, 保存报告并更新运行指标
+type Job struct {
+	store     store.CandleStore
+	coinTypes []string
+	intervals []kline.Interval
+	conf      JobConfig
+	clock     common.Clock
+}
+
+func NewJob(s store.CandleStore, coinTypes []string, conf JobConfig, clock common.Clock) (*Job, error) {
+	intervals, err := kline.ParseIntervalList(conf.Intervals)
+	if err != nil {
+		return nil, fmt.Errorf("invalid audit intervals %v: %s", conf.Intervals, err)
+	}
+	if conf.Window <= 0 {
+		return nil, fmt.Errorf("invalid audit window: %d", conf.Window)
+	}
+	return &Job{store: s, coinTypes: coinTypes, intervals: intervals, conf: conf, clock: clock}, nil
+}
+
+// 执行一次检查, 检查范围的结束时间留出K线保存的延迟, 并对齐到检查的最大周期
+// 注: 分钟及以上周期的K线由保留任务聚合, 未开启保留任务时会报告为缺失
+func (j *Job) Run() {
+	fn := "audit.Job.Run"
+	end := j.clock.Now().Unix() - saveDelay
+	for _, interval := range j.intervals {
+		end = interval.Begin(end)
+	}
+	report, err := Run(j.store, Options{
+		CoinTypes:    j.coinTypes,
+		Intervals:    j.intervals,
+		Begin:        end - j.conf.Window,
+		End:          end,
+		MaxSynthetic: j.conf.MaxSynthetic,
+	}, j.clock)
+	if err != nil {
+		log.Errorf("[%s]%s", fn, err)
+		return
+	}
+	report.UpdateMetrics()
+	for _, s := range report.Summaries {
+		if s.Issues() > 0 {
+			log.Warnf("[%s]%s %s: missing %d, duplicates %d, invalid ohlc %d, synthetic runs %d, regressions %d",
+				fn, s.CoinType, s.Interval, s.Missing, s.Duplicates, s.InvalidOHLC, s.SyntheticRuns, s.Regressions)
+		}
+	}
+	if j.conf.ReportDir == "" {
+		return
+	}
+	if err := report.Save(j.conf.ReportDir); err != nil {
+		log.Errorf("[%s]failed to save report: %s", fn, err)
+	}
+}
+
+// 保存JSON格式的报告到dir, 文件名: audit_{开始时间}_{结束时间}.json
+func (r *Report) Save(dir string) error {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	f, err := os.Create(filepath.Join(dir, fmt.Sprintf("audit_%d_%d.json", r.Begin, r.End)))
+	if err != nil {
+		return err
+	}
+	if err := r.WriteJSON(f); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
@@ -0,0 +1,79 @@
+package audit
+
+import (
+	"bytes"
+	"option-kline/common"
+	"option-kline/kline"
+	"option-kline/store"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newCandle(tm int64, open, high, low, close string, origin uint8) *kline.OptionKline {
+	return &kline.OptionKline{CoinType: "GT", Open: open, High: high, Low: low, Close: close,
+		Time: tm, LastUpdate: tm, Origin: origin}
+}
+
+func TestAudit(t *testing.T) {
+	s := store.NewMemoryStore()
+	candles := []*kline.OptionKline{
+		newCandle(100, "6.8", "6.9", "6.7", "6.85", 1),
+		newCandle(101, "6.8", "6.9", "6.7", "6.85", 1),
+		// 102, 103缺失
+		newCandle(104, "6.8", "6.9", "6.7", "6.85", 1),
+		newCandle(104, "6.8", "6.9", "6.7", "6.85", 1),
+		newCandle(105, "6.8", "6.7", "6.6", "6.85", 1), // high < close
+		newCandle(106, "6.8", "6.9", "6.7", "6.85", 0),
+		newCandle(107, "6.8", "6.9", "6.7", "6.85", 0),
+		newCandle(108, "6.8", "6.9", "6.7", "6.85", 0),
+		newCandle(109, "6.8", "6.9", "6.7", "6.85", 1),
+	}
+	candles[8].LastUpdate = 50
+	if err := s.Append(kline.Interval1s, candles...); err != nil {
+		t.Fatal(err)
+	}
+
+	clock := common.NewFakeClock(time.Unix(1000, 0))
+	report, err := Run(s, Options{
+		CoinTypes:    []string{"GT"},
+		Intervals:    []kline.Interval{kline.Interval1s},
+		Begin:        100,
+		End:          112,
+		MaxSynthetic: 2,
+	}, clock)
+	if err != nil {
+		t.Fatal(err)
+	}
+	summary := report.Summaries[0]
+	expected := Summary{CoinType: "GT", Interval: "1s", Expected: 12, Found: 9, Missing: 4, Duplicates: 1,
+		InvalidOHLC: 1, SyntheticRuns: 1, Regressions: 1}
+	if summary != expected {
+		t.Fatalf("unexpected summary: %+v", summary)
+	}
+	kinds := []string{}
+	for _, issue := range report.Issues {
+		kinds = append(kinds, issue.Kind)
+	}
+	if strings.Join(kinds, ",") != "gap,duplicate,ohlc,time_regression,synthetic_run,gap" {
+		t.Fatalf("unexpected issues: %+v", report.Issues)
+	}
+	if gap := report.Issues[0]; gap.Begin != 102 || gap.End != 104 || gap.Count != 2 {
+		t.Fatalf("unexpected gap: %+v", gap)
+	}
+	if run := report.Issues[4]; run.Begin != 106 || run.End != 109 || run.Count != 3 {
+		t.Fatalf("unexpected synthetic run: %+v", run)
+	}
+
+	buf := &bytes.Buffer{}
+	if err := report.WriteCSV(buf); err != nil {
+		t.Fatal(err)
+	}
+	if lines := strings.Split(strings.TrimSpace(buf.String()), "\n"); len(lines) != 7 || lines[1] != "gap,GT,1s,102,104,2," {
+		t.Fatalf("unexpected csv:\n%s", buf.String())
+	}
+	report.UpdateMetrics()
+	if common.GetMetric(common.NewMetrics("audit"), "GT.1s.missing") != 4 {
+		t.Fatal("metrics not updated")
+	}
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"github.com/jinzhu/gorm"
+	"option-kline/backfill"
+	"option-kline/common"
+	"option-kline/migrations"
+	"option-kline/store"
+	"strings"
+)
+
+// 补录历史K线: option-kline backfill -from 时间 -to 时间 [选项] 文件...
+func runBackfill(args []string) error {
+	fs := flag.NewFlagSet("backfill", flag.ContinueOnError)
+	from := fs.String("from", "", "begin time, unix seconds or RFC3339")
+	to := fs.String("to", "", "end time (exclusive), unix seconds or RFC3339")
+	format := fs.String("format", backfill.FormatAuto, "file format: auto, ticks, contract, jsonl")
+	date := fs.String("date", "", "date of contract csv, yyyy-mm-dd")
+	coins := fs.String("coin", "", "coin types, comma separated, default: all supported")
+	noFill := fs.Bool("no-fill", false, "do not fill seconds without ticks")
+	replace := fs.Bool("replace", false, "replace existing klines, which may have been published and settled; every replaced kline is reported")
+	fs.Bool("skip-existing", true, "deprecated, only inserting missing klines is the default")
+	dryRun := fs.Bool("dry-run", false, "report without writing")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *from == "" || *to == "" || fs.NArg() == 0 {
+		fs.Usage()
+		return fmt.Errorf("usage: option-kline backfill -from time -to time [options] file...")
+	}
+
+	opts := backfill.Options{
+		Format:    *format,
+		Date:      *date,
+		CoinTypes: common.CoinSupported.Load().([]string),
+		Fill:      !*noFill,
+		Replace:   *replace,
+		DryRun:    *dryRun,
+	}
+	var err error
+	if opts.Begin, err = common.ParseTime(*from); err != nil {
+		return err
+	}
+	if opts.End, err = common.ParseTime(*to); err != nil {
+		return err
+	}
+	if *coins != "" {
+		opts.CoinTypes = strings.Split(strings.Replace(*coins, " ", "", -1), ",")
+	}
+	if opts.Files, err = backfill.ExpandFiles(fs.Args()); err != nil {
+		return err
+	}
+
+	s, closeStore, err := openStore()
+	if err != nil {
+		return err
+	}
+	defer closeStore()
+	results, err := backfill.Run(s, opts)
+	for _, r := range results {
+		fmt.Println(r)
+		for _, overwrite := range r.Overwritten {
+			fmt.Println("  replaced", overwrite)
+		}
+	}
+	return err
+}
+
+// 按配置打开K线存储及其使用的数据库, 命令结束时调用close释放
+func openStore() (s store.CandleStore, close func(), err error) {
+	deps := DefaultDependencies()
+	db, err := deps.NewDB()
+	if err != nil {
+		return nil, nil, err
+	}
+	close = func() {
+		if s != nil {
+			s.Close()
+		}
+		if db != nil {
+			db.Close()
+		}
+	}
+	if db != nil {
+		if err = migrations.Check(db); err != nil {
+			close()
+			return nil, nil, err
+		}
+	}
+	if s, err = deps.NewStore(db); err != nil {
+		close()
+		return nil, nil, err
+	}
+	return s, close, nil
+}
+
+// 只读命令(replay, verify)打开K线存储: 使用只读库, 不执行表结构变更, 版本不符时返回错误.
+// 内存存储没有可读取的数据, 返回空存储
+func openReadOnlyStore() (s store.CandleStore, close func(), err error) {
+	var db *gorm.DB
+	switch common.StoreDriver {
+	case common.STORE_MYSQL:
+		db, err = common.NewGormDB(common.RDBCONF)
+	case common.STORE_SQLITE:
+		db, err = store.OpenSQLiteReadOnly(common.StoreSQLitePath)
+	case common.STORE_MEMORY:
+		return store.NewMemoryStore(), func() {}, nil
+	default:
+		err = fmt.Errorf("store driver not supported: %s", common.StoreDriver)
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	if err = migrations.Check(db); err != nil {
+		db.Close()
+		return nil, nil, err
+	}
+	return store.NewSQLStore(db), func() { db.Close() }, nil
+}
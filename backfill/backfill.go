@@ -0,0 +1,380 @@
+package backfill
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"io"
+	"option-kline/kline"
+	"option-kline/store"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// 数据文件格式
+const (
+	FormatAuto     = "auto"     // 根据文件扩展名及表头判断
+	FormatTicks    = "ticks"    // 行情归档: 货币对,时间(unix秒),价格, 与模拟行情服务器的回放文件相同
+	FormatContract = "contract" // SaveKLine2Csv写入的报价: name,bid,ask,high,low,open,close,ratedecpt,lastupdate
+	FormatJsonl    = "jsonl"    // file推送目标写入的K线, 每行一条
+)
+
+// 补录配置
+type Options struct {
+	Files     []string
+	Format    string
+	Date      string   // contract格式的日期(yyyy-mm-dd), 报价中只有时分秒
+	CoinTypes []string // 补录的币种
+	Begin     int64    // 补录[Begin, End)内的秒级K线
+	End       int64
+	Fill      bool // 无行情的秒以上一秒K线补充, 与实时处理一致
+	Replace   bool // 替换已有K线; 默认只写入缺失的K线, 已有K线可能已推送并用于结算. 已链接的K线始终保留
+	DryRun    bool // 只统计, 不写入
+}
+
+// 补录结果
+type Result struct {
+	CoinType string
+	Ticks    int // 范围内读取的行情数量
+	Built    int // 重建的K线数量
+	Existing int // 已有的K线数量
+	Inserted int // 新写入的K线数量
+	Skipped  int // 已有而未替换的K线数量, 包括写入时已由实时处理保存的K线
+	Replaced int // 替换的K线数量
+	Chained  int // 已链接(有哈希)而未替换的K线数量
+
+	Overwritten []Overwrite // 被替换的K线
+}
+
+func (r Result) String() string {
+	return fmt.Sprintf("%s: ticks %d, built %d, existing %d, inserted %d, skipped %d, replaced %d, chained %d",
+		r.CoinType, r.Ticks, r.Built, r.Existing, r.Inserted, r.Skipped, r.Replaced, r.Chained)
+}
+
+// 被替换的K线及替换后的K线
+type Overwrite struct {
+	Old *kline.OptionKline
+	New *kline.OptionKline
+}
+
+func (o Overwrite) String() string {
+	return fmt.Sprintf("%s %d: %s/%s/%s/%s -> %s/%s/%s/%s", o.Old.CoinType, o.Old.Time,
+		o.Old.Open, o.Old.High, o.Old.Low, o.Old.Close, o.New.Open, o.New.High, o.New.Low, o.New.Close)
+}
+
+// 判断文件格式
+func detectFormat(fileName string) (string, error) {
+	if strings.HasSuffix(fileName, ".jsonl") {
+		return FormatJsonl, nil
+	}
+	f, err := os.Open(fileName)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	line, err := bufio.NewReader(f).ReadString('\n')
+	if err != nil && err != io.EOF {
+		return "", err
+	}
+	if strings.HasPrefix(line, "name,bid,") {
+		return FormatContract, nil
+	}
+	return FormatTicks, nil
+}
+
+// 读取行情数据, 按币种分组并按时间排序
+func ReadTicks(opts Options) (map[string][]*kline.OptionKline, error) {
+	tickMap := make(map[string][]*kline.OptionKline, len(opts.CoinTypes))
+	for _, fileName := range opts.Files {
+		format := opts.Format
+		if format == "" || format == FormatAuto {
+			var err error
+			if format, err = detectFormat(fileName); err != nil {
+				return nil, err
+			}
+		}
+		var tickList []*kline.OptionKline
+		var err error
+		switch format {
+		case FormatTicks:
+			tickList, err = readTicksFile(fileName)
+		case FormatContract:
+			tickList, err = readContractFile(fileName, opts.Date)
+		case FormatJsonl:
+			tickList, err = readJsonlFile(fileName)
+		default:
+			return nil, fmt.Errorf("format not supported: %s", format)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read %s: %s", fileName, err)
+		}
+		for _, tick := range tickList {
+			tickMap[tick.CoinType] = append(tickMap[tick.CoinType], tick)
+		}
+	}
+	for _, tickList := range tickMap {
+		// 同一秒内保留文件中的顺序
+		sort.SliceStable(tickList, func(i, j int) bool { return tickList[i].Time < tickList[j].Time })
+	}
+	return tickMap, nil
+}
+
+func newTick(symbol string, tm int64, price string) *kline.OptionKline {
+	coinType, ok := kline.CoinTypeMap[symbol]
+	if !ok {
+		// 已转换为币种的数据
+		coinType = symbol
+	}
+	return &kline.OptionKline{
+		CoinType:   coinType,
+		Open:       price,
+		Close:      price,
+		High:       price,
+		Low:        price,
+		Time:       tm,
+		LastUpdate: tm,
+		Origin:     1,
+		RawOpen:    price,
+		RawClose:   price,
+		RawHigh:    price,
+		RawLow:     price,
+		Source:     kline.SourceBackfill,
+		VendorTime: tm,
+	}
+}
+
+// 行情归档: 货币对,时间(unix秒),价格, #开头为注释
+func readTicksFile(fileName string) (tickList []*kline.OptionKline, err error) {
+	f, err := os.Open(fileName)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	r := csv.NewReader(f)
+	r.FieldsPerRecord = 3
+	r.Comment = '#'
+	for {
+		record, err := r.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		tm, err := strconv.ParseInt(record[1], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid time %s: %s", record[1], err)
+		}
+		if _, err := strconv.ParseFloat(record[2], 64); err != nil {
+			return nil, fmt.Errorf("invalid price %s: %s", record[2], err)
+		}
+		tickList = append(tickList, newTick(record[0], tm, record[2]))
+	}
+	return
+}
+
+// SaveKLine2Csv写入的报价, 时间与实时处理一致按北京时间解析
+func readContractFile(fileName, date string) (tickList []*kline.OptionKline, err error) {
+	if date == "" {
+		return nil, fmt.Errorf("date is required for contract csv")
+	}
+	loc, err := time.LoadLocation("Asia/Shanghai")
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.Open(fileName)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	r := csv.NewReader(f)
+	r.FieldsPerRecord = 9
+	for {
+		record, err := r.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		// 表头
+		if record[0] == "name" {
+			continue
+		}
+		ctr := kline.Contract{Name: record[0], High: record[3], Low: record[4], Open: record[5], Close: record[6], LastUpdate: record[8]}
+		lastupdate, err := time.ParseInLocation("2006-01-02 15:04:05", date+" "+ctr.LastUpdate, loc)
+		if err != nil {
+			return nil, fmt.Errorf("invalid lastupdate %s: %s", ctr.LastUpdate, err)
+		}
+		tick := newTick(ctr.Name, lastupdate.Unix(), "")
+		tick.Open = strings.Replace(ctr.Open, ",", "", -1)
+		tick.Close = strings.Replace(ctr.Close, ",", "", -1)
+		tick.High = strings.Replace(ctr.High, ",", "", -1)
+		tick.Low = strings.Replace(ctr.Low, ",", "", -1)
+		tick.KeepRaw()
+		tickList = append(tickList, tick)
+	}
+	return
+}
+
+// file推送目标写入的K线: 推送的K线可能是补充或干预后的数据, 不作为原始数据
+func readJsonlFile(fileName string) (tickList []*kline.OptionKline, err error) {
+	f, err := os.Open(fileName)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		k := &kline.OptionKline{}
+		if err := json.Unmarshal([]byte(line), k); err != nil {
+			return nil, fmt.Errorf("invalid kline %s: %s", line, err)
+		}
+		k.Source = kline.SourceBackfill
+		tickList = append(tickList, k)
+	}
+	return tickList, scanner.Err()
+}
+
+// 按实时处理的规则重建[begin, end)内的秒级K线: 每秒取第一条行情;
+// fill为true时, 无行情的秒以上一秒K线补充(非原始数据), begin之前的行情只用于补充
+func BuildSeconds(tickList []*kline.OptionKline, begin, end int64, fill bool) []*kline.OptionKline {
+	klineList := []*kline.OptionKline{}
+	var last *kline.OptionKline
+	fillTo := func(to int64) {
+		if !fill || last == nil {
+			return
+		}
+		tm := last.Time + 1
+		if tm < begin {
+			tm = begin
+		}
+		for ; tm < to; tm++ {
+			filled := *last
+			filled.Time, filled.Origin = tm, 0
+			klineList = append(klineList, &filled)
+		}
+	}
+	for _, tick := range tickList {
+		if tick.Time >= end {
+			break
+		}
+		if last != nil && tick.Time <= last.Time {
+			continue
+		}
+		fillTo(tick.Time)
+		last = tick
+		if tick.Time >= begin {
+			candle := *tick
+			klineList = append(klineList, &candle)
+		}
+	}
+	fillTo(end)
+	return klineList
+}
+
+// 补录秒级K线, 并更新已聚合的较大周期的K线
+func Run(s store.CandleStore, opts Options) ([]Result, error) {
+	if opts.Begin >= opts.End {
+		return nil, fmt.Errorf("invalid time range: [%d, %d)", opts.Begin, opts.End)
+	}
+	tickMap, err := ReadTicks(opts)
+	if err != nil {
+		return nil, err
+	}
+	results := []Result{}
+	for _, coinType := range opts.CoinTypes {
+		tickList := tickMap[coinType]
+		result := Result{CoinType: coinType}
+		for _, tick := range tickList {
+			if tick.Time >= opts.Begin && tick.Time < opts.End {
+				result.Ticks++
+			}
+		}
+		klineList := BuildSeconds(tickList, opts.Begin, opts.End, opts.Fill)
+		result.Built = len(klineList)
+
+		existing, err := s.Range(kline.Interval1s, coinType, opts.Begin, opts.End)
+		if err != nil {
+			return results, err
+		}
+		result.Existing = len(existing)
+		existMap := make(map[int64]*kline.OptionKline, len(existing))
+		for _, k := range existing {
+			existMap[k.Time] = k
+		}
+		writeList := klineList[:0]
+		for _, k := range klineList {
+			old, ok := existMap[k.Time]
+			if !ok {
+				result.Inserted++
+			} else if !opts.Replace {
+				result.Skipped++
+				continue
+			} else if old.Hash != "" {
+				// 替换已链接的K线会破坏哈希链
+				result.Chained++
+				if !opts.DryRun {
+					log.Warnf("[backfill]keep chained kline %s %d", old.CoinType, old.Time)
+				}
+				continue
+			} else {
+				result.Replaced++
+				overwrite := Overwrite{Old: old, New: k}
+				result.Overwritten = append(result.Overwritten, overwrite)
+				if !opts.DryRun {
+					log.Warnf("[backfill]replace kline %s", overwrite)
+				}
+			}
+			writeList = append(writeList, k)
+		}
+		klineList = writeList
+		if opts.DryRun || len(klineList) == 0 {
+			results = append(results, result)
+			continue
+		}
+		// 只写入缺失的K线时不删除任何数据, 读取之后由实时处理保存的K线在写入时跳过
+		if opts.Replace {
+			_, err = s.Upsert(kline.Interval1s, klineList...)
+		} else {
+			var skipped int64
+			skipped, err = s.AppendMissing(kline.Interval1s, klineList...)
+			result.Inserted -= int(skipped)
+			result.Skipped += int(skipped)
+		}
+		if err != nil {
+			return results, err
+		}
+		if err := store.Resample(s, coinType, klineList[0].Time, klineList[len(klineList)-1].Time+1); err != nil {
+			return results, err
+		}
+		results = append(results, result)
+	}
+	return results, nil
+}
+
+// 展开文件参数中的通配符
+func ExpandFiles(patterns []string) ([]string, error) {
+	files := []string{}
+	for _, pattern := range patterns {
+		matches, err := filepath.Glob(pattern)
+		if err != nil {
+			return nil, err
+		}
+		if len(matches) == 0 {
+			return nil, fmt.Errorf("no such file: %s", pattern)
+		}
+		files = append(files, matches...)
+	}
+	return files, nil
+}
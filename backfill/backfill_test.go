@@ -0,0 +1,181 @@
+package backfill
+
+import (
+	"io/ioutil"
+	"option-kline/kline"
+	"option-kline/store"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func writeFile(t *testing.T, dir, name, content string) string {
+	fileName := filepath.Join(dir, name)
+	if err := ioutil.WriteFile(fileName, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return fileName
+}
+
+func TestBuildSeconds(t *testing.T) {
+	tickList := []*kline.OptionKline{
+		newTick("XAUUSD", 98, "1280.00"),
+		newTick("XAUUSD", 101, "1280.10"),
+		newTick("XAUUSD", 101, "1280.20"),
+		newTick("XAUUSD", 104, "1280.40"),
+		newTick("XAUUSD", 110, "1280.90"),
+	}
+	klineList := BuildSeconds(tickList, 100, 106, true)
+	expected := []struct {
+		time   int64
+		price  string
+		origin uint8
+	}{{100, "1280.00", 0}, {101, "1280.10", 1}, {102, "1280.10", 0}, {103, "1280.10", 0}, {104, "1280.40", 1}, {105, "1280.40", 0}}
+	if len(klineList) != len(expected) {
+		t.Fatalf("there should be %d klines, actual: %v", len(expected), klineList)
+	}
+	for idx, e := range expected {
+		if k := klineList[idx]; k.Time != e.time || k.Open != e.price || k.Origin != e.origin || k.CoinType != "GT" {
+			t.Errorf("unexpected kline at %d: %s", idx, k)
+		}
+	}
+	if klineList = BuildSeconds(tickList, 100, 106, false); len(klineList) != 2 {
+		t.Errorf("only klines with ticks should be built, actual: %v", klineList)
+	}
+}
+
+func TestReadTicks(t *testing.T) {
+	dir, err := ioutil.TempDir("", "kline-backfill")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	opts := Options{
+		Files: []string{
+			writeFile(t, dir, "ticks.csv", "# symbol,time,price\nXAUUSD,1546065000,1280.10\nUSDCNH,1546065000,6.8800\n"),
+			// 北京时间14:30:01
+			writeFile(t, dir, "data.csv", "name,bid,ask,high,low,open,close,ratedecpt,lastupdate\nXAUUSD,1280,1281,\"1,290.5\",1270.1,1280.5,1280.6,2,14:30:01\n"),
+			writeFile(t, dir, "kline_GT_20181229.jsonl", "{\"coinType\":\"GT\",\"open\":\"1280.7\",\"close\":\"1280.7\",\"high\":\"1280.7\",\"low\":\"1280.7\",\"time\":1546065002}\n"),
+		},
+		Date: "2018-12-29",
+	}
+	tickMap, err := ReadTicks(opts)
+	if err != nil {
+		t.Fatalf("failed to read ticks: %s", err)
+	}
+	gt := tickMap["GT"]
+	if len(gt) != 3 || len(tickMap["USDT"]) != 1 {
+		t.Fatalf("unexpected ticks: %v", tickMap)
+	}
+	if gt[0].Open != "1280.10" || gt[1].Time != 1546065001 || gt[1].High != "1290.5" || gt[1].Open != "1280.5" {
+		t.Errorf("unexpected ticks: %v", gt)
+	}
+	if gt[2].Time != 1546065002 || gt[2].Origin != 0 {
+		t.Errorf("published klines should not be original data: %s", gt[2])
+	}
+
+	opts.Date = ""
+	if _, err := ReadTicks(opts); err == nil {
+		t.Error("contract csv without date should fail")
+	}
+}
+
+func TestRun(t *testing.T) {
+	dir, err := ioutil.TempDir("", "kline-backfill")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	fileName := writeFile(t, dir, "ticks.csv", "XAUUSD,1546065000,1280.10\nXAUUSD,1546065030,1280.40\n")
+
+	s := store.NewMemoryStore()
+	// 已有部分K线(包括已链接的K线)及已聚合的分钟K线
+	s.Append(kline.Interval1s, &kline.OptionKline{CoinType: "GT", Open: "1.0", Close: "1.0", High: "1.0", Low: "1.0", Time: 1546065010})
+	s.Append(kline.Interval1s, &kline.OptionKline{CoinType: "GT", Open: "2.0", Close: "2.0", High: "2.0", Low: "2.0", Time: 1546065020, Hash: "0011"})
+	s.Append(kline.Interval1m, &kline.OptionKline{CoinType: "GT", Open: "1.0", Close: "1.0", High: "1.0", Low: "1.0", Time: 1546065000 - 1546065000%60})
+
+	opts := Options{Files: []string{fileName}, CoinTypes: []string{"GT"}, Begin: 1546065000, End: 1546065060, Fill: true}
+	results, err := Run(s, opts)
+	if err != nil {
+		t.Fatalf("failed to backfill: %s", err)
+	}
+	if r := results[0]; r.Ticks != 2 || r.Built != 60 || r.Existing != 2 || r.Inserted != 58 || r.Skipped != 2 || r.Replaced != 0 {
+		t.Errorf("unexpected result: %s", r)
+	}
+	klineList, _ := s.Range(kline.Interval1s, "GT", 0, 1<<40)
+	if len(klineList) != 60 || klineList[10].Open != "1.0" {
+		t.Errorf("existing klines should be kept without duplicates: %v", klineList)
+	}
+
+	// 默认重复执行不修改已有K线
+	if results, err = Run(s, opts); err != nil {
+		t.Fatalf("failed to backfill: %s", err)
+	}
+	if r := results[0]; r.Inserted != 0 || r.Skipped != 60 || r.Replaced != 0 {
+		t.Errorf("unexpected result: %s", r)
+	}
+
+	// 明确指定时替换未链接的已有K线并报告被替换的K线, 重复执行不产生重复数据
+	opts.Replace = true
+	for i := 0; i < 2; i++ {
+		if results, err = Run(s, opts); err != nil {
+			t.Fatalf("failed to backfill: %s", err)
+		}
+	}
+	if r := results[0]; r.Existing != 60 || r.Inserted != 0 || r.Replaced != 59 || r.Chained != 1 || len(r.Overwritten) != 59 {
+		t.Errorf("unexpected result: %s", r)
+	}
+	klineList, _ = s.Range(kline.Interval1s, "GT", 0, 1<<40)
+	if len(klineList) != 60 || klineList[10].Open != "1280.10" || klineList[20].Open != "2.0" {
+		t.Errorf("existing klines should be replaced: %v", klineList)
+	}
+	// 已聚合的分钟K线随之更新
+	klineList, _ = s.Range(kline.Interval1m, "GT", 0, 1<<40)
+	if len(klineList) != 1 || klineList[0].High != "1280.40" {
+		t.Errorf("downsampled klines should be resampled: %v", klineList)
+	}
+}
+
+// 读取已有K线之后由实时处理保存的K线
+type racingStore struct {
+	store.CandleStore
+	live *kline.OptionKline
+}
+
+func (s *racingStore) Range(interval kline.Interval, coinType string, begin, end int64) ([]*kline.OptionKline, error) {
+	klineList, err := s.CandleStore.Range(interval, coinType, begin, end)
+	if s.live != nil && interval.Name == kline.Interval1s.Name {
+		s.CandleStore.Append(kline.Interval1s, s.live)
+		s.live = nil
+	}
+	return klineList, err
+}
+
+// 补录期间实时写入的K线被跳过, 不产生重复的秒
+func TestRunConcurrentLiveWrite(t *testing.T) {
+	dir, err := ioutil.TempDir("", "kline-backfill")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	fileName := writeFile(t, dir, "ticks.csv", "XAUUSD,1546065000,1280.10\n")
+	sqliteStore, err := store.NewSQLiteStore(filepath.Join(dir, "kline.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sqliteStore.Close()
+	live := &kline.OptionKline{CoinType: "GT", Open: "1.0", Close: "1.0", High: "1.0", Low: "1.0", Time: 1546065005}
+	s := &racingStore{CandleStore: sqliteStore, live: live}
+
+	results, err := Run(s, Options{Files: []string{fileName}, CoinTypes: []string{"GT"}, Begin: 1546065000, End: 1546065010, Fill: true})
+	if err != nil {
+		t.Fatalf("failed to backfill: %s", err)
+	}
+	if r := results[0]; r.Built != 10 || r.Inserted != 9 || r.Skipped != 1 {
+		t.Errorf("unexpected result: %s", r)
+	}
+	klineList, _ := sqliteStore.Range(kline.Interval1s, "GT", 0, 1<<40)
+	if len(klineList) != 10 || klineList[5].Open != "1.0" {
+		t.Errorf("live kline should be kept without duplicates: %v", klineList)
+	}
+}
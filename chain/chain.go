@@ -0,0 +1,86 @@
+package chain
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"option-kline/kline"
+	"option-kline/store"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// 启动时查找链头的秒级K线数量
+const headScan = 3600
+
+// K线内容及上一条K线哈希的SHA-256, 十六进制
+// 包含推送及原始报价、来源等所有保存的字段, 任一字段被修改都会导致哈希不一致
+func Hash(k *kline.OptionKline) string {
+	content := strings.Join([]string{
+		k.CoinType, strconv.FormatInt(k.Time, 10),
+		k.Open, k.High, k.Low, k.Close,
+		strconv.FormatInt(k.LastUpdate, 10), strconv.Itoa(int(k.Origin)),
+		k.RawOpen, k.RawHigh, k.RawLow, k.RawClose,
+		k.Source, strconv.FormatInt(k.VendorTime, 10),
+		k.PrevHash,
+	}, "|")
+	sum := sha256.Sum256([]byte(content))
+	return hex.EncodeToString(sum[:])
+}
+
+// 链头: 最后一条已保存K线的时间及哈希
+type Head struct {
+	Time int64
+	Hash string
+}
+
+// 各币种的哈希链, 保存K线前调用Link, 保存成功后调用Commit
+type Chain struct {
+	mutex sync.Mutex
+	heads map[string]Head
+}
+
+// 以存储中各币种最新的已链接秒级K线作为链头
+// 补录等方式写入的K线没有哈希, 向前查找最近headScan条, 均无哈希时从新链开始
+func NewChain(s store.CandleStore, coinTypes []string) (*Chain, error) {
+	c := &Chain{heads: make(map[string]Head, len(coinTypes))}
+	for _, coinType := range coinTypes {
+		latest, err := s.Latest(kline.Interval1s, coinType, headScan)
+		if err != nil {
+			return nil, err
+		}
+		for idx := len(latest) - 1; idx >= 0; idx-- {
+			if latest[idx].Hash != "" {
+				c.heads[coinType] = Head{Time: latest[idx].Time, Hash: latest[idx].Hash}
+				break
+			}
+		}
+	}
+	return c, nil
+}
+
+// 将K线链接到链头, 保存失败重试时哈希不变
+func (c *Chain) Link(k *kline.OptionKline) {
+	c.mutex.Lock()
+	k.PrevHash = c.heads[k.CoinType].Hash
+	c.mutex.Unlock()
+	k.Hash = Hash(k)
+}
+
+// K线已保存, 更新链头
+func (c *Chain) Commit(k *kline.OptionKline) {
+	c.mutex.Lock()
+	c.heads[k.CoinType] = Head{Time: k.Time, Hash: k.Hash}
+	c.mutex.Unlock()
+}
+
+// 各币种当前的链头
+func (c *Chain) Heads() map[string]Head {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	heads := make(map[string]Head, len(c.heads))
+	for coinType, head := range c.heads {
+		heads[coinType] = head
+	}
+	return heads
+}
@@ -0,0 +1,121 @@
+package chain
+
+import (
+	"crypto/ed25519"
+	"io/ioutil"
+	"option-kline/common"
+	"option-kline/kline"
+	"option-kline/store"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+const testSeed = "000102030405060708090a0b0c0d0e0f101112131415161718191a1b1c1d1e1f"
+
+func newCandle(tm int64, price string) *kline.OptionKline {
+	return &kline.OptionKline{CoinType: "GT", Time: tm, Open: price, High: price, Low: price, Close: price,
+		RawOpen: price, RawHigh: price, RawLow: price, RawClose: price, LastUpdate: tm, Origin: 1, Source: kline.SourceForex, VendorTime: tm}
+}
+
+// 模拟App.PersistKline: 链接, 保存, 更新链头
+func persist(t *testing.T, c *Chain, s store.CandleStore, k *kline.OptionKline) {
+	c.Link(k)
+	if err := s.Append(kline.Interval1s, k); err != nil {
+		t.Fatal(err)
+	}
+	c.Commit(k)
+}
+
+func kinds(summary *Summary) string {
+	list := []string{}
+	for _, issue := range summary.Issues {
+		list = append(list, issue.Kind)
+	}
+	return strings.Join(list, ",")
+}
+
+func TestChainVerify(t *testing.T) {
+	dir, err := ioutil.TempDir("", "chain")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	clock := common.NewFakeClock(time.Unix(1546065000, 0))
+	// 使用sqlite存储, 以便绕过存储接口直接修改数据
+	s, err := store.NewSQLiteStore(filepath.Join(dir, "kline.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	c, err := NewChain(s, []string{"GT"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkpointer, err := NewCheckpointer(c, Config{CheckpointDir: dir, SignKey: testSeed}, clock)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for tm := int64(100); tm < 105; tm++ {
+		persist(t, c, s, newCandle(tm, "1280.5"))
+	}
+	checkpointer.Run()
+	clock.Advance(time.Minute)
+	// 链头未变化时不重复写入
+	checkpointer.Run()
+
+	// 重启后从存储中最新的已链接K线继续, 补录的K线没有哈希
+	if err := s.Append(kline.Interval1s, newCandle(105, "1280.6")); err != nil {
+		t.Fatal(err)
+	}
+	if c, err = NewChain(s, []string{"GT"}); err != nil {
+		t.Fatal(err)
+	}
+	if head := c.Heads()["GT"]; head.Time != 104 {
+		t.Fatalf("head should be the latest linked kline: %+v", head)
+	}
+	persist(t, c, s, newCandle(106, "1280.7"))
+
+	checkpoints, err := ReadCheckpoints(dir)
+	if err != nil || len(checkpoints) != 1 || checkpoints[0].Time != 104 {
+		t.Fatalf("unexpected checkpoints: %+v, %v", checkpoints, err)
+	}
+	privateKey, _ := ParsePrivateKey(testSeed)
+	publicKey := privateKey.Public().(ed25519.PublicKey)
+	summary, err := Verify(s, "GT", 100, 200, checkpoints, publicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 链开始之后无哈希的K线视为问题, 链开始之前的旧数据不视为问题
+	if summary.Rows != 7 || summary.Unchained != 1 || summary.Checkpoints != 1 || kinds(summary) != "unchained" {
+		t.Fatalf("only the unchained row should be reported: %+v", summary)
+	}
+	if summary, err = Verify(s, "GT", 105, 200, checkpoints, publicKey); err != nil || len(summary.Issues) != 0 {
+		t.Fatalf("unchained row before the first chained row should not be reported: %+v, %v", summary, err)
+	}
+
+	// 已链接的K线不可通过存储接口替换
+	if _, err := s.Upsert(kline.Interval1s, newCandle(103, "1300")); err != store.ErrChainedKline {
+		t.Fatalf("chained kline should not be replaced, err: %v", err)
+	}
+	// 直接修改内容, 替换一条K线, 修改检查点
+	db := s.DB().Table(store.TableName(kline.Interval1s))
+	if err := db.Where("time = ?", 101).Update("close", "1300").Error; err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Where("time = ?", 103).Delete(&kline.OptionKline{}).Error; err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Append(kline.Interval1s, &kline.OptionKline{CoinType: "GT", Time: 103}); err != nil {
+		t.Fatal(err)
+	}
+	checkpoints[0].Hash = strings.Repeat("0", 64)
+	if summary, err = Verify(s, "GT", 100, 200, checkpoints, publicKey); err != nil {
+		t.Fatal(err)
+	}
+	if k := kinds(summary); k != "checkpoint,hash_mismatch,unchained,broken_link,unchained" {
+		t.Errorf("unexpected issues: %s, %+v", k, summary.Issues)
+	}
+}
@@ -0,0 +1,181 @@
+package chain
+
+import (
+	"bufio"
+	"crypto/ed25519"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"option-kline/common"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+)
+
+// 检查点文件, 每行一个检查点, 只追加不修改
+const checkpointFile = "checkpoints.jsonl"
+
+// 检查点: 某一时刻各币种的链头, 以ed25519签名, 与K线分开保存
+type Checkpoint struct {
+	CoinType  string `json:"coinType"`
+	Time      int64  `json:"time"` // 链头K线的时间
+	Hash      string `json:"hash"` // 链头K线的哈希
+	CreatedAt int64  `json:"createdAt"`
+	Signature string `json:"signature,omitempty"` // 未配置签名密钥时为空
+}
+
+// 签名的内容
+func (c Checkpoint) payload() []byte {
+	return []byte(c.CoinType + "|" + strconv.FormatInt(c.Time, 10) + "|" + c.Hash + "|" + strconv.FormatInt(c.CreatedAt, 10))
+}
+
+// 检查签名
+func (c Checkpoint) Verify(publicKey ed25519.PublicKey) error {
+	if c.Signature == "" {
+		return fmt.Errorf("checkpoint is not signed")
+	}
+	sig, err := hex.DecodeString(c.Signature)
+	if err != nil {
+		return fmt.Errorf("invalid signature: %s", err)
+	}
+	if !ed25519.Verify(publicKey, c.payload(), sig) {
+		return fmt.Errorf("signature mismatch")
+	}
+	return nil
+}
+
+// 解析签名密钥: 32字节种子的十六进制
+func ParsePrivateKey(seed string) (ed25519.PrivateKey, error) {
+	data, err := hex.DecodeString(seed)
+	if err != nil || len(data) != ed25519.SeedSize {
+		return nil, fmt.Errorf("sign key should be %d bytes in hex", ed25519.SeedSize)
+	}
+	return ed25519.NewKeyFromSeed(data), nil
+}
+
+// 解析验证公钥: 32字节的十六进制
+func ParsePublicKey(key string) (ed25519.PublicKey, error) {
+	data, err := hex.DecodeString(key)
+	if err != nil || len(data) != ed25519.PublicKeySize {
+		return nil, fmt.Errorf("verify key should be %d bytes in hex", ed25519.PublicKeySize)
+	}
+	return ed25519.PublicKey(data), nil
+}
+
+// 哈希链配置
+type Config struct {
+	CheckpointDir string // 检查点目录, 与K线数据分开保存
+	SignKey       string // 检查点签名密钥(ed25519种子, 十六进制), 为空时不签名
+}
+
+// 根据已加载的配置生成哈希链配置
+func NewConfig() Config {
+	return Config{
+		CheckpointDir: common.ChainCheckpointDir,
+		SignKey:       common.ChainSignKey,
+	}
+}
+
+// 定期写入检查点
+type Checkpointer struct {
+	chain      *Chain
+	dir        string
+	privateKey ed25519.PrivateKey // 为nil时不签名
+	clock      common.Clock
+	last       map[string]Head // 各币种上一个检查点, 链头未变化时不重复写入
+}
+
+func NewCheckpointer(chain *Chain, conf Config, clock common.Clock) (*Checkpointer, error) {
+	c := &Checkpointer{chain: chain, dir: conf.CheckpointDir, clock: clock, last: map[string]Head{}}
+	if conf.CheckpointDir == "" {
+		return nil, fmt.Errorf("checkpoint dir is not configured")
+	}
+	if conf.SignKey != "" {
+		privateKey, err := ParsePrivateKey(conf.SignKey)
+		if err != nil {
+			return nil, err
+		}
+		c.privateKey = privateKey
+	}
+	return c, nil
+}
+
+// 写入各币种当前链头的检查点
+func (c *Checkpointer) Run() {
+	fn := "Checkpointer.Run"
+	heads := c.chain.Heads()
+	coinTypes := make([]string, 0, len(heads))
+	for coinType := range heads {
+		coinTypes = append(coinTypes, coinType)
+	}
+	sort.Strings(coinTypes)
+	checkpoints := []Checkpoint{}
+	for _, coinType := range coinTypes {
+		head := heads[coinType]
+		if head.Hash == "" || c.last[coinType] == head {
+			continue
+		}
+		cp := Checkpoint{CoinType: coinType, Time: head.Time, Hash: head.Hash, CreatedAt: c.clock.Now().Unix()}
+		if c.privateKey != nil {
+			cp.Signature = hex.EncodeToString(ed25519.Sign(c.privateKey, cp.payload()))
+		}
+		checkpoints = append(checkpoints, cp)
+	}
+	if len(checkpoints) == 0 {
+		return
+	}
+	if err := AppendCheckpoints(c.dir, checkpoints); err != nil {
+		log.Errorf("[%s]failed to write checkpoints: %s", fn, err)
+		return
+	}
+	for _, cp := range checkpoints {
+		c.last[cp.CoinType] = Head{Time: cp.Time, Hash: cp.Hash}
+	}
+}
+
+// 追加检查点到dir下的检查点文件
+func AppendCheckpoints(dir string, checkpoints []Checkpoint) error {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(filepath.Join(dir, checkpointFile), os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	enc := json.NewEncoder(f)
+	for _, cp := range checkpoints {
+		if err := enc.Encode(cp); err != nil {
+			f.Close()
+			return err
+		}
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// 读取dir下的所有检查点, 文件不存在时返回空
+func ReadCheckpoints(dir string) ([]Checkpoint, error) {
+	f, err := os.Open(filepath.Join(dir, checkpointFile))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	checkpoints := []Checkpoint{}
+	scanner := bufio.NewScanner(f)
+	for line := 1; scanner.Scan(); line++ {
+		cp := Checkpoint{}
+		if err := json.Unmarshal(scanner.Bytes(), &cp); err != nil {
+			return nil, fmt.Errorf("invalid checkpoint at line %d: %s", line, err)
+		}
+		checkpoints = append(checkpoints, cp)
+	}
+	return checkpoints, scanner.Err()
+}
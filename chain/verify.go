@@ -0,0 +1,107 @@
+package chain
+
+import (
+	"crypto/ed25519"
+	"fmt"
+	"option-kline/kline"
+	"option-kline/store"
+)
+
+// 检查发现的问题类型
+const (
+	IssueHashMismatch = "hash_mismatch" // K线内容与哈希不一致, 数据被修改
+	IssueBrokenLink   = "broken_link"   // prevHash与上一条K线的哈希不一致, K线被删除、插入或替换
+	IssueCheckpoint   = "checkpoint"    // 检查点签名无效, 或与该时刻K线的哈希不一致
+	IssueUnchained    = "unchained"     // 链开始之后出现无哈希的K线, 数据被补录或绕过哈希链写入
+)
+
+const scanRows = 10000 // 每次查询的秒级K线数量上限
+
+type Issue struct {
+	Kind     string `json:"kind"`
+	CoinType string `json:"coinType"`
+	Time     int64  `json:"time"`
+	Detail   string `json:"detail"`
+}
+
+func (i Issue) String() string {
+	return fmt.Sprintf("%s %s at %d: %s", i.Kind, i.CoinType, i.Time, i.Detail)
+}
+
+// 单个币种的检查结果
+type Summary struct {
+	CoinType    string  `json:"coinType"`
+	Begin       int64   `json:"begin"`
+	End         int64   `json:"end"`
+	Rows        int     `json:"rows"`        // 检查的K线数量
+	Unchained   int     `json:"unchained"`   // 无哈希的K线数量, 只有第一条已链接K线之前的旧数据不视为问题
+	Checkpoints int     `json:"checkpoints"` // 检查的检查点数量
+	Issues      []Issue `json:"issues"`
+}
+
+func (s *Summary) add(kind string, time int64, format string, args ...interface{}) {
+	s.Issues = append(s.Issues, Issue{Kind: kind, CoinType: s.CoinType, Time: time, Detail: fmt.Sprintf(format, args...)})
+}
+
+// 按时间顺序重新遍历[begin, end)内的秒级K线, 检查每条K线的哈希及与上一条已链接K线的链接,
+// 并核对该范围内的检查点. publicKey为nil时不检查签名
+// 范围内第一条已链接K线的上一条不在范围内, 不检查其链接; 此后无哈希的K线均视为问题
+func Verify(s store.CandleStore, coinType string, begin, end int64, checkpoints []Checkpoint, publicKey ed25519.PublicKey) (*Summary, error) {
+	summary := &Summary{CoinType: coinType, Begin: begin, End: end, Issues: []Issue{}}
+	pending := map[int64][]Checkpoint{}
+	for _, cp := range checkpoints {
+		if cp.CoinType != coinType || cp.Time < begin || cp.Time >= end {
+			continue
+		}
+		summary.Checkpoints++
+		if publicKey != nil {
+			if err := cp.Verify(publicKey); err != nil {
+				summary.add(IssueCheckpoint, cp.Time, "checkpoint created at %d: %s", cp.CreatedAt, err)
+				continue
+			}
+		}
+		pending[cp.Time] = append(pending[cp.Time], cp)
+	}
+
+	prevHash := ""
+	window := int64(scanRows) * kline.Interval1s.Seconds
+	for from := begin; from < end; from += window {
+		to := from + window
+		if to > end {
+			to = end
+		}
+		klineList, err := s.Range(kline.Interval1s, coinType, from, to)
+		if err != nil {
+			return nil, err
+		}
+		for _, k := range klineList {
+			summary.Rows++
+			if k.Hash == "" {
+				summary.Unchained++
+				if prevHash != "" {
+					summary.add(IssueUnchained, k.Time, "row without hash after chained row")
+				}
+				continue
+			}
+			if hash := Hash(k); hash != k.Hash {
+				summary.add(IssueHashMismatch, k.Time, "stored hash %s, computed %s", k.Hash, hash)
+			}
+			if prevHash != "" && k.PrevHash != prevHash {
+				summary.add(IssueBrokenLink, k.Time, "prevHash %s, previous row hash %s", k.PrevHash, prevHash)
+			}
+			prevHash = k.Hash
+			for _, cp := range pending[k.Time] {
+				if cp.Hash != k.Hash {
+					summary.add(IssueCheckpoint, k.Time, "checkpoint created at %d has hash %s, row hash %s", cp.CreatedAt, cp.Hash, k.Hash)
+				}
+			}
+			delete(pending, k.Time)
+		}
+	}
+	for time, cps := range pending {
+		for _, cp := range cps {
+			summary.add(IssueCheckpoint, time, "checkpoint created at %d refers to a missing or unchained row", cp.CreatedAt)
+		}
+	}
+	return summary, nil
+}
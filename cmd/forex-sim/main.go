@@ -0,0 +1,68 @@
+// 模拟行情服务器: 使用与真实行情服务器相同的协议推送随机游走或回放的报价,
+// 本地开发及集成测试时将 [kline] forex_addr 指向该地址即可
+//
+//	go run ./cmd/forex-sim -addr 127.0.0.1:2000 -symbols XAUUSD:1280.5,USDCNH:6.88
+//	go run ./cmd/forex-sim -replay ticks.csv -speed 10
+package main
+
+import (
+	"flag"
+	log "github.com/sirupsen/logrus"
+	"option-kline/common"
+	"option-kline/forex/simulator"
+	"strconv"
+	"strings"
+)
+
+func parseSymbols(val string) (map[string]float64, error) {
+	symbols := make(map[string]float64, 5)
+	for _, item := range strings.Split(strings.Replace(val, " ", "", -1), ",") {
+		if item == "" {
+			continue
+		}
+		kv := strings.SplitN(item, ":", 2)
+		price := 1.0
+		if len(kv) == 2 {
+			var err error
+			if price, err = strconv.ParseFloat(kv[1], 64); err != nil {
+				return nil, err
+			}
+		}
+		symbols[kv[0]] = price
+	}
+	return symbols, nil
+}
+
+func main() {
+	conf := simulator.DefaultConfig()
+	symbols := flag.String("symbols", "XAUUSD:1280.5,USDCNH:6.88,BTCUSD:3800", "货币对及初始价格, 格式: 货币对:价格,...")
+	flag.StringVar(&conf.Addr, "addr", conf.Addr, "监听地址")
+	flag.DurationVar(&conf.Interval, "interval", conf.Interval, "报价间隔")
+	flag.Float64Var(&conf.Volatility, "volatility", conf.Volatility, "随机游走每次的最大相对涨跌幅")
+	flag.IntVar(&conf.Precision, "precision", conf.Precision, "价格小数位数")
+	flag.Int64Var(&conf.Seed, "seed", conf.Seed, "随机数种子, 0表示使用当前时间")
+	flag.StringVar(&conf.ReplayFile, "replay", conf.ReplayFile, "回放文件, 每行: 货币对,时间(unix秒),价格")
+	flag.Float64Var(&conf.Speed, "speed", conf.Speed, "回放速度倍数, 0表示按interval匀速回放")
+	flag.DurationVar(&conf.DisconnectEvery, "disconnect", conf.DisconnectEvery, "每隔多久断开所有客户端, 0表示不断开")
+	flag.Float64Var(&conf.MalformedRate, "malformed", conf.MalformedRate, "畸形记录的比例, 0~1")
+	flag.DurationVar(&conf.BurstEvery, "burst-every", conf.BurstEvery, "每隔多久突发发送一批报价, 0表示不突发")
+	flag.IntVar(&conf.BurstSize, "burst-size", conf.BurstSize, "突发时每个货币对连续发送的记录数")
+	flag.DurationVar(&conf.SilenceEvery, "silence-every", conf.SilenceEvery, "每隔多久静默一次, 0表示不静默")
+	flag.DurationVar(&conf.SilenceFor, "silence-for", conf.SilenceFor, "每次静默的时长")
+	flag.Parse()
+
+	var err error
+	if conf.Symbols, err = parseSymbols(*symbols); err != nil {
+		log.Fatalf("[main]invalid symbols %s: %s", *symbols, err)
+	}
+	sim, err := simulator.New(conf)
+	if err != nil {
+		log.Fatalf("[main]Failed to create simulator: %s", err)
+	}
+	if err := sim.Start(); err != nil {
+		log.Fatalf("[main]Failed to start simulator: %s", err)
+	}
+	s := <-common.QuitSignal()
+	log.Infof("[main]Received quit signal: %v", s)
+	sim.Close()
+}
@@ -0,0 +1,142 @@
+package common
+
+import (
+	"sync"
+	"time"
+)
+
+// 时钟: K线周期划分、补数据及报价干预都通过时钟获取当前时间,
+// 测试时使用FakeClock, 回放历史行情时使用ReplayClock
+type Clock interface {
+	Now() time.Time
+	After(d time.Duration) <-chan time.Time // 时钟前进d后收到当前时间
+}
+
+// 由行情时间驱动的时钟, 数据源收到行情后调用Observe推进时间
+type TickClock interface {
+	Clock
+	Observe(t time.Time)
+}
+
+type realClock struct{}
+
+func (realClock) Now() time.Time {
+	return time.Now()
+}
+
+func (realClock) After(d time.Duration) <-chan time.Time {
+	return time.After(d)
+}
+
+// 系统时钟
+var RealClock Clock = realClock{}
+
+// 根据配置创建时钟
+func NewClock(mode string) Clock {
+	if mode == CLOCK_REPLAY {
+		return NewReplayClock(time.Time{})
+	}
+	return RealClock
+}
+
+// 等待时钟到达的时间, 由手动控制及回放时钟在时间前进时通知
+type clockWaiter struct {
+	deadline time.Time
+	c        chan time.Time
+}
+
+type clockWaiters []clockWaiter
+
+func (w *clockWaiters) add(now time.Time, d time.Duration) <-chan time.Time {
+	c := make(chan time.Time, 1)
+	if d <= 0 {
+		c <- now
+		return c
+	}
+	*w = append(*w, clockWaiter{deadline: now.Add(d), c: c})
+	return c
+}
+
+func (w *clockWaiters) fire(now time.Time) {
+	pending := (*w)[:0]
+	for _, waiter := range *w {
+		if waiter.deadline.After(now) {
+			pending = append(pending, waiter)
+			continue
+		}
+		waiter.c <- now
+	}
+	*w = pending
+}
+
+// 手动控制的时钟, 用于测试
+type FakeClock struct {
+	mutex   sync.Mutex
+	now     time.Time
+	waiters clockWaiters
+}
+
+func NewFakeClock(now time.Time) *FakeClock {
+	return &FakeClock{now: now}
+}
+
+func (c *FakeClock) Now() time.Time {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.now
+}
+
+// Set或Advance使时间到达后收到通知
+func (c *FakeClock) After(d time.Duration) <-chan time.Time {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.waiters.add(c.now, d)
+}
+
+func (c *FakeClock) Set(now time.Time) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.now = now
+	c.waiters.fire(c.now)
+}
+
+func (c *FakeClock) Advance(d time.Duration) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.now = c.now.Add(d)
+	c.waiters.fire(c.now)
+}
+
+// 回放时钟: 当前时间为已收到的最新行情时间, 时间只前进不后退
+type ReplayClock struct {
+	mutex   sync.Mutex
+	now     time.Time
+	waiters clockWaiters
+}
+
+func NewReplayClock(start time.Time) *ReplayClock {
+	return &ReplayClock{now: start}
+}
+
+func (c *ReplayClock) Now() time.Time {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.now
+}
+
+// 收到的行情时间到达后通知, 没有新的行情时不会通知
+func (c *ReplayClock) After(d time.Duration) <-chan time.Time {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.waiters.add(c.now, d)
+}
+
+// 收到行情时推进时间, 早于当前时间的行情不会使时钟倒退
+func (c *ReplayClock) Observe(t time.Time) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if t.After(c.now) {
+		c.now = t
+		c.waiters.fire(c.now)
+	}
+}
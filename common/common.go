@@ -20,6 +20,7 @@ import (
 	"strconv"
 	"strings"
 	"syscall"
+	"time"
 )
 
 //将int64数组按照分隔符拼接成字符串
@@ -479,3 +480,33 @@ func IsStrBigger(str1, str2 string) bool {
 	}
 	return true
 }
+
+// 解析时长, 单位: s 秒, m 分, h 小时, d 天, 如: 7d, 返回秒数
+func ParseSeconds(str string) (int64, error) {
+	str = strings.TrimSpace(str)
+	if len(str) < 2 {
+		return 0, fmt.Errorf("invalid duration: %s", str)
+	}
+	units := map[byte]int64{'s': 1, 'm': 60, 'h': 3600, 'd': DAY_SECONDS}
+	unit, ok := units[str[len(str)-1]]
+	if !ok {
+		return 0, fmt.Errorf("invalid duration unit: %s", str)
+	}
+	n, err := strconv.ParseInt(str[:len(str)-1], 10, 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid duration: %s", str)
+	}
+	return n * unit, nil
+}
+
+// 解析时间: unix秒或RFC3339格式
+func ParseTime(str string) (int64, error) {
+	if tm, err := strconv.ParseInt(str, 10, 64); err == nil {
+		return tm, nil
+	}
+	tm, err := time.Parse(time.RFC3339, str)
+	if err != nil {
+		return 0, fmt.Errorf("invalid time %s, expected unix seconds or %s", str, time.RFC3339)
+	}
+	return tm.Unix(), nil
+}
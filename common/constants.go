@@ -35,9 +35,52 @@ const (
 	ORDER_TYPE_BUY  = 1 //买入类型
 )
 
+// K线处理模式
+const (
+	PIPELINE_SERIAL   = "serial"   //保存数据库成功后再推送
+	PIPELINE_PARALLEL = "parallel" //保存数据库与推送互相独立
+)
+
+// 报价模式
+const (
+	PRICE_MODE_PASSTHROUGH = "passthrough" //推送及保存行情源的原始报价
+	PRICE_MODE_ADJUST      = "adjust"      //按报价调节器平滑报价, 只使用行情数据
+)
+
+// 时钟类型
+const (
+	CLOCK_REAL   = "real"   //系统时间
+	CLOCK_REPLAY = "replay" //由行情时间驱动, 用于回放历史行情
+)
+
+// K线存储类型
+const (
+	STORE_MYSQL  = "mysql"  //MySQL数据库, 使用[db]配置
+	STORE_SQLITE = "sqlite" //SQLite数据库文件, 适用于小规模部署
+	STORE_MEMORY = "memory" //内存, 不持久化, 用于测试
+)
+
+// 配置来源, 后面的层覆盖前面的层, option_setting中的动态配置最后生效
+const (
+	CONFIG_SOURCE_DEFAULT = "default" //默认值
+	CONFIG_SOURCE_FILE    = "file"    //配置文件
+	CONFIG_SOURCE_ENV     = "env"     //环境变量
+	CONFIG_SOURCE_FLAG    = "flag"    //命令行参数
+	CONFIG_SOURCE_DB      = "db"      //option_setting表
+)
+
+// 缓冲队列满时的处理策略
+const (
+	QUEUE_POLICY_BLOCK       = "block"       //阻塞等待, 超时后丢弃
+	QUEUE_POLICY_DROP_OLDEST = "drop_oldest" //丢弃最旧的数据
+	QUEUE_POLICY_DROP_NEWEST = "drop_newest" //丢弃最新的数据
+	QUEUE_POLICY_COALESCE    = "coalesce"    //每个币种只保留最新的一条
+)
+
 // redis key
 const (
-	REDIS_KEY_PREFIX = "bc:exg" //redis缓存前缀
+	REDIS_KEY_PREFIX       = "bc:exg"             //redis缓存前缀
+	REDIS_KLINE_KEY_PREFIX = "option-kline:kline" //K线缓存前缀
 )
 
 //分页设置
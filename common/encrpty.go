@@ -5,6 +5,8 @@ import (
 	"crypto/cipher"
 	"bytes"
 	"compress/flate"
+	"compress/gzip"
+	"io"
 	"strings"
 	"io/ioutil"
 	"fmt"
@@ -81,3 +83,13 @@ func Gzencode(data string,level int) []byte  {
 	return bufs.Bytes()
 }
 
+// gzip格式的流式压缩, 与Gzencode不同, 输出带gzip文件头, 可直接用gunzip解压
+// level为flate压缩级别, 写入完成后需调用Close
+func NewGzipWriter(w io.Writer, level int) (io.WriteCloser, error) {
+	return gzip.NewWriterLevel(w, level)
+}
+
+// gzip格式的流式解压
+func NewGzipReader(r io.Reader) (io.ReadCloser, error) {
+	return gzip.NewReader(r)
+}
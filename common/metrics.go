@@ -0,0 +1,32 @@
+package common
+
+import (
+	"expvar"
+)
+
+// 获取或创建运行指标, 通过 LISTENPORT 的 /debug/vars 查看
+func NewMetrics(name string) *expvar.Map {
+	if v, ok := expvar.Get(name).(*expvar.Map); ok {
+		return v
+	}
+	return expvar.NewMap(name)
+}
+
+// 设置指标的当前值
+func SetMetric(m *expvar.Map, key string, value int64) {
+	if v, ok := m.Get(key).(*expvar.Int); ok {
+		v.Set(value)
+		return
+	}
+	v := new(expvar.Int)
+	v.Set(value)
+	m.Set(key, v)
+}
+
+// 获取指标的当前值
+func GetMetric(m *expvar.Map, key string) int64 {
+	if v, ok := m.Get(key).(*expvar.Int); ok {
+		return v.Value()
+	}
+	return 0
+}
@@ -20,32 +20,33 @@ type RedisConfig struct {
 }
 
 var (
-	RedisPool *redis.Pool
+	RedisPool *redis.Pool // RedisGetData等函数使用的连接池, 由调用方通过NewRedisPool创建后设置
 )
 
-func initRedisPool() {
-	RedisPool = &redis.Pool{
-		MaxIdle:     int(REDISCONF.MaxIdle),
-		MaxActive:   int(REDISCONF.MaxActive),
-		IdleTimeout: time.Duration(REDISCONF.IdleTimeout) * time.Second,
-		Wait:        REDISCONF.Wait,
+// 创建redis连接池, 连接在首次使用时建立
+func NewRedisPool(conf *RedisConfig) *redis.Pool {
+	return &redis.Pool{
+		MaxIdle:     int(conf.MaxIdle),
+		MaxActive:   int(conf.MaxActive),
+		IdleTimeout: time.Duration(conf.IdleTimeout) * time.Second,
+		Wait:        conf.Wait,
 		Dial: func() (redis.Conn, error) {
 			conn, err := redis.Dial("tcp",
-				REDISCONF.Host+":"+REDISCONF.Port,
-				redis.DialConnectTimeout(time.Duration(REDISCONF.ConnTimeout)*time.Millisecond),
-				redis.DialReadTimeout(time.Duration(REDISCONF.ReadTimeout)*time.Millisecond),
-				redis.DialWriteTimeout(time.Duration(REDISCONF.WriteTimeout)*time.Millisecond),
+				conf.Host+":"+conf.Port,
+				redis.DialConnectTimeout(time.Duration(conf.ConnTimeout)*time.Millisecond),
+				redis.DialReadTimeout(time.Duration(conf.ReadTimeout)*time.Millisecond),
+				redis.DialWriteTimeout(time.Duration(conf.WriteTimeout)*time.Millisecond),
 			)
 			if err != nil {
 				return nil, err
 			}
 			//认证
-			if len(REDISCONF.Auth) > 0 {
-				conn.Do("AUTH", REDISCONF.Auth)
+			if len(conf.Auth) > 0 {
+				conn.Do("AUTH", conf.Auth)
 			}
 			// 选择db
-			if len(REDISCONF.DbName) > 0 {
-				conn.Do("SELECT", REDISCONF.DbName)
+			if len(conf.DbName) > 0 {
+				conn.Do("SELECT", conf.DbName)
 			} else {
 				conn.Do("SELECT", 0)
 			}
@@ -0,0 +1,213 @@
+package common
+
+import (
+	"bufio"
+	"bytes"
+	"os"
+	"strings"
+	"testing"
+)
+
+// 内存中的配置来源, 键为 段.键
+type mapSource map[string]string
+
+func (m mapSource) GetValue(section, key string) string {
+	return m[section+"."+key]
+}
+
+// 按行读取ini文件, 只处理段、键值及#注释
+func readIni(t *testing.T, fileName string) mapSource {
+	f, err := os.Open(fileName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	src := mapSource{}
+	section := ""
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if strings.HasPrefix(line, "[") && strings.HasSuffix(line, "]") {
+			section = line[1 : len(line)-1]
+			continue
+		}
+		if idx := strings.Index(line, "="); idx > 0 {
+			src[section+"."+strings.TrimSpace(line[:idx])] = strings.TrimSpace(line[idx+1:])
+		}
+	}
+	return src
+}
+
+func TestDevConfigIsValid(t *testing.T) {
+	conf, err := ParseConfig(readIni(t, "../conf/dev.ini"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if conf.CacheCapacity != 3600 || conf.KlineSampleNum != 300 || conf.Queues["persist"].Size != 3600 {
+		t.Errorf("unexpected config: %+v", conf)
+	}
+	if sinks := conf.PublishSinksMap["GT"]; len(sinks) != 2 || sinks[1] != "websocket" {
+		t.Errorf("unexpected sinks of GT: %v", sinks)
+	}
+}
+
+func TestParseConfigReportsAllProblems(t *testing.T) {
+	conf, err := ParseConfig(mapSource{
+		"db.host":                     "localhost",
+		"service.cache_capacity":      "abc",
+		"kline.kline_sample_number":   "10",
+		"kline.price_mode_GT":         "revenue",
+		"queue.sink_policy":           "drop_all",
+		"publish.sinks":               "rabbitmq, kafka",
+		"settlement.period":           "60",
+		"monitor.enabled":             "yes",
+		"kline.order_rate":            "1.5",
+		"store.driver":                "mysql",
+		"chain.sign_key":              "0011",
+		"retention.keep":              "1s:7d",
+		"rabbitmq.PushRoutineKeyList": "a, b",
+	})
+	configErr, ok := err.(*ConfigError)
+	if !ok {
+		t.Fatalf("expected ConfigError, got %v", err)
+	}
+	expected := []string{
+		"service.cache_capacity", "kline.kline_sample_number", "kline.price_mode_GT", "queue.sink_policy",
+		"publish.sinks", "settlement.period", "monitor.enabled", "chain.sign_key",
+	}
+	if len(configErr.Problems) != len(expected) {
+		t.Fatalf("expected %d problems, got %d: %v", len(expected), len(configErr.Problems), configErr.Problems)
+	}
+	for _, key := range expected {
+		if !strings.Contains(configErr.Error(), key+":") {
+			t.Errorf("missing problem of %s: %s", key, configErr)
+		}
+	}
+	// 无法解析的值保留默认值, 其余配置正常解析
+	if conf.CacheCapacity != 100000 || conf.OrderRate != 1.5 || conf.RetentionKeep["1s"] != 7*DAY_SECONDS {
+		t.Errorf("unexpected config: %+v", conf)
+	}
+}
+
+// 待保存的K线不能被丢弃
+func TestPersistQueueOnlyBlocks(t *testing.T) {
+	for _, policy := range []string{QUEUE_POLICY_DROP_OLDEST, QUEUE_POLICY_DROP_NEWEST, QUEUE_POLICY_COALESCE} {
+		_, err := ParseConfig(mapSource{"db.host": "localhost", "queue.persist_policy": policy})
+		if configErr, ok := err.(*ConfigError); !ok || len(configErr.Problems) != 1 || !strings.Contains(configErr.Error(), "queue.persist_policy:") {
+			t.Errorf("persist policy %s should be rejected, err: %v", policy, err)
+		}
+	}
+	conf, err := ParseConfig(mapSource{"db.host": "localhost", "queue.persist_policy": QUEUE_POLICY_BLOCK, "queue.sink_policy": QUEUE_POLICY_DROP_NEWEST})
+	if err != nil || conf.Queues["persist"].Policy != QUEUE_POLICY_BLOCK {
+		t.Errorf("block should be allowed for the persist queue, err: %v", err)
+	}
+}
+
+func TestConfigReportRedactsSecrets(t *testing.T) {
+	conf, err := ParseConfig(mapSource{
+		"db.host":              "localhost",
+		"db.password":          "db-secret",
+		"redis.auth":           "redis-secret",
+		"rabbitmq.RabbitMqUrl": "amqp://user:mq-secret@mq:5672",
+		"monitor.alert_url":    "https://alert.example.com/hook?token=alert-secret",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	if err := conf.WriteReport(&buf); err != nil {
+		t.Fatal(err)
+	}
+	report := buf.String()
+	for _, secret := range []string{"db-secret", "redis-secret", "mq-secret", "alert-secret"} {
+		if strings.Contains(report, secret) {
+			t.Errorf("report contains %s:\n%s", secret, report)
+		}
+	}
+	for _, line := range []string{
+		"db.host = localhost (file)\n", "db.password = ****** (file)\n",
+		"rabbitmq.RabbitMqUrl = amqp://user:xxxxxx@mq:5672 (file)\n",
+		"kline.order_rate = 5 (default)\n",
+	} {
+		if !strings.Contains(report, line) {
+			t.Errorf("report missing %q:\n%s", line, report)
+		}
+	}
+}
+
+func TestConfigLayers(t *testing.T) {
+	flags, args, err := ParseConfigFlags([]string{
+		"--config", "/etc/option-kline.ini", "--kline.price_mode=adjust", "--db.port", "3307", "migrate", "-to", "3",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if flags.File != "/etc/option-kline.ini" || len(args) != 3 || args[0] != "migrate" {
+		t.Fatalf("unexpected flags: %+v, args: %v", flags, args)
+	}
+	file := mapSource{"db.host": "file-host", "db.port": "3306", "kline.price_mode": "passthrough", "store.driver": "mysql"}
+	env := NewEnvSource([]string{"OPTION_KLINE_DB_HOST=env-host", "OPTION_KLINE_DB_PORT=3308", "PATH=/bin"})
+	conf, err := ParseConfigLayers(
+		ConfigLayer{Name: CONFIG_SOURCE_FILE, Source: file},
+		ConfigLayer{Name: CONFIG_SOURCE_ENV, Source: env},
+		ConfigLayer{Name: CONFIG_SOURCE_FLAG, Source: flags},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if conf.DB.Host != "env-host" || conf.DB.Port != "3307" || conf.PriceMode != PRICE_MODE_ADJUST {
+		t.Errorf("unexpected config: %+v", conf)
+	}
+	sources := map[string]string{}
+	for _, item := range conf.Items() {
+		sources[item.Key] = item.Source
+	}
+	for key, source := range map[string]string{
+		"db.host": CONFIG_SOURCE_ENV, "db.port": CONFIG_SOURCE_FLAG, "kline.price_mode": CONFIG_SOURCE_FLAG,
+		"store.driver": CONFIG_SOURCE_FILE, "kline.order_rate": CONFIG_SOURCE_DEFAULT,
+	} {
+		if sources[key] != source {
+			t.Errorf("expected %s from %s, got %s", key, source, sources[key])
+		}
+	}
+
+	// 未知的环境变量及命令行配置, 通常为拼写错误
+	flags, _, err = ParseConfigFlags([]string{"--kline.price_mod=adjust"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = ParseConfigLayers(
+		ConfigLayer{Name: CONFIG_SOURCE_FILE, Source: file},
+		ConfigLayer{Name: CONFIG_SOURCE_ENV, Source: NewEnvSource([]string{"OPTION_KLINE_DB_HOTS=x"})},
+		ConfigLayer{Name: CONFIG_SOURCE_FLAG, Source: flags},
+	)
+	if err == nil || !strings.Contains(err.Error(), "OPTION_KLINE_DB_HOTS: unknown") ||
+		!strings.Contains(err.Error(), "kline.price_mod: unknown") {
+		t.Errorf("expected unknown keys, got %v", err)
+	}
+	if _, _, err := ParseConfigFlags([]string{"--verbose", "migrate"}); err == nil {
+		t.Error("expected error for flag without section")
+	}
+}
+
+// 已废弃的队列长度配置仍然生效并给出警告, 同时配置时以queue段为准
+func TestDeprecatedQueueSize(t *testing.T) {
+	conf, err := ParseConfig(mapSource{
+		"db.host":                   "localhost",
+		"publish.buffer_size":       "200",
+		"kline.persist_buffer_size": "7200",
+		"queue.persist_size":        "1800",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if conf.Queues["sink"].Size != 200 || conf.Queues["persist"].Size != 1800 {
+		t.Errorf("unexpected queues: %+v", conf.Queues)
+	}
+	if len(conf.Warnings()) != 2 || !strings.Contains(conf.Warnings()[0], "kline.persist_buffer_size is deprecated") {
+		t.Errorf("unexpected warnings: %v", conf.Warnings())
+	}
+}
@@ -0,0 +1,113 @@
+package common
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// 环境变量前缀, 完整名称为: 前缀段_键(大写), 如: OPTION_KLINE_DB_HOST
+const ConfigEnvPrefix = "OPTION_KLINE_"
+
+// 一层配置
+type ConfigLayer struct {
+	Name   string // 来源: file, env, flag
+	Source ConfigSource
+}
+
+// 可列出全部配置名的来源, 用于发现拼写错误等未知的配置
+type namedConfigSource interface {
+	ConfigSource
+	Names() []string                 // 来源中的全部配置名
+	Name(section, key string) string // 配置项在来源中的名称
+}
+
+// 环境变量中的配置
+type EnvSource struct {
+	values map[string]string
+}
+
+// environ格式与os.Environ()一致, 只保留以ConfigEnvPrefix开头的变量
+func NewEnvSource(environ []string) *EnvSource {
+	e := &EnvSource{values: map[string]string{}}
+	for _, kv := range environ {
+		if idx := strings.Index(kv, "="); idx > 0 && strings.HasPrefix(kv, ConfigEnvPrefix) {
+			e.values[kv[:idx]] = kv[idx+1:]
+		}
+	}
+	return e
+}
+
+func (e *EnvSource) GetValue(section, key string) string {
+	return e.values[e.Name(section, key)]
+}
+
+func (e *EnvSource) Name(section, key string) string {
+	return ConfigEnvPrefix + strings.ToUpper(section+"_"+key)
+}
+
+func (e *EnvSource) Names() []string {
+	names := make([]string, 0, len(e.values))
+	for name := range e.values {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// 命令行中的配置: --config 配置文件, --段.键=值 或 --段.键 值
+type ConfigFlags struct {
+	File   string // 配置文件路径, 为空时使用conf/{mode}.ini
+	values map[string]string
+	names  []string
+}
+
+// 解析子命令之前的参数, 返回配置及剩余的参数(子命令及其参数)
+func ParseConfigFlags(args []string) (*ConfigFlags, []string, error) {
+	f := &ConfigFlags{values: map[string]string{}}
+	for len(args) > 0 {
+		arg := args[0]
+		if arg == "--" {
+			return f, args[1:], nil
+		}
+		if !strings.HasPrefix(arg, "-") {
+			break
+		}
+		name := strings.TrimLeft(arg, "-")
+		args = args[1:]
+		value, hasValue := "", false
+		if idx := strings.Index(name, "="); idx >= 0 {
+			name, value, hasValue = name[:idx], name[idx+1:], true
+		}
+		if !hasValue {
+			if len(args) == 0 {
+				return nil, nil, fmt.Errorf("flag needs a value: %s", arg)
+			}
+			value, args = args[0], args[1:]
+		}
+		switch {
+		case name == "config":
+			f.File = value
+		case strings.Count(name, ".") == 1 && !strings.HasPrefix(name, ".") && !strings.HasSuffix(name, "."):
+			if _, ok := f.values[name]; !ok {
+				f.names = append(f.names, name)
+			}
+			f.values[name] = value
+		default:
+			return nil, nil, fmt.Errorf("unknown flag: %s, expected --config or --section.key", arg)
+		}
+	}
+	return f, args, nil
+}
+
+func (f *ConfigFlags) GetValue(section, key string) string {
+	return f.values[f.Name(section, key)]
+}
+
+func (f *ConfigFlags) Name(section, key string) string {
+	return section + "." + key
+}
+
+func (f *ConfigFlags) Names() []string {
+	return f.names
+}
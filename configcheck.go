@@ -0,0 +1,54 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"option-kline/common"
+	"os"
+)
+
+// 检查配置: option-kline [--config 配置文件] [--段.键=值] config check [-f 配置文件]
+// 与启动时一样叠加配置文件、环境变量及命令行参数, 不读取option_setting
+// 输出生效的配置及来源(密码、密钥等已隐藏)及所有不合法的配置项, 配置不合法时返回错误
+func runConfig(args []string, flags *common.ConfigFlags) error {
+	if len(args) == 0 || args[0] != "check" {
+		return fmt.Errorf("usage: option-kline config check [-f file]")
+	}
+	fs := flag.NewFlagSet("config check", flag.ContinueOnError)
+	file := fs.String("f", flags.File, "config file, default: --config or conf/$SERVERMODE.ini")
+	quiet := fs.Bool("q", false, "only print invalid items")
+	if err := fs.Parse(args[1:]); err != nil {
+		return err
+	}
+	if *file == "" {
+		if err := common.InitMode(); err != nil {
+			return err
+		}
+		*file = common.ConfigFileName()
+	}
+	flags.File = *file
+
+	conf, err := common.ParseLayeredConfig(flags, os.Environ())
+	if conf == nil {
+		return err
+	}
+	if !*quiet {
+		if err := conf.WriteReport(os.Stdout); err != nil {
+			return err
+		}
+	}
+	for _, warning := range conf.Warnings() {
+		fmt.Fprintf(os.Stderr, "warning: %s\n", warning)
+	}
+	if configErr, ok := err.(*common.ConfigError); ok {
+		for _, problem := range configErr.Problems {
+			fmt.Fprintf(os.Stderr, "invalid: %s\n", problem)
+		}
+		return fmt.Errorf("%s: %d invalid config item(s)", *file, len(configErr.Problems))
+	}
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "%s: ok\n", *file)
+	return nil
+}
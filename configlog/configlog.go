@@ -0,0 +1,242 @@
+package configlog
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"github.com/jinzhu/gorm"
+	log "github.com/sirupsen/logrus"
+	"option-kline/common"
+	"option-kline/sink"
+	"option-kline/store"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// 配置变更事件类型
+const EventConfigChange = "config_change"
+
+const TableName = "option_setting_log"
+
+// db table: option_setting_log
+// 一条生效的动态配置变更, 只追加不修改, 每条记录包含上一条记录的哈希
+type Entry struct {
+	Id         int64  `gorm:"column:id" json:"id"`
+	KeyName    string `gorm:"column:keyName" json:"key"`
+	OldValue   string `gorm:"column:oldValue" json:"oldValue"`
+	NewValue   string `gorm:"column:newValue" json:"newValue"`
+	UpdateTime int64  `gorm:"column:updateTime" json:"updateTime"` // option_setting中该配置的更新时间
+	AppliedAt  int64  `gorm:"column:appliedAt" json:"appliedAt"`   // 生效时间
+	PrevHash   string `gorm:"column:prevHash" json:"prevHash"`
+	Hash       string `gorm:"column:hash" json:"hash"`
+}
+
+// 记录内容及上一条记录哈希的SHA-256, 十六进制
+func Hash(e *Entry) string {
+	content := strings.Join([]string{
+		e.KeyName, e.OldValue, e.NewValue,
+		strconv.FormatInt(e.UpdateTime, 10), strconv.FormatInt(e.AppliedAt, 10),
+		e.PrevHash,
+	}, "|")
+	sum := sha256.Sum256([]byte(content))
+	return hex.EncodeToString(sum[:])
+}
+
+// 配置变更记录存储, 只提供追加及查询
+type Store interface {
+	Append(e *Entry) error
+	// 最后一条记录, 无记录时返回nil
+	Last() (*Entry, error)
+	// 查询生效时间在[begin, end)内的记录, 按记录顺序排列; key为空时查询所有配置, limit为0时不限制数量
+	List(key string, begin, end int64, limit int) ([]*Entry, error)
+}
+
+// 与K线存储使用相同的数据库, 内存K线存储使用内存记录存储
+func NewStoreFor(s store.CandleStore) Store {
+	if sqlStore, ok := s.(*store.SQLStore); ok {
+		return NewSQLStore(sqlStore.DB())
+	}
+	return NewMemoryStore()
+}
+
+type SQLStore struct {
+	db *gorm.DB
+}
+
+func NewSQLStore(db *gorm.DB) *SQLStore {
+	return &SQLStore{db: db}
+}
+
+func (s *SQLStore) Append(e *Entry) error {
+	return s.db.Table(TableName).Create(e).Error
+}
+
+func (s *SQLStore) Last() (*Entry, error) {
+	entries := []*Entry{}
+	if err := s.db.Table(TableName).Order("id desc").Limit(1).Find(&entries).Error; err != nil {
+		return nil, err
+	}
+	if len(entries) == 0 {
+		return nil, nil
+	}
+	return entries[0], nil
+}
+
+func (s *SQLStore) List(key string, begin, end int64, limit int) (entries []*Entry, err error) {
+	db := s.db.Table(TableName).Where("appliedAt >= ? AND appliedAt < ?", begin, end)
+	if key != "" {
+		db = db.Where("keyName = ?", key)
+	}
+	if limit > 0 {
+		db = db.Limit(limit)
+	}
+	if err = db.Order("id").Find(&entries).Error; err != nil {
+		return nil, err
+	}
+	return
+}
+
+// 内存记录存储, 用于测试及内存K线存储
+type MemoryStore struct {
+	mutex   sync.RWMutex
+	entries []*Entry
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{}
+}
+
+func (s *MemoryStore) Append(e *Entry) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	e.Id = int64(len(s.entries) + 1)
+	entry := *e
+	s.entries = append(s.entries, &entry)
+	return nil
+}
+
+func (s *MemoryStore) Last() (*Entry, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	if len(s.entries) == 0 {
+		return nil, nil
+	}
+	entry := *s.entries[len(s.entries)-1]
+	return &entry, nil
+}
+
+func (s *MemoryStore) List(key string, begin, end int64, limit int) ([]*Entry, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	entries := []*Entry{}
+	for _, e := range s.entries {
+		if e.AppliedAt < begin || e.AppliedAt >= end || key != "" && e.KeyName != key {
+			continue
+		}
+		if limit > 0 && len(entries) >= limit {
+			break
+		}
+		entry := *e
+		entries = append(entries, &entry)
+	}
+	return entries, nil
+}
+
+// 检查所有记录的哈希及链接, 返回第一处错误
+func Verify(s Store) error {
+	entries, err := s.List("", 0, 1<<62, 0)
+	if err != nil {
+		return err
+	}
+	prevHash := ""
+	for _, e := range entries {
+		if e.PrevHash != prevHash {
+			return fmt.Errorf("entry %d: prevHash %s, previous entry hash %s", e.Id, e.PrevHash, prevHash)
+		}
+		if hash := Hash(e); hash != e.Hash {
+			return fmt.Errorf("entry %d: stored hash %s, computed %s", e.Id, e.Hash, hash)
+		}
+		prevHash = e.Hash
+	}
+	return nil
+}
+
+// 配置变更记录: 保存每条生效的变更并推送事件
+type Log struct {
+	store    Store
+	events   sink.EventPublisher // 为nil时不推送
+	clock    common.Clock
+	mutex    sync.Mutex
+	prevHash string
+	loaded   bool     // 是否已读取最后一条记录的哈希
+	pending  []*Entry // 未保存的变更, 下次记录时按顺序重试
+}
+
+func New(s Store, events sink.EventPublisher, clock common.Clock) *Log {
+	return &Log{store: s, events: events, clock: clock}
+}
+
+func (l *Log) Store() Store {
+	return l.store
+}
+
+// 记录配置变更, 按顺序保存此前未保存的及本次的变更, 保存后推送事件.
+// 保存失败时返回错误, 未保存的变更保留至下次记录时重试, 不推送
+func (l *Log) Record(changes []common.ConfigChange) error {
+	fn := "ConfigLog.Record"
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	appliedAt := l.clock.Now().Unix()
+	for _, change := range changes {
+		l.pending = append(l.pending, &Entry{
+			KeyName:    change.Key,
+			OldValue:   change.OldValue,
+			NewValue:   change.NewValue,
+			UpdateTime: change.UpdateTime,
+			AppliedAt:  appliedAt,
+		})
+	}
+	if len(l.pending) == 0 {
+		return nil
+	}
+	if !l.loaded {
+		last, err := l.store.Last()
+		if err != nil {
+			return fmt.Errorf("failed to load the last entry, %d changes pending: %s", len(l.pending), err)
+		}
+		if last != nil {
+			l.prevHash = last.Hash
+		}
+		l.loaded = true
+	}
+	for len(l.pending) > 0 {
+		e := l.pending[0]
+		e.PrevHash = l.prevHash
+		e.Hash = Hash(e)
+		if err := l.store.Append(e); err != nil {
+			return fmt.Errorf("failed to save config change %s, %d changes pending: %s", e.KeyName, len(l.pending), err)
+		}
+		l.prevHash = e.Hash
+		l.pending = l.pending[1:]
+		if l.events != nil {
+			if err := l.events.PublishEvent(EventConfigChange, e); err != nil {
+				log.Errorf("[%s]failed to publish config change: %s, %+v", fn, err, e)
+			}
+		}
+	}
+	return nil
+}
+
+// 各配置最后记录的值
+func (l *Log) LastValues() (map[string]string, error) {
+	entries, err := l.store.List("", 0, 1<<62, 0)
+	if err != nil {
+		return nil, err
+	}
+	values := map[string]string{}
+	for _, e := range entries {
+		values[e.KeyName] = e.NewValue
+	}
+	return values, nil
+}
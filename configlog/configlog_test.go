@@ -0,0 +1,122 @@
+package configlog
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http/httptest"
+	"option-kline/common"
+	"option-kline/sink"
+	"option-kline/store"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestRecordConfigChanges(t *testing.T) {
+	dir, err := ioutil.TempDir("", "configlog")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	s, err := store.NewSQLiteStore(dir + "/kline.db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	// 动态配置为全局变量, 测试结束后恢复
+	orderRate, amplitude := common.OrderRate.Load(), common.PriceAmplitude.Load()
+	defer func() {
+		common.OrderRate.Store(orderRate)
+		common.PriceAmplitude.Store(amplitude)
+	}()
+	common.OrderRate.Store(5.0)
+	common.PriceAmplitude.Store(100)
+
+	db := s.DB()
+	db.Create(&common.OptionSetting{KeyName: "order_rate", Value: "5", UpdateTime: 100})
+	db.Create(&common.OptionSetting{KeyName: "price_amplitude", Value: "120", UpdateTime: 101})
+	db.Create(&common.OptionSetting{KeyName: "unknown", Value: "1", UpdateTime: 102})
+
+	clock := common.NewFakeClock(time.Unix(1546065000, 0))
+	events := sink.NewMemorySink()
+	l := New(NewSQLStore(db), events, clock)
+	l.Record(common.LoadConfigFromDB(db))
+	// 值未变化时不记录
+	clock.Advance(5 * time.Second)
+	l.Record(common.LoadConfigFromDB(db))
+	db.Model(&common.OptionSetting{}).Where("keyName = ?", "order_rate").Updates(map[string]interface{}{"value": "4.5", "updateTime": 200})
+	l.Record(common.LoadConfigFromDB(db))
+	// 无效的值不生效, 不记录
+	db.Model(&common.OptionSetting{}).Where("keyName = ?", "price_amplitude").Update("value", "abc")
+	l.Record(common.LoadConfigFromDB(db))
+
+	h := NewHandler(l.Store())
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", "/api/config/changes?verify=1", nil))
+	result := Result{}
+	if err := json.Unmarshal(w.Body.Bytes(), &result); err != nil {
+		t.Fatal(err)
+	}
+	if len(result.Entries) != 2 || result.Verified == nil || !*result.Verified {
+		t.Fatalf("unexpected result: %s", w.Body.String())
+	}
+	if e := result.Entries[0]; e.KeyName != "price_amplitude" || e.OldValue != "100" || e.NewValue != "120" || e.UpdateTime != 101 || e.AppliedAt != 1546065000 {
+		t.Errorf("unexpected entry: %+v", e)
+	}
+	if e := result.Entries[1]; e.KeyName != "order_rate" || e.OldValue != "5" || e.NewValue != "4.5" || e.UpdateTime != 200 || e.PrevHash != result.Entries[0].Hash {
+		t.Errorf("unexpected entry: %+v", e)
+	}
+	if list := events.Events(); len(list) != 2 || list[1].Type != EventConfigChange || list[1].Data.(*Entry).NewValue != "4.5" {
+		t.Errorf("each change should be published: %+v", list)
+	}
+
+	// 重启后继续之前的哈希链, 修改记录后检查失败
+	l = New(NewSQLStore(db), nil, clock)
+	l.Record([]common.ConfigChange{{Key: "kline_sample_number", OldValue: "200", NewValue: "300"}})
+	if err := Verify(l.Store()); err != nil {
+		t.Errorf("log should verify after restart: %s", err)
+	}
+	db.Table(TableName).Where("keyName = ?", "order_rate").Update("newValue", "6")
+	if err := Verify(l.Store()); err == nil {
+		t.Error("modified entry should fail verification")
+	}
+}
+
+// 保存失败指定次数
+type failingStore struct {
+	Store
+	failures int
+}
+
+func (s *failingStore) Append(e *Entry) error {
+	if s.failures > 0 {
+		s.failures--
+		return fmt.Errorf("database is locked")
+	}
+	return s.Store.Append(e)
+}
+
+// 保存失败时返回错误且不推送, 下次记录时按顺序重试
+func TestRecordRetryFailedAppend(t *testing.T) {
+	s := &failingStore{Store: NewMemoryStore(), failures: 1}
+	events := sink.NewMemorySink()
+	l := New(s, events, common.NewFakeClock(time.Unix(1546065000, 0)))
+
+	if err := l.Record([]common.ConfigChange{{Key: "price_amplitude", OldValue: "100", NewValue: "120"}}); err == nil {
+		t.Fatal("failed append should be returned")
+	}
+	if list, _ := s.List("", 0, 1<<40, 0); len(list) != 0 || len(events.Events()) != 0 {
+		t.Fatalf("unsaved change should not be published: %+v", events.Events())
+	}
+	if err := l.Record([]common.ConfigChange{{Key: "order_rate", OldValue: "5", NewValue: "4.5"}}); err != nil {
+		t.Fatalf("failed to record: %s", err)
+	}
+	list, _ := s.List("", 0, 1<<40, 0)
+	if len(list) != 2 || list[0].KeyName != "price_amplitude" || list[1].KeyName != "order_rate" || len(events.Events()) != 2 {
+		t.Errorf("pending change should be saved first: %+v", list)
+	}
+	if err := Verify(s); err != nil {
+		t.Errorf("log should verify after retry: %s", err)
+	}
+}
@@ -0,0 +1,67 @@
+package configlog
+
+import (
+	"encoding/json"
+	"net/http"
+	"option-kline/common"
+	"strconv"
+)
+
+const defaultLimit = 100 // 默认返回的记录数量
+
+// 配置变更记录查询接口:
+// GET {ConfigLogPath}?key=order_rate&from=时间&to=时间&limit=100
+// 所有参数可选, 时间为unix秒或RFC3339; verify=1时检查所有记录的哈希链
+type Handler struct {
+	store Store
+}
+
+func NewHandler(s Store) *Handler {
+	return &Handler{store: s}
+}
+
+// 查询结果
+type Result struct {
+	Entries  []*Entry `json:"entries"`
+	Verified *bool    `json:"verified,omitempty"` // verify=1时返回
+	Error    string   `json:"error,omitempty"`    // 哈希链检查发现的错误
+}
+
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	begin, end, limit := int64(0), int64(1<<62), defaultLimit
+	var err error
+	if val := query.Get("from"); val != "" {
+		if begin, err = common.ParseTime(val); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+	if val := query.Get("to"); val != "" {
+		if end, err = common.ParseTime(val); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+	if val := query.Get("limit"); val != "" {
+		if limit, err = strconv.Atoi(val); err != nil || limit <= 0 {
+			http.Error(w, "invalid limit: "+val, http.StatusBadRequest)
+			return
+		}
+	}
+	result := Result{}
+	if result.Entries, err = h.store.List(query.Get("key"), begin, end, limit); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if query.Get("verify") == "1" {
+		err := Verify(h.store)
+		verified := err == nil
+		result.Verified = &verified
+		if err != nil {
+			result.Error = err.Error()
+		}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(result)
+}
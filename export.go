@@ -0,0 +1,67 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"option-kline/common"
+	"option-kline/export"
+	"option-kline/kline"
+	"os"
+)
+
+// 导出K线: option-kline export -coin 币种 -from 时间 -to 时间 [选项]
+func runExport(args []string) error {
+	fs := flag.NewFlagSet("export", flag.ContinueOnError)
+	coin := fs.String("coin", "", "coin type")
+	interval := fs.String("interval", kline.Interval1s.Name, "kline interval: 1s, 1m, 5m, 15m, 1h, 1d")
+	from := fs.String("from", "", "begin time, unix seconds or RFC3339")
+	to := fs.String("to", "", "end time (exclusive), unix seconds or RFC3339")
+	format := fs.String("format", export.FormatCSV, "output format: csv, jsonl, parquet")
+	gzip := fs.Bool("gzip", false, "gzip the output")
+	output := fs.String("o", "", "output file, default: stdout")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *coin == "" || *from == "" || *to == "" {
+		fs.Usage()
+		return fmt.Errorf("usage: option-kline export -coin coinType -from time -to time [options]")
+	}
+
+	opts := export.Options{CoinType: *coin, Format: *format, Gzip: *gzip}
+	var err error
+	if opts.Interval, err = kline.ParseInterval(*interval); err != nil {
+		return err
+	}
+	if opts.Begin, err = common.ParseTime(*from); err != nil {
+		return err
+	}
+	if opts.End, err = common.ParseTime(*to); err != nil {
+		return err
+	}
+	if err := opts.Validate(); err != nil {
+		return err
+	}
+
+	s, closeStore, err := openStore()
+	if err != nil {
+		return err
+	}
+	defer closeStore()
+	out := os.Stdout
+	if *output != "" {
+		if out, err = os.Create(*output); err != nil {
+			return err
+		}
+	}
+	count, err := export.Export(s, out, opts)
+	if out != os.Stdout {
+		if cerr := out.Close(); err == nil {
+			err = cerr
+		}
+	}
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "exported %d klines\n", count)
+	return nil
+}
@@ -0,0 +1,185 @@
+package export
+
+import (
+	"bufio"
+	"compress/flate"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"option-kline/common"
+	"option-kline/kline"
+	"option-kline/store"
+	"strconv"
+)
+
+// 导出格式
+const (
+	FormatCSV     = "csv"
+	FormatJsonl   = "jsonl"
+	FormatParquet = "parquet"
+)
+
+// 每次查询的K线数量上限, 按时间窗口分批读取, 避免一次加载整个范围
+const exportRows = 10000
+
+// CSV表头, 与option_kline的列名一致
+var csvHeader = []string{"coinType", "time", "open", "high", "low", "close", "lastupdate", "origin"}
+
+// 导出配置
+type Options struct {
+	CoinType string
+	Interval kline.Interval
+	Begin    int64 // 导出[Begin, End)内的K线
+	End      int64
+	Format   string
+	Gzip     bool
+}
+
+// 检查导出配置
+func (o Options) Validate() error {
+	if o.CoinType == "" {
+		return fmt.Errorf("coin type is required")
+	}
+	if o.Interval.Seconds <= 0 {
+		return fmt.Errorf("interval is required")
+	}
+	if o.Begin >= o.End {
+		return fmt.Errorf("invalid time range: [%d, %d)", o.Begin, o.End)
+	}
+	switch o.Format {
+	case FormatCSV, FormatJsonl, FormatParquet:
+	default:
+		return fmt.Errorf("format not supported: %s", o.Format)
+	}
+	return nil
+}
+
+// 导出文件名, 如: GT_1m_1546063200_1546066800.csv.gz
+func (o Options) FileName() string {
+	name := fmt.Sprintf("%s_%s_%d_%d.%s", o.CoinType, o.Interval, o.Begin, o.End, o.Format)
+	if o.Gzip {
+		name += ".gz"
+	}
+	return name
+}
+
+// 导出文件的Content-Type
+func (o Options) ContentType() string {
+	if o.Gzip {
+		return "application/gzip"
+	}
+	switch o.Format {
+	case FormatCSV:
+		return "text/csv; charset=utf-8"
+	case FormatJsonl:
+		return "application/x-ndjson"
+	}
+	return "application/octet-stream"
+}
+
+// 单条K线的写入, 写入完成后调用Close输出剩余数据
+type candleWriter interface {
+	Write(k *kline.OptionKline) error
+	Close() error
+}
+
+// 按时间升序导出K线到w, 返回导出的数量
+func Export(s store.CandleStore, w io.Writer, opts Options) (int, error) {
+	if err := opts.Validate(); err != nil {
+		return 0, err
+	}
+	out := w
+	var gz io.WriteCloser
+	if opts.Gzip {
+		var err error
+		if gz, err = common.NewGzipWriter(w, flate.DefaultCompression); err != nil {
+			return 0, err
+		}
+		out = gz
+	}
+	bw := bufio.NewWriter(out)
+	var cw candleWriter
+	switch opts.Format {
+	case FormatCSV:
+		cw = newCSVWriter(bw)
+	case FormatJsonl:
+		cw = newJsonlWriter(bw)
+	case FormatParquet:
+		cw = newParquetWriter(bw)
+	}
+
+	count := 0
+	window := exportRows * opts.Interval.Seconds
+	for begin := opts.Begin; begin < opts.End; begin += window {
+		end := begin + window
+		if end > opts.End {
+			end = opts.End
+		}
+		klineList, err := s.Range(opts.Interval, opts.CoinType, begin, end)
+		if err != nil {
+			return count, err
+		}
+		for _, k := range klineList {
+			if err := cw.Write(k); err != nil {
+				return count, err
+			}
+			count++
+		}
+	}
+	if err := cw.Close(); err != nil {
+		return count, err
+	}
+	if err := bw.Flush(); err != nil {
+		return count, err
+	}
+	if gz != nil {
+		if err := gz.Close(); err != nil {
+			return count, err
+		}
+	}
+	return count, nil
+}
+
+type csvWriter struct {
+	w *csv.Writer
+}
+
+// 无数据时也输出表头, 写入错误在Close时返回
+func newCSVWriter(w io.Writer) *csvWriter {
+	c := &csvWriter{w: csv.NewWriter(w)}
+	c.w.Write(csvHeader)
+	return c
+}
+
+func (c *csvWriter) Write(k *kline.OptionKline) error {
+	return c.w.Write([]string{k.CoinType, strconv.FormatInt(k.Time, 10), k.Open, k.High, k.Low, k.Close,
+		strconv.FormatInt(k.LastUpdate, 10), strconv.Itoa(int(k.Origin))})
+}
+
+func (c *csvWriter) Close() error {
+	c.w.Flush()
+	return c.w.Error()
+}
+
+// JSON-lines中的K线, 与推送的K线格式一致, 增加origin字段
+type jsonlRecord struct {
+	*kline.OptionKline
+	Origin uint8 `json:"origin"`
+}
+
+type jsonlWriter struct {
+	enc *json.Encoder
+}
+
+func newJsonlWriter(w io.Writer) *jsonlWriter {
+	return &jsonlWriter{enc: json.NewEncoder(w)}
+}
+
+func (j *jsonlWriter) Write(k *kline.OptionKline) error {
+	return j.enc.Encode(jsonlRecord{OptionKline: k, Origin: k.Origin})
+}
+
+func (j *jsonlWriter) Close() error {
+	return nil
+}
@@ -0,0 +1,200 @@
+package export
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"io/ioutil"
+	"math"
+	"option-kline/common"
+	"option-kline/kline"
+	"option-kline/store"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// 第i秒的K线, 价格每秒增加0.0001
+func testKline(i int) *kline.OptionKline {
+	price := strconv.FormatFloat(6.8+float64(i)/10000, 'f', 4, 64)
+	return &kline.OptionKline{CoinType: "GT", Open: price, Close: price, High: price, Low: price,
+		Time: int64(1000 + i), LastUpdate: int64(1000 + i), Origin: uint8(i % 2)}
+}
+
+func newTestStore(t *testing.T, n int) store.CandleStore {
+	s := store.NewMemoryStore()
+	for i := 0; i < n; i++ {
+		if err := s.Append(kline.Interval1s, testKline(i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return s
+}
+
+func TestExportCSVAndJsonl(t *testing.T) {
+	s := newTestStore(t, 10)
+	buf := &bytes.Buffer{}
+	opts := Options{CoinType: "GT", Interval: kline.Interval1s, Begin: 1002, End: 1005, Format: FormatCSV, Gzip: true}
+	count, err := Export(s, buf, opts)
+	if err != nil || count != 3 {
+		t.Fatalf("export csv: %d, %v", count, err)
+	}
+	r, err := common.NewGzipReader(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := "coinType,time,open,high,low,close,lastupdate,origin\n" +
+		"GT,1002,6.8002,6.8002,6.8002,6.8002,1002,0\n" +
+		"GT,1003,6.8003,6.8003,6.8003,6.8003,1003,1\n" +
+		"GT,1004,6.8004,6.8004,6.8004,6.8004,1004,0\n"
+	if string(data) != expected {
+		t.Fatalf("unexpected csv:\n%s", data)
+	}
+
+	buf.Reset()
+	opts.Format, opts.Gzip = FormatJsonl, false
+	if _, err := Export(s, buf, opts); err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 3 {
+		t.Fatalf("unexpected jsonl:\n%s", buf.String())
+	}
+	record := map[string]interface{}{}
+	if err := json.Unmarshal([]byte(lines[1]), &record); err != nil {
+		t.Fatal(err)
+	}
+	if record["coinType"] != "GT" || record["time"] != float64(1003) || record["close"] != "6.8003" || record["origin"] != float64(1) {
+		t.Fatalf("unexpected record: %v", record)
+	}
+}
+
+func TestExportParquet(t *testing.T) {
+	n := parquetRowGroupRows + 10
+	s := newTestStore(t, n)
+	buf := &bytes.Buffer{}
+	opts := Options{CoinType: "GT", Interval: kline.Interval1s, Begin: 0, End: 1 << 32, Format: FormatParquet}
+	if count, err := Export(s, buf, opts); err != nil || count != n {
+		t.Fatalf("export parquet: %d, %v", count, err)
+	}
+
+	data := buf.Bytes()
+	if string(data[:4]) != parquetMagic || string(data[len(data)-4:]) != parquetMagic {
+		t.Fatal("invalid magic")
+	}
+	size := int(binary.LittleEndian.Uint32(data[len(data)-8:]))
+	r := &thriftReader{data: data[len(data)-8-size : len(data)-8]}
+	meta := r.readStruct()
+	if meta[3].(int64) != int64(n) {
+		t.Fatalf("num rows: %v", meta[3])
+	}
+	schema := meta[2].([]interface{})
+	if len(schema) != len(parquetColumns)+1 {
+		t.Fatalf("schema: %v", schema)
+	}
+	groups := meta[4].([]interface{})
+	if len(groups) != 2 {
+		t.Fatalf("row groups: %d", len(groups))
+	}
+
+	// 读取每列的值并与原始K线比较
+	row := 0
+	for _, g := range groups {
+		group := g.(map[int16]interface{})
+		rows := int(group[3].(int64))
+		columns := map[string][]byte{}
+		for idx, c := range group[1].([]interface{}) {
+			chunk := c.(map[int16]interface{})[3].(map[int16]interface{})
+			name := string(chunk[3].([]interface{})[0].([]byte))
+			if name != parquetColumns[idx].name {
+				t.Fatalf("column %d: %s", idx, name)
+			}
+			page := &thriftReader{data: data[chunk[9].(int64):]}
+			header := page.readStruct()
+			pageSize := int(header[3].(int64))
+			columns[name] = page.data[page.pos : page.pos+pageSize]
+		}
+		for i := 0; i < rows; i++ {
+			k := testKline(row)
+			coinLen := int(binary.LittleEndian.Uint32(columns["coinType"]))
+			coinType := string(columns["coinType"][4 : 4+coinLen])
+			columns["coinType"] = columns["coinType"][4+coinLen:]
+			tm := int64(binary.LittleEndian.Uint64(columns["time"][i*8:]))
+			closePrice := math.Float64frombits(binary.LittleEndian.Uint64(columns["close"][i*8:]))
+			origin := int32(binary.LittleEndian.Uint32(columns["origin"][i*4:]))
+			if coinType != k.CoinType || tm != k.Time || strconv.FormatFloat(closePrice, 'f', 4, 64) != k.Close || origin != int32(k.Origin) {
+				t.Fatalf("row %d: %s %d %v %d", row, coinType, tm, closePrice, origin)
+			}
+			row++
+		}
+	}
+	if row != n {
+		t.Fatalf("read %d rows", row)
+	}
+}
+
+// 测试用的Thrift Compact解码, 结构体解码为字段id -> 值
+type thriftReader struct {
+	data []byte
+	pos  int
+}
+
+func (r *thriftReader) varint() uint64 {
+	v, n := binary.Uvarint(r.data[r.pos:])
+	r.pos += n
+	return v
+}
+
+func (r *thriftReader) zigzag() int64 {
+	v := r.varint()
+	return int64(v>>1) ^ -int64(v&1)
+}
+
+func (r *thriftReader) readValue(typ byte) interface{} {
+	switch typ {
+	case thriftI32, thriftI64:
+		return r.zigzag()
+	case thriftBinary:
+		n := int(r.varint())
+		v := r.data[r.pos : r.pos+n]
+		r.pos += n
+		return v
+	case thriftList:
+		header := r.data[r.pos]
+		r.pos++
+		size, elemType := int(header>>4), header&0x0f
+		if size == 15 {
+			size = int(r.varint())
+		}
+		list := make([]interface{}, 0, size)
+		for i := 0; i < size; i++ {
+			list = append(list, r.readValue(elemType))
+		}
+		return list
+	case thriftStruct:
+		return r.readStruct()
+	}
+	panic("unsupported thrift type " + strconv.Itoa(int(typ)))
+}
+
+func (r *thriftReader) readStruct() map[int16]interface{} {
+	fields := map[int16]interface{}{}
+	var id int16
+	for {
+		header := r.data[r.pos]
+		r.pos++
+		if header == thriftStop {
+			return fields
+		}
+		if delta := int16(header >> 4); delta != 0 {
+			id += delta
+		} else {
+			id = int16(r.zigzag())
+		}
+		fields[id] = r.readValue(header & 0x0f)
+	}
+}
@@ -0,0 +1,86 @@
+package export
+
+import (
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"net/http"
+	"option-kline/common"
+	"option-kline/kline"
+	"option-kline/store"
+)
+
+// K线导出接口:
+// GET {ExportPath}?coin=GT&interval=1m&from=时间&to=时间&format=csv&gzip=1
+// interval默认1s, format默认csv, 时间为unix秒或RFC3339
+type Handler struct {
+	store store.CandleStore
+}
+
+func NewHandler(s store.CandleStore) *Handler {
+	return &Handler{store: s}
+}
+
+// 解析请求参数
+func ParseQuery(r *http.Request) (opts Options, err error) {
+	query := r.URL.Query()
+	opts.CoinType = query.Get("coin")
+	opts.Format = FormatCSV
+	if val := query.Get("format"); val != "" {
+		opts.Format = val
+	}
+	opts.Interval = kline.Interval1s
+	if val := query.Get("interval"); val != "" {
+		if opts.Interval, err = kline.ParseInterval(val); err != nil {
+			return opts, err
+		}
+	}
+	if query.Get("from") == "" || query.Get("to") == "" {
+		return opts, fmt.Errorf("from and to are required")
+	}
+	if opts.Begin, err = common.ParseTime(query.Get("from")); err != nil {
+		return opts, err
+	}
+	if opts.End, err = common.ParseTime(query.Get("to")); err != nil {
+		return opts, err
+	}
+	opts.Gzip = query.Get("gzip") == "1" || query.Get("gzip") == "true"
+	return opts, opts.Validate()
+}
+
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	fn := "export.ServeHTTP"
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	opts, err := ParseQuery(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	w.Header().Set("Content-Type", opts.ContentType())
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", opts.FileName()))
+	tw := &trackWriter{w: w}
+	count, err := Export(h.store, tw, opts)
+	if err != nil {
+		log.Errorf("[%s]failed to export %s: %s", fn, opts.FileName(), err)
+		// 已开始输出后无法返回错误状态码, 客户端收到的文件不完整
+		if !tw.written {
+			w.Header().Del("Content-Disposition")
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+		return
+	}
+	log.Infof("[%s]exported %d klines to %s", fn, count, r.RemoteAddr)
+}
+
+// 记录是否已开始输出
+type trackWriter struct {
+	w       http.ResponseWriter
+	written bool
+}
+
+func (t *trackWriter) Write(p []byte) (int, error) {
+	t.written = true
+	return t.w.Write(p)
+}
@@ -0,0 +1,324 @@
+package export
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"option-kline/kline"
+	"strconv"
+)
+
+// 最小化的Parquet写入: 所有列为REQUIRED, 每个列块一个未压缩的PLAIN数据页(v1),
+// 文件元数据使用Thrift Compact编码; 整个文件可由gzip压缩
+// 格式参考: https://github.com/apache/parquet-format
+
+const (
+	parquetMagic        = "PAR1"
+	parquetRowGroupRows = 65536 // 每个行组的行数
+	parquetCreatedBy    = "option-kline export"
+)
+
+// Parquet物理类型
+const (
+	parquetInt32     = 1
+	parquetInt64     = 2
+	parquetDouble    = 5
+	parquetByteArray = 6
+)
+
+// Parquet编码, 枚举值见parquet.thrift
+const (
+	parquetEncodingPlain = 0
+	parquetEncodingRLE   = 3
+	parquetConvertedUTF8 = 0
+	parquetRequired      = 0
+	parquetDataPage      = 0
+	parquetUncompressed  = 0
+)
+
+type parquetColumn struct {
+	name string
+	typ  int32
+	utf8 bool
+	// 按物理类型PLAIN编码一个值
+	encode func(buf *bytes.Buffer, k *kline.OptionKline) error
+}
+
+// 导出的列, 顺序与CSV表头一致; 价格转为DOUBLE便于分析
+var parquetColumns = []parquetColumn{
+	{name: "coinType", typ: parquetByteArray, utf8: true, encode: func(buf *bytes.Buffer, k *kline.OptionKline) error {
+		binary.Write(buf, binary.LittleEndian, uint32(len(k.CoinType)))
+		buf.WriteString(k.CoinType)
+		return nil
+	}},
+	{name: "time", typ: parquetInt64, encode: func(buf *bytes.Buffer, k *kline.OptionKline) error {
+		return binary.Write(buf, binary.LittleEndian, k.Time)
+	}},
+	{name: "open", typ: parquetDouble, encode: priceEncoder(func(k *kline.OptionKline) string { return k.Open })},
+	{name: "high", typ: parquetDouble, encode: priceEncoder(func(k *kline.OptionKline) string { return k.High })},
+	{name: "low", typ: parquetDouble, encode: priceEncoder(func(k *kline.OptionKline) string { return k.Low })},
+	{name: "close", typ: parquetDouble, encode: priceEncoder(func(k *kline.OptionKline) string { return k.Close })},
+	{name: "lastupdate", typ: parquetInt64, encode: func(buf *bytes.Buffer, k *kline.OptionKline) error {
+		return binary.Write(buf, binary.LittleEndian, k.LastUpdate)
+	}},
+	{name: "origin", typ: parquetInt32, encode: func(buf *bytes.Buffer, k *kline.OptionKline) error {
+		return binary.Write(buf, binary.LittleEndian, int32(k.Origin))
+	}},
+}
+
+func priceEncoder(price func(k *kline.OptionKline) string) func(buf *bytes.Buffer, k *kline.OptionKline) error {
+	return func(buf *bytes.Buffer, k *kline.OptionKline) error {
+		val, err := strconv.ParseFloat(price(k), 64)
+		if err != nil {
+			return fmt.Errorf("invalid price of %s at %d: %s", k.CoinType, k.Time, err)
+		}
+		return binary.Write(buf, binary.LittleEndian, math.Float64bits(val))
+	}
+}
+
+// 已写入的列块
+type parquetChunk struct {
+	offset int64 // 数据页头在文件中的位置
+	size   int64 // 数据页头及数据的大小
+	values int64
+}
+
+// 已写入的行组
+type parquetRowGroup struct {
+	chunks []parquetChunk
+	rows   int64
+}
+
+// 记录写入位置的Writer
+type countWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
+
+type parquetWriter struct {
+	w      *countWriter
+	rows   []*kline.OptionKline // 当前行组缓存的K线
+	groups []parquetRowGroup
+	total  int64
+	err    error
+}
+
+func newParquetWriter(w io.Writer) *parquetWriter {
+	p := &parquetWriter{w: &countWriter{w: w}}
+	_, p.err = io.WriteString(p.w, parquetMagic)
+	return p
+}
+
+func (p *parquetWriter) Write(k *kline.OptionKline) error {
+	if p.err != nil {
+		return p.err
+	}
+	p.rows = append(p.rows, k)
+	if len(p.rows) >= parquetRowGroupRows {
+		p.err = p.flush()
+	}
+	return p.err
+}
+
+// 写入缓存的K线为一个行组
+func (p *parquetWriter) flush() error {
+	if len(p.rows) == 0 {
+		return nil
+	}
+	group := parquetRowGroup{rows: int64(len(p.rows))}
+	for _, col := range parquetColumns {
+		data := &bytes.Buffer{}
+		for _, k := range p.rows {
+			if err := col.encode(data, k); err != nil {
+				return err
+			}
+		}
+		t := &thriftWriter{}
+		t.beginStruct()
+		t.i32Field(1, parquetDataPage)
+		t.i32Field(2, int32(data.Len()))
+		t.i32Field(3, int32(data.Len()))
+		t.structField(5)
+		t.i32Field(1, int32(len(p.rows)))
+		t.i32Field(2, parquetEncodingPlain)
+		t.i32Field(3, parquetEncodingRLE)
+		t.i32Field(4, parquetEncodingRLE)
+		t.endStruct()
+		t.endStruct()
+
+		chunk := parquetChunk{offset: p.w.n, size: int64(t.buf.Len() + data.Len()), values: int64(len(p.rows))}
+		if _, err := p.w.Write(t.buf.Bytes()); err != nil {
+			return err
+		}
+		if _, err := p.w.Write(data.Bytes()); err != nil {
+			return err
+		}
+		group.chunks = append(group.chunks, chunk)
+	}
+	p.groups = append(p.groups, group)
+	p.total += group.rows
+	p.rows = p.rows[:0]
+	return nil
+}
+
+// 写入剩余的行组及文件元数据
+func (p *parquetWriter) Close() error {
+	if p.err != nil {
+		return p.err
+	}
+	if err := p.flush(); err != nil {
+		return err
+	}
+	t := &thriftWriter{}
+	t.beginStruct()
+	t.i32Field(1, 1)
+	// schema: 根节点及各列
+	t.listField(2, thriftStruct, len(parquetColumns)+1)
+	t.beginStruct()
+	t.binaryField(4, "schema")
+	t.i32Field(5, int32(len(parquetColumns)))
+	t.endStruct()
+	for _, col := range parquetColumns {
+		t.beginStruct()
+		t.i32Field(1, col.typ)
+		t.i32Field(3, parquetRequired)
+		t.binaryField(4, col.name)
+		if col.utf8 {
+			t.i32Field(6, parquetConvertedUTF8)
+		}
+		t.endStruct()
+	}
+	t.i64Field(3, p.total)
+	t.listField(4, thriftStruct, len(p.groups))
+	for _, group := range p.groups {
+		t.beginStruct()
+		t.listField(1, thriftStruct, len(group.chunks))
+		var groupSize int64
+		for idx, chunk := range group.chunks {
+			col := parquetColumns[idx]
+			groupSize += chunk.size
+			t.beginStruct()
+			t.i64Field(2, chunk.offset)
+			t.structField(3)
+			t.i32Field(1, col.typ)
+			t.listField(2, thriftI32, 2)
+			t.writeVarint(zigzag(parquetEncodingPlain))
+			t.writeVarint(zigzag(parquetEncodingRLE))
+			t.listField(3, thriftBinary, 1)
+			t.writeBinary(col.name)
+			t.i32Field(4, parquetUncompressed)
+			t.i64Field(5, chunk.values)
+			t.i64Field(6, chunk.size)
+			t.i64Field(7, chunk.size)
+			t.i64Field(9, chunk.offset)
+			t.endStruct()
+			t.endStruct()
+		}
+		t.i64Field(2, groupSize)
+		t.i64Field(3, group.rows)
+		t.endStruct()
+	}
+	t.binaryField(6, parquetCreatedBy)
+	t.endStruct()
+
+	if _, err := p.w.Write(t.buf.Bytes()); err != nil {
+		return err
+	}
+	if err := binary.Write(p.w, binary.LittleEndian, uint32(t.buf.Len())); err != nil {
+		return err
+	}
+	_, err := io.WriteString(p.w, parquetMagic)
+	return err
+}
+
+// Thrift Compact类型
+const (
+	thriftStop   = 0
+	thriftI32    = 5
+	thriftI64    = 6
+	thriftBinary = 8
+	thriftList   = 9
+	thriftStruct = 12
+)
+
+// Thrift Compact编码, 只实现Parquet元数据用到的类型
+type thriftWriter struct {
+	buf    bytes.Buffer
+	lastID int16   // 当前结构体上一个字段的id, 用于字段id的增量编码
+	stack  []int16 // 外层结构体的lastID
+}
+
+func zigzag(n int64) uint64 {
+	return uint64((n << 1) ^ (n >> 63))
+}
+
+func (t *thriftWriter) writeVarint(n uint64) {
+	var b [binary.MaxVarintLen64]byte
+	t.buf.Write(b[:binary.PutUvarint(b[:], n)])
+}
+
+func (t *thriftWriter) writeBinary(s string) {
+	t.writeVarint(uint64(len(s)))
+	t.buf.WriteString(s)
+}
+
+func (t *thriftWriter) fieldHeader(id int16, typ byte) {
+	if delta := id - t.lastID; delta > 0 && delta <= 15 {
+		t.buf.WriteByte(byte(delta)<<4 | typ)
+	} else {
+		t.buf.WriteByte(typ)
+		t.writeVarint(zigzag(int64(id)))
+	}
+	t.lastID = id
+}
+
+func (t *thriftWriter) beginStruct() {
+	t.stack = append(t.stack, t.lastID)
+	t.lastID = 0
+}
+
+func (t *thriftWriter) endStruct() {
+	t.buf.WriteByte(thriftStop)
+	t.lastID = t.stack[len(t.stack)-1]
+	t.stack = t.stack[:len(t.stack)-1]
+}
+
+func (t *thriftWriter) i32Field(id int16, v int32) {
+	t.fieldHeader(id, thriftI32)
+	t.writeVarint(zigzag(int64(v)))
+}
+
+func (t *thriftWriter) i64Field(id int16, v int64) {
+	t.fieldHeader(id, thriftI64)
+	t.writeVarint(zigzag(v))
+}
+
+func (t *thriftWriter) binaryField(id int16, s string) {
+	t.fieldHeader(id, thriftBinary)
+	t.writeBinary(s)
+}
+
+// 结构体字段, 之后写入其字段并调用endStruct
+func (t *thriftWriter) structField(id int16) {
+	t.fieldHeader(id, thriftStruct)
+	t.beginStruct()
+}
+
+// 列表字段, 之后写入size个元素, 结构体元素使用beginStruct/endStruct
+func (t *thriftWriter) listField(id int16, elemType byte, size int) {
+	t.fieldHeader(id, thriftList)
+	if size < 15 {
+		t.buf.WriteByte(byte(size)<<4 | elemType)
+	} else {
+		t.buf.WriteByte(0xf0 | elemType)
+		t.writeVarint(uint64(size))
+	}
+}
@@ -9,11 +9,16 @@ import (
 	"sort"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 )
 
 //var addr = "103.229.144.153:3225"
 
+const (
+	reconnectDelay = time.Second // 断开后的重连间隔
+)
+
 type ForexData struct {
 	Id        string `gorm:"column:id" json:"-"`
 	CoinType  string `gorm:"column:coinType" json:"coinType"`
@@ -42,7 +47,7 @@ func (k ForexData) String() string {
 }
 
 func (f ForexData) CsvString() []string {
-	return []string{f.CoinType, f.Exchange, f.SubMarket, f.Precision, strconv.FormatInt(f.Time, 64), f.NewPrice,
+	return []string{f.CoinType, f.Exchange, f.SubMarket, f.Precision, strconv.FormatInt(f.Time, 10), f.NewPrice,
 		f.Buy, f.Sell, f.Open, f.Close, f.High, f.Low}
 }
 
@@ -88,15 +93,68 @@ func sortMapByValue(m map[string]int) PairList {
 	return p
 }
 
-func GetForexData(klineChan chan *kline.OptionKline) {
+// 行情服务器客户端, 断开后自动重连; clock为TickClock时, 由收到的行情时间推进
+type Client struct {
+	addr  string
+	clock common.Clock
+	quit  chan struct{}
+	done  chan struct{}
+	mutex sync.Mutex
+	conn  net.Conn
+}
+
+func NewClient(addr string, clock common.Clock) *Client {
+	return &Client{
+		addr:  addr,
+		clock: clock,
+		quit:  make(chan struct{}),
+		done:  make(chan struct{}),
+	}
+}
+
+// 读取行情数据并写入tickQueue, 直到调用Stop
+func (c *Client) Run(tickQueue *kline.Queue) {
+	defer close(c.done)
+	for {
+		c.GetForexData(tickQueue)
+		select {
+		case <-c.quit:
+			return
+		case <-time.After(reconnectDelay):
+		}
+	}
+}
+
+// 停止读取行情数据, 并等待Run退出
+func (c *Client) Stop() {
+	close(c.quit)
+	c.mutex.Lock()
+	if c.conn != nil {
+		c.conn.Close()
+	}
+	c.mutex.Unlock()
+	<-c.done
+}
+
+// 连接行情服务器并读取行情数据, 连接断开或读取失败时返回
+func (c *Client) GetForexData(tickQueue *kline.Queue) {
 	fn := "GetForexData"
-	defer func() { go GetForexData(klineChan) }()
 	defer common.CheckPanic(fn, nil)
-	conn, err := net.DialTimeout("tcp", common.ForexAddr, 3*time.Second)
+	conn, err := net.DialTimeout("tcp", c.addr, 3*time.Second)
 	if err != nil {
-		log.Errorf("[%s] failed to connect tcp addr: %s, error: %s", fn, common.ForexAddr, err)
+		log.Errorf("[%s] failed to connect tcp addr: %s, error: %s", fn, c.addr, err)
+		return
+	}
+	defer conn.Close()
+	c.mutex.Lock()
+	select {
+	case <-c.quit:
+		c.mutex.Unlock()
 		return
+	default:
 	}
+	c.conn = conn
+	c.mutex.Unlock()
 	buf := make([]byte, 1024)
 	for {
 		err := conn.SetReadDeadline(time.Now().Add(10 * time.Second))
@@ -104,51 +162,77 @@ func GetForexData(klineChan chan *kline.OptionKline) {
 			log.Errorf("[%s]failed to set read deadline: %s", fn, err)
 			return
 		}
-		if _, err = conn.Read(buf); err != nil {
+		n, err := conn.Read(buf)
+		if err != nil {
 			log.Errorf("[%s] failed to read tcp data: %s", fn, err)
 			return
 		}
-		coinFlag := make(map[string]bool, 10)
-		dataList := bytes.Split(buf, []byte{0x00})
-		// 0xFF|69|6000||3||LTCUS|100|255|6|20181229|143135|32.000000|32.000000|32.000005|||||0x00
-		for _, data := range dataList {
-			if len(data) <= 0 || data[0] != 0xff {
-				continue
-			}
-			fieldList := strings.Split(string(data), "|")
-			// �, 60, 6000, , 3, , ETHUSD, 100, 29, 2, 20181229, 145034, 136.46, 136.46, 136.51, , , , ,
-			if len(fieldList) < 20 || fieldList[2] != "6000" && fieldList[4] != "3" {
-				continue
-			}
-			coinType, ok := kline.CoinTypeMap[fieldList[6]]
-			if !ok || !common.IsInList(coinType, common.CoinSupported.Load().([]string)) {
-				continue
-			}
-			// 对于需要的货币信息,只取一条
-			if _, ok := coinFlag[coinType]; ok {
-				continue
-			}
-			coinFlag[coinType] = true
-			loc, _ := time.LoadLocation("Asia/Shanghai")
-			tm, err := time.ParseInLocation("20060102150405", strings.Join(fieldList[10:12], ""), loc)
-			if err != nil {
-				log.Errorf("[%s]Failed to parse time in localtino: %s", fn, err)
-				continue
-			}
-			kline := kline.OptionKline{
-				CoinType:   coinType,
-				Open:       fieldList[12],
-				Close:      fieldList[12],
-				High:       fieldList[12], // == newprice
-				Low:        fieldList[12],
-				LastUpdate: tm.Unix(),
-				Time:       tm.Unix(),
-				Origin:     1,
+		for _, kline := range ParseForexData(buf[:n]) {
+			if tickClock, ok := c.clock.(common.TickClock); ok {
+				tickClock.Observe(time.Unix(kline.Time, 0))
 			}
 			log.Debugf("[%s]received kline:%s", fn, kline)
-			klineChan <- &kline
+			if !tickQueue.Push(kline) {
+				log.Errorf("[%s]tick queue is full, drop kline: %s", fn, kline)
+			}
+		}
+	}
+}
+
+// 解析行情数据, 每条记录以0xFF开头、0x00结尾, 字段以|分隔:
+// 0xFF|69|6000||3||LTCUS|100|255|6|20181229|143135|32.000000|32.000000|32.000005|||||0x00
+// 只解析支持的币种, 同一批数据中每个币种只取第一条
+func ParseForexData(buf []byte) (klineList []*kline.OptionKline) {
+	fn := "ParseForexData"
+	coinFlag := make(map[string]bool, 10)
+	dataList := bytes.Split(buf, []byte{0x00})
+	for _, data := range dataList {
+		if len(data) <= 0 || data[0] != 0xff {
+			continue
+		}
+		fieldList := strings.Split(string(data), "|")
+		// �, 60, 6000, , 3, , ETHUSD, 100, 29, 2, 20181229, 145034, 136.46, 136.46, 136.51, , , , ,
+		if len(fieldList) < 20 || fieldList[2] != "6000" && fieldList[4] != "3" {
+			continue
+		}
+		coinType, ok := kline.CoinTypeMap[fieldList[6]]
+		if !ok || !common.IsInList(coinType, common.CoinSupported.Load().([]string)) {
+			continue
+		}
+		// 对于需要的货币信息,只取一条
+		if _, ok := coinFlag[coinType]; ok {
+			continue
 		}
+		coinFlag[coinType] = true
+		loc, _ := time.LoadLocation("Asia/Shanghai")
+		tm, err := time.ParseInLocation("20060102150405", strings.Join(fieldList[10:12], ""), loc)
+		if err != nil {
+			log.Errorf("[%s]Failed to parse time in localtino: %s", fn, err)
+			continue
+		}
+		klineList = append(klineList, &kline.OptionKline{
+			CoinType:   coinType,
+			Open:       fieldList[12],
+			Close:      fieldList[12],
+			High:       fieldList[12], // == newprice
+			Low:        fieldList[12],
+			LastUpdate: tm.Unix(),
+			Time:       tm.Unix(),
+			Origin:     1,
+			Source:     kline.SourceForex,
+			VendorTime: tm.Unix(),
+		})
 	}
+	return
+}
+
+// 按行情服务器的协议格式化一条行情记录, 用于模拟行情服务器
+func FormatForexData(symbol, price string, tm time.Time) []byte {
+	loc, _ := time.LoadLocation("Asia/Shanghai")
+	tm = tm.In(loc)
+	fieldList := []string{"\xff", "69", "6000", "", "3", "", symbol, "100", "255", "6",
+		tm.Format("20060102"), tm.Format("150405"), price, price, price, "", "", "", "", ""}
+	return append([]byte(strings.Join(fieldList, "|")), 0x00)
 }
 
 //		forexData := ForexData{
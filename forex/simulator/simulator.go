@@ -0,0 +1,330 @@
+package simulator
+
+import (
+	"encoding/csv"
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"io"
+	"math/rand"
+	"net"
+	"option-kline/forex"
+	"os"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// 模拟行情服务器配置
+type Config struct {
+	Addr       string             // 监听地址
+	Symbols    map[string]float64 // 行情服务器的货币对及其初始价格, 如 XAUUSD: 1280.5
+	Interval   time.Duration      // 报价间隔
+	Volatility float64            // 随机游走每次的最大相对涨跌幅
+	Precision  int                // 价格小数位数
+	Seed       int64              // 随机数种子, 0表示使用当前时间
+
+	ReplayFile string  // 回放文件, 每行: 货币对,时间(unix秒),价格; 为空时使用随机游走
+	Speed      float64 // 回放速度倍数, 0表示按Interval匀速回放
+
+	DisconnectEvery time.Duration // 每隔多久断开所有客户端, 0表示不断开
+	MalformedRate   float64       // 畸形记录的比例, 0~1
+	BurstEvery      time.Duration // 每隔多久突发发送一批报价, 0表示不突发
+	BurstSize       int           // 突发时每个货币对连续发送的记录数
+	SilenceEvery    time.Duration // 每隔多久静默一次, 0表示不静默
+	SilenceFor      time.Duration // 每次静默的时长
+}
+
+func DefaultConfig() Config {
+	return Config{
+		Addr: "127.0.0.1:2000",
+		Symbols: map[string]float64{
+			"XAUUSD": 1280.5,
+			"USDCNH": 6.88,
+			"BTCUSD": 3800,
+		},
+		Interval:   500 * time.Millisecond,
+		Volatility: 0.0005,
+		Precision:  5,
+		BurstSize:  10,
+	}
+}
+
+// 一条报价
+type Tick struct {
+	Symbol string
+	Price  float64
+	Time   time.Time
+}
+
+// 模拟行情服务器: 使用与真实行情服务器相同的TCP协议推送报价
+type Simulator struct {
+	conf     Config
+	listener net.Listener
+	rd       *rand.Rand
+	prices   map[string]float64
+	symbols  []string
+	replay   []Tick
+
+	mutex    sync.Mutex
+	clients  map[net.Conn]bool
+	accepted int // 累计连接的客户端数量
+	quit     chan struct{}
+	wg       sync.WaitGroup
+}
+
+func New(conf Config) (*Simulator, error) {
+	if conf.Interval <= 0 {
+		return nil, fmt.Errorf("interval should be positive: %v", conf.Interval)
+	}
+	seed := conf.Seed
+	if seed == 0 {
+		seed = time.Now().UnixNano()
+	}
+	s := &Simulator{
+		conf:    conf,
+		rd:      rand.New(rand.NewSource(seed)),
+		prices:  make(map[string]float64, len(conf.Symbols)),
+		clients: make(map[net.Conn]bool, 10),
+		quit:    make(chan struct{}),
+	}
+	for symbol, price := range conf.Symbols {
+		s.prices[symbol] = price
+		s.symbols = append(s.symbols, symbol)
+	}
+	// 固定顺序, 使相同种子生成相同的报价
+	sort.Strings(s.symbols)
+	if conf.ReplayFile != "" {
+		replay, err := LoadReplayFile(conf.ReplayFile)
+		if err != nil {
+			return nil, err
+		}
+		s.replay = replay
+	} else if len(s.symbols) == 0 {
+		return nil, fmt.Errorf("no symbols to simulate")
+	}
+	return s, nil
+}
+
+// 读取回放文件, 每行: 货币对,时间(unix秒),价格
+func LoadReplayFile(fileName string) (tickList []Tick, err error) {
+	f, err := os.Open(fileName)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	r := csv.NewReader(f)
+	r.FieldsPerRecord = 3
+	r.Comment = '#'
+	for {
+		record, err := r.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		tm, err := strconv.ParseInt(record[1], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid time %s: %s", record[1], err)
+		}
+		price, err := strconv.ParseFloat(record[2], 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid price %s: %s", record[2], err)
+		}
+		tickList = append(tickList, Tick{Symbol: record[0], Price: price, Time: time.Unix(tm, 0)})
+	}
+	return
+}
+
+// 开始监听并推送报价
+func (s *Simulator) Start() error {
+	listener, err := net.Listen("tcp", s.conf.Addr)
+	if err != nil {
+		return err
+	}
+	s.listener = listener
+	s.wg.Add(2)
+	go s.accept()
+	go s.run()
+	log.Infof("[Simulator]listening on %s, symbols: %v, replay: %s", listener.Addr(), s.symbols, s.conf.ReplayFile)
+	return nil
+}
+
+// 实际监听地址
+func (s *Simulator) Addr() string {
+	return s.listener.Addr().String()
+}
+
+// 当前连接的客户端数量
+func (s *Simulator) Clients() int {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return len(s.clients)
+}
+
+// 累计连接的客户端数量
+func (s *Simulator) Accepted() int {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.accepted
+}
+
+func (s *Simulator) Close() error {
+	close(s.quit)
+	err := s.listener.Close()
+	s.wg.Wait()
+	s.Disconnect()
+	return err
+}
+
+// 断开所有客户端
+func (s *Simulator) Disconnect() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	for conn := range s.clients {
+		conn.Close()
+		delete(s.clients, conn)
+	}
+}
+
+func (s *Simulator) accept() {
+	defer s.wg.Done()
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			select {
+			case <-s.quit:
+				return
+			default:
+			}
+			log.Errorf("[Simulator]failed to accept: %s", err)
+			time.Sleep(100 * time.Millisecond)
+			continue
+		}
+		log.Infof("[Simulator]client connected: %s", conn.RemoteAddr())
+		s.mutex.Lock()
+		s.clients[conn] = true
+		s.accepted++
+		s.mutex.Unlock()
+	}
+}
+
+func (s *Simulator) run() {
+	defer s.wg.Done()
+	ticker := time.NewTicker(s.conf.Interval)
+	defer ticker.Stop()
+	begin := time.Now()
+	lastDisconnect, lastBurst, lastSilence := begin, begin, begin
+	replayIdx := 0
+	var replayBegin time.Time
+	for {
+		select {
+		case <-s.quit:
+			return
+		case now := <-ticker.C:
+			if s.conf.DisconnectEvery > 0 && now.Sub(lastDisconnect) >= s.conf.DisconnectEvery {
+				lastDisconnect = now
+				log.Infof("[Simulator]disconnect all clients")
+				s.Disconnect()
+				continue
+			}
+			if s.conf.SilenceEvery > 0 && now.Sub(lastSilence) >= s.conf.SilenceEvery {
+				if now.Sub(lastSilence) < s.conf.SilenceEvery+s.conf.SilenceFor {
+					continue
+				}
+				lastSilence = now
+			}
+			var tickList []Tick
+			if s.replay != nil {
+				// 有客户端连接后才开始回放
+				if replayIdx >= len(s.replay) || replayIdx == 0 && s.Clients() == 0 {
+					continue
+				}
+				if replayBegin.IsZero() {
+					replayBegin = now
+				}
+				tickList, replayIdx = s.nextReplay(replayIdx, now.Sub(replayBegin))
+			} else {
+				tickList = s.nextRandom(now)
+			}
+			n := 1
+			if s.conf.BurstEvery > 0 && now.Sub(lastBurst) >= s.conf.BurstEvery {
+				lastBurst = now
+				n = s.conf.BurstSize
+			}
+			s.broadcast(s.encode(tickList, n))
+		}
+	}
+}
+
+// 随机游走生成每个货币对的下一个报价
+func (s *Simulator) nextRandom(now time.Time) (tickList []Tick) {
+	for _, symbol := range s.symbols {
+		price := s.prices[symbol]
+		price *= 1 + (s.rd.Float64()*2-1)*s.conf.Volatility
+		s.prices[symbol] = price
+		tickList = append(tickList, Tick{Symbol: symbol, Price: price, Time: now})
+	}
+	return
+}
+
+// 取出到达回放进度的报价; Speed为0时每次取出同一秒的报价
+func (s *Simulator) nextReplay(idx int, elapsed time.Duration) (tickList []Tick, next int) {
+	first := s.replay[idx].Time
+	for next = idx; next < len(s.replay); next++ {
+		tick := s.replay[next]
+		if s.conf.Speed > 0 {
+			if float64(tick.Time.Sub(s.replay[0].Time)) > float64(elapsed)*s.conf.Speed {
+				break
+			}
+		} else if !tick.Time.Equal(first) {
+			break
+		}
+		tickList = append(tickList, tick)
+	}
+	return
+}
+
+// 按协议编码报价, 按配置混入畸形记录; n>1时每条报价重复发送n次
+func (s *Simulator) encode(tickList []Tick, n int) (buf []byte) {
+	for _, tick := range tickList {
+		price := strconv.FormatFloat(tick.Price, 'f', s.conf.Precision, 64)
+		for i := 0; i < n; i++ {
+			if s.conf.MalformedRate > 0 && s.rd.Float64() < s.conf.MalformedRate {
+				buf = append(buf, s.malformed(tick.Symbol, price)...)
+				continue
+			}
+			buf = append(buf, forex.FormatForexData(tick.Symbol, price, tick.Time)...)
+		}
+	}
+	return
+}
+
+// 生成一条畸形记录: 缺少字段、缺少起始符或时间格式错误
+func (s *Simulator) malformed(symbol, price string) []byte {
+	switch s.rd.Intn(3) {
+	case 0:
+		return append([]byte("\xff|69|6000||3||"+symbol+"|100"), 0x00)
+	case 1:
+		return append([]byte("69|6000||3||"+symbol+"|100|255|6|20181229|143135|"+price+"|||||||"), 0x00)
+	default:
+		return append([]byte("\xff|69|6000||3||"+symbol+"|100|255|6|2018-12-29|14:31|"+price+"|"+price+"|"+price+"|||||"), 0x00)
+	}
+}
+
+func (s *Simulator) broadcast(buf []byte) {
+	if len(buf) == 0 {
+		return
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	for conn := range s.clients {
+		conn.SetWriteDeadline(time.Now().Add(time.Second))
+		if _, err := conn.Write(buf); err != nil {
+			log.Infof("[Simulator]client disconnected: %s, %s", conn.RemoteAddr(), err)
+			conn.Close()
+			delete(s.clients, conn)
+		}
+	}
+}
@@ -0,0 +1,118 @@
+package simulator
+
+import (
+	"io/ioutil"
+	"option-kline/common"
+	"option-kline/forex"
+	"option-kline/kline"
+	"os"
+	"testing"
+	"time"
+)
+
+func receive(q *kline.Queue, n int, timeout time.Duration) (klineList []*kline.OptionKline) {
+	deadline := time.After(timeout)
+	for len(klineList) < n {
+		select {
+		case k := <-q.C():
+			klineList = append(klineList, k)
+		case <-deadline:
+			return
+		}
+	}
+	return
+}
+
+func startClient(t *testing.T, conf Config) (*Simulator, *kline.Queue) {
+	sim, err := New(conf)
+	if err != nil {
+		t.Fatalf("failed to create simulator: %s", err)
+	}
+	if err := sim.Start(); err != nil {
+		t.Fatalf("failed to start simulator: %s", err)
+	}
+	q := kline.NewQueueWithPolicy("test.simulator", common.QUEUE_POLICY_DROP_NEWEST, 1000, 0)
+	client := forex.NewClient(sim.Addr(), common.RealClock)
+	go client.Run(q)
+	t.Cleanup(func() {
+		client.Stop()
+		sim.Close()
+		q.Close()
+	})
+	return sim, q
+}
+
+func TestSimulatorRandomWalk(t *testing.T) {
+	conf := DefaultConfig()
+	conf.Addr = "127.0.0.1:0"
+	conf.Interval = 20 * time.Millisecond
+	conf.Seed = 1
+	conf.MalformedRate = 0.3
+	_, q := startClient(t, conf)
+
+	klineList := receive(q, 20, 3*time.Second)
+	if len(klineList) < 20 {
+		t.Fatalf("client should receive simulated ticks, actual: %d", len(klineList))
+	}
+	for _, k := range klineList {
+		if k.CoinType != "GT" && k.CoinType != "USDT" && k.CoinType != "BTC" {
+			t.Errorf("unexpected coin type: %s", k)
+		}
+		if k.Origin != 1 || k.Open == "" || k.Open != k.Close {
+			t.Errorf("unexpected tick: %s", k)
+		}
+	}
+}
+
+func TestSimulatorReplay(t *testing.T) {
+	f, err := ioutil.TempFile("", "replay-*.csv")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	f.WriteString("# symbol,time,price\nXAUUSD,1546065095,1280.10\nXAUUSD,1546065096,1280.20\nXAUUSD,1546065098,1280.40\n")
+	f.Close()
+
+	conf := DefaultConfig()
+	conf.Addr = "127.0.0.1:0"
+	conf.Interval = 20 * time.Millisecond
+	conf.Precision = 2
+	conf.ReplayFile = f.Name()
+	_, q := startClient(t, conf)
+
+	klineList := receive(q, 3, 3*time.Second)
+	if len(klineList) != 3 {
+		t.Fatalf("client should receive all replayed ticks, actual: %v", klineList)
+	}
+	for idx, expected := range []struct {
+		time  int64
+		price string
+	}{{1546065095, "1280.10"}, {1546065096, "1280.20"}, {1546065098, "1280.40"}} {
+		if klineList[idx].Time != expected.time || klineList[idx].Open != expected.price {
+			t.Errorf("unexpected replayed tick at %d: %s", idx, klineList[idx])
+		}
+	}
+}
+
+func TestSimulatorDisconnect(t *testing.T) {
+	conf := DefaultConfig()
+	conf.Addr = "127.0.0.1:0"
+	conf.Interval = 20 * time.Millisecond
+	conf.DisconnectEvery = 300 * time.Millisecond
+	sim, q := startClient(t, conf)
+
+	// 断开后客户端自动重连, 继续接收报价
+	deadline := time.Now().Add(5 * time.Second)
+	for sim.Accepted() < 2 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if sim.Accepted() < 2 {
+		t.Fatal("client should reconnect after disconnect")
+	}
+	for len(q.C()) > 0 {
+		<-q.C()
+	}
+	if klineList := receive(q, 1, 3*time.Second); len(klineList) == 0 {
+		t.Error("client should keep receiving ticks after reconnect")
+	}
+}
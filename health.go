@@ -0,0 +1,29 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+)
+
+// 币种状态
+type symbolHealth struct {
+	CoinType  string `json:"coinType"`
+	PriceMode string `json:"priceMode"`
+}
+
+// 健康检查结果
+type health struct {
+	Status       string         `json:"status"`
+	PipelineMode string         `json:"pipelineMode"`
+	Symbols      []symbolHealth `json:"symbols"`
+}
+
+// 健康检查接口: 返回运行状态及各币种的报价模式
+func (a *App) ServeHealth(w http.ResponseWriter, r *http.Request) {
+	h := health{Status: "ok", PipelineMode: a.conf.PipelineMode, Symbols: []symbolHealth{}}
+	for _, coinType := range a.conf.CoinTypes {
+		h.Symbols = append(h.Symbols, symbolHealth{CoinType: coinType, PriceMode: a.conf.PriceMode(coinType)})
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(h)
+}
@@ -0,0 +1,140 @@
+package kline
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/gomodule/redigo/redis"
+	"option-kline/common"
+)
+
+// redis K线缓存: 缓存各周期最新的K线, 并通过pub/sub推送K线
+type RedisCache struct {
+	pool      *redis.Pool
+	size      int        // 每个币种每个周期缓存的最新K线数量, 0表示不缓存
+	intervals []Interval // 缓存的K线周期
+	channel   string     // 推送频道前缀
+}
+
+func NewRedisCache(pool *redis.Pool, size int, intervals []Interval, channel string) *RedisCache {
+	return &RedisCache{
+		pool:      pool,
+		size:      size,
+		intervals: intervals,
+		channel:   channel,
+	}
+}
+
+// 根据配置创建redis K线缓存
+func NewRedisCacheFromConfig(pool *redis.Pool) (*RedisCache, error) {
+	intervals, err := ParseIntervalList(common.RedisCacheIntervals)
+	if err != nil {
+		return nil, fmt.Errorf("invalid redis cache intervals %v: %s", common.RedisCacheIntervals, err)
+	}
+	return NewRedisCache(pool, common.RedisCacheSize, intervals, common.RedisPublishChannel), nil
+}
+
+// redis缓存K线的key: 每个币种、每个周期一个有序集合
+func RedisKLineKey(coinType string, interval Interval) string {
+	return fmt.Sprintf("%s:%s:%s", common.REDIS_KLINE_KEY_PREFIX, coinType, interval.Name)
+}
+
+// redis推送K线的频道: 每个币种一个频道
+func (c *RedisCache) Channel(coinType string) string {
+	return fmt.Sprintf("%s:%s", c.channel, coinType)
+}
+
+// 将K线写入redis缓存, score为K线所在周期的开始时间, 每个周期只保留最新的N条
+func (c *RedisCache) CacheKLine(kline *OptionKline) (err error) {
+	if c == nil || c.size <= 0 {
+		return nil
+	}
+	conn := c.pool.Get()
+	defer conn.Close()
+	for _, interval := range c.intervals {
+		if err = c.cacheKLine(conn, kline, interval); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (c *RedisCache) cacheKLine(conn redis.Conn, kline *OptionKline, interval Interval) error {
+	key := RedisKLineKey(kline.CoinType, interval)
+	begin := interval.Begin(kline.Time)
+	candle := *kline
+	candle.Time = begin
+	// 1. 非秒级周期, 与当前周期已缓存的K线合并
+	if interval.Seconds > 1 {
+		vals, err := redis.Strings(conn.Do("ZRANGEBYSCORE", key, begin, begin))
+		if err != nil {
+			return err
+		}
+		if len(vals) > 0 {
+			last := OptionKline{}
+			if err := json.Unmarshal([]byte(vals[len(vals)-1]), &last); err != nil {
+				return err
+			}
+			MergeKLine(&last, kline)
+			candle = last
+		}
+	}
+	data, err := json.Marshal(candle)
+	if err != nil {
+		return err
+	}
+
+	// 2. 替换当前周期的K线, 并删除超出缓存数量的旧数据
+	conn.Send("MULTI")
+	conn.Send("ZREMRANGEBYSCORE", key, begin, begin)
+	conn.Send("ZADD", key, begin, data)
+	conn.Send("ZREMRANGEBYRANK", key, 0, -(c.size + 1))
+	_, err = conn.Do("EXEC")
+	return err
+}
+
+// 从redis缓存获取最新的n条K线, 按时间升序排列
+func (c *RedisCache) GetLatestKLines(coinType string, interval Interval, n int) (klineList []*OptionKline, err error) {
+	if c == nil {
+		return nil, fmt.Errorf("redis cache is not initialized")
+	}
+	if n <= 0 {
+		return
+	}
+	conn := c.pool.Get()
+	defer conn.Close()
+	vals, err := redis.Strings(conn.Do("ZRANGE", RedisKLineKey(coinType, interval), -n, -1))
+	if err != nil {
+		return nil, err
+	}
+	for _, val := range vals {
+		kline := &OptionKline{}
+		if err := json.Unmarshal([]byte(val), kline); err != nil {
+			return nil, err
+		}
+		klineList = append(klineList, kline)
+	}
+	return
+}
+
+// 通过redis pub/sub推送K线
+func (c *RedisCache) PublishKLine(kline *OptionKline) error {
+	if c == nil {
+		return fmt.Errorf("redis cache is not initialized")
+	}
+	data, err := json.Marshal(kline)
+	if err != nil {
+		return err
+	}
+	conn := c.pool.Get()
+	defer conn.Close()
+	_, err = conn.Do("PUBLISH", c.Channel(kline.CoinType), data)
+	return err
+}
+
+// 关闭redis连接池
+func (c *RedisCache) Close() error {
+	if c == nil {
+		return nil
+	}
+	return c.pool.Close()
+}
@@ -0,0 +1,126 @@
+package kline
+
+import (
+	"encoding/json"
+	"github.com/alicebob/miniredis/v2"
+	"github.com/gomodule/redigo/redis"
+	"testing"
+	"time"
+)
+
+func setupRedis(t *testing.T, cacheSize int, intervals ...Interval) (*miniredis.Miniredis, *RedisCache) {
+	s, err := miniredis.Run()
+	if err != nil {
+		t.Fatalf("failed to start miniredis: %s", err)
+	}
+	pool := &redis.Pool{
+		MaxIdle: 2,
+		Dial: func() (redis.Conn, error) {
+			return redis.Dial("tcp", s.Addr())
+		},
+	}
+	t.Cleanup(func() {
+		pool.Close()
+		s.Close()
+	})
+	return s, NewRedisCache(pool, cacheSize, intervals, "option-kline")
+}
+
+func TestRedisCacheKLine(t *testing.T) {
+	_, cache := setupRedis(t, 3, Interval1s)
+	prices := []string{"1.1", "1.2", "1.3", "1.4", "1.5"}
+	for idx, price := range prices {
+		kline := &OptionKline{CoinType: "GT", Open: price, Close: price, High: price, Low: price, Time: 1000 + int64(idx)}
+		if err := cache.CacheKLine(kline); err != nil {
+			t.Fatalf("CacheKLine failed: %s", err)
+		}
+	}
+
+	klineList, err := cache.GetLatestKLines("GT", Interval1s, 10)
+	if err != nil {
+		t.Fatalf("GetLatestKLines failed: %s", err)
+	}
+	if len(klineList) != 3 {
+		t.Fatalf("cache size should be 3, actual: %d", len(klineList))
+	}
+	for idx, kline := range klineList {
+		if kline.Time != 1002+int64(idx) || kline.Open != prices[idx+2] {
+			t.Errorf("unexpected kline at %d: %s", idx, kline)
+		}
+	}
+
+	klineList, err = cache.GetLatestKLines("GT", Interval1s, 1)
+	if err != nil || len(klineList) != 1 || klineList[0].Time != 1004 {
+		t.Errorf("GetLatestKLines(1) should return the newest kline, actual: %v, err: %v", klineList, err)
+	}
+}
+
+func TestRedisCacheKLineMinute(t *testing.T) {
+	_, cache := setupRedis(t, 10, Interval1s, Interval1m)
+	ticks := []*OptionKline{
+		{CoinType: "GT", Open: "10.0", Close: "10.0", High: "10.0", Low: "10.0", Time: 120},
+		{CoinType: "GT", Open: "12.5", Close: "12.5", High: "12.5", Low: "12.5", Time: 121},
+		{CoinType: "GT", Open: "9.5", Close: "9.5", High: "9.5", Low: "9.5", Time: 150},
+		{CoinType: "GT", Open: "11.0", Close: "11.0", High: "11.0", Low: "11.0", Time: 179},
+		{CoinType: "GT", Open: "11.2", Close: "11.2", High: "11.2", Low: "11.2", Time: 180},
+	}
+	for _, kline := range ticks {
+		if err := cache.CacheKLine(kline); err != nil {
+			t.Fatalf("CacheKLine failed: %s", err)
+		}
+	}
+
+	klineList, err := cache.GetLatestKLines("GT", Interval1m, 10)
+	if err != nil {
+		t.Fatalf("GetLatestKLines failed: %s", err)
+	}
+	if len(klineList) != 2 {
+		t.Fatalf("there should be 2 minute klines, actual: %d", len(klineList))
+	}
+	k := klineList[0]
+	if k.Time != 120 || k.Open != "10.0" || k.High != "12.5" || k.Low != "9.5" || k.Close != "11.0" {
+		t.Errorf("unexpected minute kline: %s", k)
+	}
+	k = klineList[1]
+	if k.Time != 180 || k.Open != "11.2" || k.Close != "11.2" {
+		t.Errorf("unexpected minute kline: %s", k)
+	}
+
+	klineList, err = cache.GetLatestKLines("GT", Interval1s, 10)
+	if err != nil || len(klineList) != len(ticks) {
+		t.Errorf("all second klines should be cached, actual: %d, err: %v", len(klineList), err)
+	}
+}
+
+func TestRedisCachePublishKLine(t *testing.T) {
+	s, cache := setupRedis(t, 0)
+	conn, err := redis.Dial("tcp", s.Addr())
+	if err != nil {
+		t.Fatalf("failed to connect miniredis: %s", err)
+	}
+	psc := redis.PubSubConn{Conn: conn}
+	defer psc.Close()
+	if err := psc.Subscribe(cache.Channel("USDT")); err != nil {
+		t.Fatalf("failed to subscribe: %s", err)
+	}
+	// 订阅确认
+	if _, ok := psc.ReceiveWithTimeout(time.Second).(redis.Subscription); !ok {
+		t.Fatal("failed to receive subscription")
+	}
+
+	kline := &OptionKline{CoinType: "USDT", Open: "6.88123", Close: "6.88123", High: "6.88123", Low: "6.88123", Time: 1000}
+	if err := cache.PublishKLine(kline); err != nil {
+		t.Fatalf("PublishKLine failed: %s", err)
+	}
+	msg, ok := psc.ReceiveWithTimeout(time.Second).(redis.Message)
+	if !ok {
+		t.Fatal("failed to receive published kline")
+	}
+	received := OptionKline{}
+	if err := json.Unmarshal(msg.Data, &received); err != nil {
+		t.Fatalf("failed to unmarshal published kline: %s", err)
+	}
+	if received.String() != kline.String() {
+		t.Errorf("published kline mismatch, expected: %s, actual: %s", kline, received)
+	}
+}
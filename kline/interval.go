@@ -0,0 +1,92 @@
+package kline
+
+import (
+	"fmt"
+	"option-kline/common"
+	"strings"
+)
+
+// K线周期
+type Interval struct {
+	Name    string // 周期名称, 如: 1s, 1m
+	Seconds int64  // 周期长度, 单位:秒
+}
+
+var (
+	Interval1s  = Interval{Name: "1s", Seconds: 1}
+	Interval1m  = Interval{Name: "1m", Seconds: 60}
+	Interval5m  = Interval{Name: "5m", Seconds: 5 * 60}
+	Interval15m = Interval{Name: "15m", Seconds: 15 * 60}
+	Interval1h  = Interval{Name: "1h", Seconds: 60 * 60}
+	Interval1d  = Interval{Name: "1d", Seconds: common.DAY_SECONDS}
+
+	// 支持的K线周期
+	IntervalList = []Interval{Interval1s, Interval1m, Interval5m, Interval15m, Interval1h, Interval1d}
+)
+
+func (i Interval) String() string {
+	return i.Name
+}
+
+// 获取时间t所在周期的开始时间
+func (i Interval) Begin(t int64) int64 {
+	return t - t%i.Seconds
+}
+
+// 根据名称获取K线周期
+func ParseInterval(name string) (Interval, error) {
+	name = strings.TrimSpace(name)
+	for _, interval := range IntervalList {
+		if interval.Name == name {
+			return interval, nil
+		}
+	}
+	return Interval{}, fmt.Errorf("interval not supported: %s", name)
+}
+
+// 根据名称列表获取K线周期列表
+func ParseIntervalList(names []string) ([]Interval, error) {
+	intervals := make([]Interval, 0, len(names))
+	for _, name := range names {
+		if strings.TrimSpace(name) == "" {
+			continue
+		}
+		interval, err := ParseInterval(name)
+		if err != nil {
+			return nil, err
+		}
+		intervals = append(intervals, interval)
+	}
+	return intervals, nil
+}
+
+// 将同一周期内较新的K线src合并到dst: 开盘价不变, 收盘价取最新, 最高/最低价取极值
+func MergeKLine(dst, src *OptionKline) {
+	if common.IsStrBigger(src.High, dst.High) {
+		dst.High = src.High
+	}
+	if common.IsStrBigger(dst.Low, src.Low) {
+		dst.Low = src.Low
+	}
+	dst.Close = src.Close
+	if src.LastUpdate > dst.LastUpdate {
+		dst.LastUpdate = src.LastUpdate
+	}
+	// 原始报价, 无原始报价的旧数据不合并
+	if dst.RawOpen != "" && src.RawOpen != "" {
+		if common.IsStrBigger(src.RawHigh, dst.RawHigh) {
+			dst.RawHigh = src.RawHigh
+		}
+		if common.IsStrBigger(dst.RawLow, src.RawLow) {
+			dst.RawLow = src.RawLow
+		}
+		dst.RawClose = src.RawClose
+	}
+	if src.VendorTime > dst.VendorTime {
+		dst.VendorTime = src.VendorTime
+	}
+	// 只要有一条为补充数据, 则整个周期都不是原始数据
+	if src.Origin == 0 {
+		dst.Origin = 0
+	}
+}
@@ -16,7 +16,6 @@ import (
 	"os"
 	"strconv"
 	"strings"
-	"sync"
 	"time"
 )
 
@@ -28,9 +27,14 @@ var (
 		"USDCNH": "USDT",
 		"BTCUSD": "BTC",
 	}
-	KLineDataMap     = make(map[string]*KLineData, 10)
-	firstFlag        = false
-	klineDstPriceMap = make(map[string]float64, 2)
+	firstFlag = false
+)
+
+// 行情源名称
+const (
+	SourceForex    = "forex"    // forex行情服务器
+	SourceHTTP     = "http"     // 已弃用的http报价接口
+	SourceBackfill = "backfill" // 补录的历史行情
 )
 
 type Trader struct {
@@ -69,6 +73,29 @@ type OptionKline struct {
 	Time       int64  `gorm:"column:time" json:"time"`
 	LastUpdate int64  `gorm:"column:lastupdate" json:"lastupdate"`
 	Origin     uint8  `gorm:"column:origin" json:"-"` // 是否为原始数据
+
+	// 行情源的原始报价及来源, 与推送的报价(可能经过干预)对照
+	RawOpen    string `gorm:"column:rawOpen" json:"-"`
+	RawClose   string `gorm:"column:rawClose" json:"-"`
+	RawHigh    string `gorm:"column:rawHigh" json:"-"`
+	RawLow     string `gorm:"column:rawLow" json:"-"`
+	Source     string `gorm:"column:source" json:"-"`     // 行情源名称
+	VendorTime int64  `gorm:"column:vendorTime" json:"-"` // 行情源的报价时间(unix秒)
+
+	// 哈希链: 每个币种的秒级K线按保存顺序链接, 用于证明历史报价未被修改
+	PrevHash string `gorm:"column:prevHash" json:"-"`
+	Hash     string `gorm:"column:hash" json:"-"`
+}
+
+// 哈希链的列, 只存在于秒级K线表
+var ChainColumns = []string{"prevHash", "hash"}
+
+// 记录行情源的原始报价, 已记录时不覆盖
+func (k *OptionKline) KeepRaw() {
+	if k.RawOpen != "" {
+		return
+	}
+	k.RawOpen, k.RawClose, k.RawHigh, k.RawLow = k.Open, k.Close, k.High, k.Low
 }
 
 func (k OptionKline) String() string {
@@ -82,17 +109,36 @@ func (k OptionKline) String() string {
 	)
 }
 
+// 币种的K线缓存, 由该币种的处理协程独占, 不需要加锁
 type KLineData struct {
 	Data     []*OptionKline
 	CoinType string
-	RWMutex  *sync.RWMutex
+	adjuster Adjuster // 报价干预, 为nil时不干预报价
+	clock    common.Clock
 }
 
-func NewKLineData(coinType string) *KLineData {
+func NewKLineData(coinType string, adjuster Adjuster, clock common.Clock) *KLineData {
 	return &KLineData{
 		Data:     []*OptionKline{},
 		CoinType: coinType,
-		RWMutex:  new(sync.RWMutex),
+		adjuster: adjuster,
+		clock:    clock,
+	}
+}
+
+// 报价干预, 只能使用行情数据, 不能依赖订单等用户数据
+type Adjuster interface {
+	AdjustPrice(kline *OptionKline)
+}
+
+// 报价干预: 由各币种的报价调节器平滑报价
+type PriceAdjuster struct {
+	regulators map[string]*regulator.Regulator
+}
+
+func NewPriceAdjuster(regulators map[string]*regulator.Regulator) *PriceAdjuster {
+	return &PriceAdjuster{
+		regulators: regulators,
 	}
 }
 
@@ -107,7 +153,7 @@ func (this *KLineData) CheckCapacity() {
 	}
 }
 
-func httpGet(url string, klineChan chan *OptionKline) {
+func httpGet(url string, tickQueue *Queue) {
 	fn := "httpGet"
 	log.Debugf("[%s]begin...", fn)
 	transport := &http.Transport{
@@ -163,33 +209,25 @@ func httpGet(url string, klineChan chan *OptionKline) {
 			LastUpdate: lastupdate.Unix(),
 			Time:       tn.Unix(),
 			Origin:     1,
+			Source:     SourceHTTP,
+			VendorTime: lastupdate.Unix(),
 		}
 		log.Debugf("[%s]send kline to chan: %v", fn, kline)
-		klineChan <- kline
+		tickQueue.Push(kline)
 		log.Debugf("[%s]====  success to send kline to chan", fn)
 	}
 }
 
 // 获取K线数据，每秒获取两次: 该数据源已弃用
-func GetKLineData(klineChan chan *OptionKline) {
-	defer func() { go GetKLineData(klineChan) }()
+func GetKLineData(tickQueue *Queue) {
+	defer func() { go GetKLineData(tickQueue) }()
 	defer common.CheckPanic("GetKLineData", nil)
 	for {
-		httpGet(url, klineChan)
+		httpGet(url, tickQueue)
 		time.Sleep(1000 * time.Millisecond)
 	}
 }
 
-func SaveKLine2DB(kline *OptionKline) (err error) {
-	db := common.GetDbGormConn()
-	//if err := db.Where(OptionKline{CoinType: kline.CoinType, Time: kline.Time}).
-	//	Assign(*kline).FirstOrCreate(kline).Error; err != nil {
-	if err := db.Create(kline).Error; err != nil {
-		return err
-	}
-	return nil
-}
-
 func SaveKLine2Csv(trader *Trader) {
 	f, err := os.OpenFile("./data.csv", os.O_RDWR|os.O_CREATE|os.O_APPEND, 0666)
 	if err != nil {
@@ -213,36 +251,21 @@ func SaveKLine2Csv(trader *Trader) {
 	w.Flush()
 }
 
-func DealAllHistoryPrice(klineChanMap map[string]chan *OptionKline) {
-	fn := "DealAllHistoryPrice"
-	defer common.CheckPanic(fn, nil)
-	for _, coinType := range common.CoinSupported.Load().([]string) {
-		if ch, ok := klineChanMap[coinType]; ok {
-			DealHistoryPrice(ch, coinType)
-		}
-	}
-}
-
-func DealHistoryPrice(ch chan *OptionKline, coinType string) {
+// 若当前秒无数据，则以最新一条数据为基础补充一条数据
+// @return: 需要保存并推送的K线, 无需补数据时返回nil
+func (this *KLineData) DealHistoryPrice() *OptionKline {
 	fn := "DealHistoryPrice"
-	klineData, ok := KLineDataMap[coinType]
-	if !ok {
-		log.Errorf("[%s]kline data for %s doesn't exist", fn, coinType)
-		return
-	}
-	klineData.RWMutex.Lock()
-	defer klineData.RWMutex.Unlock()
-	klineList := &klineData.Data
+	klineList := &this.Data
 	n := len(*klineList)
 	// 0. 程序刚启动，无数据，则跳过
 	if n == 0 {
-		return
+		return nil
 	}
 	lastKline := (*klineList)[n-1]
-	tn := time.Now().Unix()
+	tn := this.clock.Now().Unix()
 	// 1. 若最新一条数据时间不晚于当前时间，则不用补数据
 	if lastKline.Time >= tn {
-		return
+		return nil
 	}
 	// 2. 若最新一条数据比当前时间晚1秒以上，则以最新一条数据为基础，制造并保存一条数据，并推送
 	tmpKline := *lastKline
@@ -251,55 +274,48 @@ func DealHistoryPrice(ch chan *OptionKline, coinType string) {
 	*klineList = append(*klineList, &tmpKline)
 	dstKline := tmpKline
 
-	AdjustPrice(&dstKline)
+	this.adjustPrice(&dstKline)
 	log.Debugf("[%s][%s] before: %s after: %s, Time: %d",
 		fn, tmpKline.CoinType, tmpKline.Open, dstKline.Open, tmpKline.Time)
-	ch <- &dstKline
 	n = len(*klineList)
 	if n > common.CacheCapacity {
 		*klineList = append((*klineList)[:0], (*klineList)[n-common.CacheCapacity:]...)
 	}
+	return &dstKline
 }
 
-func DealCurrentKLine(kline *OptionKline, dbChan chan *OptionKline) {
+// 处理当前行情数据
+// @return: 需要保存并推送的K线, 当前秒已有数据时返回nil
+func (this *KLineData) DealCurrentKLine(kline *OptionKline) *OptionKline {
 	fn := "DealCurrentKLine"
-	defer common.CheckPanic(fn, nil)
-	klineData, ok := KLineDataMap[kline.CoinType]
-	if !ok {
-		log.Errorf("[%s]kline data for %s doesn't exist", fn, kline.CoinType)
-		return
-	}
-	klineData.RWMutex.Lock()
-	defer klineData.RWMutex.Unlock()
-	klineList := &klineData.Data
+	klineList := &this.Data
 	n := len(*klineList)
+	kline.KeepRaw()
 	if n == 0 {
 		*klineList = append(*klineList, kline)
-		dbChan <- kline
-		return
+		return kline
 	}
 
 	// 1. 以原始数据为基础，都要保存
-	tn := time.Now().Unix()
+	tn := this.clock.Now().Unix()
 	lastKline := (*klineList)[n-1]
 	// 若当前时间已经有数据且已发布，则不处理
 	if lastKline.Time >= tn {
-		return
+		return nil
 	}
 	kline.Time = tn
 	*klineList = append(*klineList, kline)
 	dstKline := *kline
 	// 2. 干预价格
-	AdjustPrice(&dstKline)
+	this.adjustPrice(&dstKline)
 	log.Debugf("[%s][%s] before: %s after: %s, Time: %d",
 		fn, kline.CoinType, kline.Open, dstKline.Open, kline.Time)
 
-	// 3. 保存新数据
-	dbChan <- &dstKline
 	n = len(*klineList)
 	if n > common.CacheCapacity {
 		*klineList = append((*klineList)[:0], (*klineList)[n-common.CacheCapacity:]...)
 	}
+	return &dstKline
 }
 
 func CheckPriceAmplitude(lastPrice, curPrice string) bool {
@@ -320,7 +336,13 @@ func CheckPriceAmplitude(lastPrice, curPrice string) bool {
 	return true
 }
 
-func AdjustPrice(kline *OptionKline) {
+func (this *KLineData) adjustPrice(kline *OptionKline) {
+	if this.adjuster != nil {
+		this.adjuster.AdjustPrice(kline)
+	}
+}
+
+func (a *PriceAdjuster) AdjustPrice(kline *OptionKline) {
 	fn := "AdjustPrice"
 	kline.Origin = 0
 	open, err := strconv.ParseFloat(kline.Open, 64)
@@ -347,7 +369,7 @@ func AdjustPrice(kline *OptionKline) {
 		precision = 3
 	}
 
-	reg, ok := regulator.RegulatorMap[kline.CoinType]
+	reg, ok := a.regulators[kline.CoinType]
 	if !ok {
 		log.Errorf("[%s]coin type not supported:%s", fn, kline.CoinType)
 		return
@@ -365,8 +387,6 @@ func AdjustPrice(kline *OptionKline) {
 	if newOpen < low {
 		kline.Low = kline.Open
 	}
-	// 检查当前用户营收，并调整K线
-	CheckRevenue(kline, precision)
 }
 
 func AdjustPrice1(kline *OptionKline) {
@@ -426,88 +446,3 @@ func AdjustPrice1(kline *OptionKline) {
 	}
 	//kline.LastUpdate = kline.Time
 }
-
-// 检查当前用户营收，调整报价使营收与目标营收一致
-func CheckRevenue(kline *OptionKline, precision int) {
-	fn := "CheckRevenue"
-	defer common.CheckPanic(fn, nil)
-	tn := kline.Time
-	t := tn - tn%60
-	adjustTimeBegin := t + 40
-	if kline.Time%60 == 0 {
-		adjustTimeBegin = tn - 20
-	}
-	adjustTimeEnd := adjustTimeBegin + 20
-	rd := rand.New(rand.NewSource(time.Now().UnixNano()))
-	var err error
-	ts := time.Unix(kline.Time, 0).Second()
-	// 1. 仅在用户停止下单期间调整价格
-	if ts > 5 && ts < 40 /* && kline.Time < adjustTimeBegin) || kline.Time > adjustTimeEnd */ {
-		delete(klineDstPriceMap, kline.CoinType)
-		return
-	}
-
-	// 2. 若未计算过dstPrice，则计算并获取dstPrice
-	dstPrice, ok := klineDstPriceMap[kline.CoinType]
-	if !ok {
-		dstPrice, err = GetDstPrice(kline, precision)
-		if err != nil {
-			log.Errorf("[%s]failed to GetDstPrice:%s", fn, err)
-			return
-		}
-		// 若无订单，则不干预报价
-		if dstPrice <= 0 {
-			return
-		}
-		klineDstPriceMap[kline.CoinType] = dstPrice
-	}
-
-	// 3. 调整当前报价向dstPrice靠近
-	oldPrice, err := strconv.ParseFloat(kline.Open, 64)
-	if err != nil {
-		log.Errorf("[%s]failed to parse float for %s: %s", fn, kline.Open, err)
-		return
-	}
-	tpos := tn - adjustTimeBegin
-	propotion := float64(tpos) / float64(adjustTimeEnd-adjustTimeBegin-2) //18.0
-	if propotion > 1 || propotion < 0 {
-		propotion = 1
-	}
-	diff := (dstPrice - oldPrice) * propotion
-	okPrice := oldPrice + diff
-	flag := 2 // 0: normal schedule, 1: use last price, 2: use adjusted dstPrice, don't change price value
-	if tn < adjustTimeEnd-2 {
-		// 使用上一条报价,使报价连续平滑
-		if rd.Intn(100) < 30.0 {
-			flag = 1
-		}
-	} else if tn < adjustTimeEnd /*&& rd.Intn(100) < 70.0 */ {
-		// 3.1 提前在结算之前，到达目标报价(N% probability)
-		okPrice = dstPrice
-	} else if tn == adjustTimeEnd {
-		// 3.2 结算时，报价为dstPrice
-		okPrice = dstPrice
-	} else if ts < 5 {
-		// 3.3 结算后，随机用上一条报价
-		if rd.Intn(100) < 60.0 {
-			flag = 1
-		}
-	}
-	if reg, ok := regulator.RegulatorMap[kline.CoinType]; ok {
-		okPrice = reg.AdjustPrice(&regulator.AdJustRequest{
-			CoinType:  kline.CoinType,
-			Price:     okPrice,
-			Precision: precision,
-			Flag:      flag,
-		})
-	}
-	kline.Open = strconv.FormatFloat(okPrice, 'f', precision, 64)
-	kline.Close = kline.Open
-	log.Debugf("[%s][%s]origin price: %v, adjusted price: %s, dstPrice: %v, klineTime: %v", fn, kline.CoinType, oldPrice, kline.Open, dstPrice, kline.Time)
-}
-
-func InitKLine() {
-	for _, coinType := range common.CoinSupported.Load().([]string) {
-		KLineDataMap[coinType] = NewKLineData(coinType)
-	}
-}
@@ -0,0 +1,235 @@
+package kline
+
+import (
+	log "github.com/sirupsen/logrus"
+	"option-kline/common"
+	"sync"
+	"time"
+)
+
+const (
+	saturatedWarnAfter    = 10 * time.Second // 队列持续满载多久后告警
+	saturatedWarnInterval = 10 * time.Second // 持续满载时的告警间隔
+)
+
+var (
+	queueMetrics = common.NewMetrics("queue")
+	queueMap     = make(map[string]*Queue, 10)
+	queueMutex   sync.Mutex
+)
+
+// K线缓冲队列, 队列满时按配置的策略处理:
+// block: 阻塞等待, 超时后丢弃; drop_oldest: 丢弃最旧的数据; drop_newest: 丢弃最新的数据;
+// coalesce: 每个币种只保留最新的一条
+type Queue struct {
+	name    string
+	policy  string
+	timeout time.Duration
+	ch      chan *OptionKline
+
+	// coalesce策略下待消费的数据
+	mutex   sync.Mutex
+	pending map[string]*OptionKline
+	keys    []string
+	notify  chan struct{}
+	closed  chan struct{}
+	stopped chan struct{}
+
+	saturatedSince time.Time
+	lastWarn       time.Time
+}
+
+// 根据配置创建队列
+func NewQueue(name string) *Queue {
+	conf := common.GetQueueConfig(name)
+	return NewQueueWithPolicy(name, conf.Policy, conf.Size, time.Duration(conf.Timeout)*time.Millisecond)
+}
+
+func NewQueueWithPolicy(name, policy string, size int, timeout time.Duration) *Queue {
+	q := &Queue{
+		name:    name,
+		policy:  policy,
+		timeout: timeout,
+		ch:      make(chan *OptionKline, size),
+		closed:  make(chan struct{}),
+		stopped: make(chan struct{}),
+	}
+	if policy == common.QUEUE_POLICY_COALESCE {
+		q.pending = make(map[string]*OptionKline, 10)
+		q.notify = make(chan struct{}, 1)
+		go q.pump()
+	} else {
+		close(q.stopped)
+	}
+	queueMutex.Lock()
+	queueMap[name] = q
+	queueMutex.Unlock()
+	return q
+}
+
+func (q *Queue) Name() string {
+	return q.name
+}
+
+// 消费队列
+func (q *Queue) C() <-chan *OptionKline {
+	return q.ch
+}
+
+// 被丢弃的数据数量
+func (q *Queue) Dropped() int64 {
+	return common.GetMetric(queueMetrics, q.name+".dropped")
+}
+
+func (q *Queue) Len() int {
+	if q.policy == common.QUEUE_POLICY_COALESCE {
+		q.mutex.Lock()
+		defer q.mutex.Unlock()
+		return len(q.keys) + len(q.ch)
+	}
+	return len(q.ch)
+}
+
+// 写入队列, 数据被丢弃时返回false
+func (q *Queue) Push(kline *OptionKline) bool {
+	switch q.policy {
+	case common.QUEUE_POLICY_DROP_NEWEST:
+		select {
+		case q.ch <- kline:
+			return true
+		default:
+			q.drop(kline)
+			return false
+		}
+	case common.QUEUE_POLICY_DROP_OLDEST:
+		for {
+			select {
+			case q.ch <- kline:
+				return true
+			default:
+			}
+			select {
+			case old := <-q.ch:
+				q.drop(old)
+			default:
+			}
+		}
+	case common.QUEUE_POLICY_COALESCE:
+		q.mutex.Lock()
+		if _, ok := q.pending[kline.CoinType]; ok {
+			q.pending[kline.CoinType] = kline
+			q.mutex.Unlock()
+			queueMetrics.Add(q.name+".coalesced", 1)
+			return true
+		}
+		q.pending[kline.CoinType] = kline
+		q.keys = append(q.keys, kline.CoinType)
+		q.mutex.Unlock()
+		select {
+		case q.notify <- struct{}{}:
+		default:
+		}
+		return true
+	default:
+		select {
+		case q.ch <- kline:
+			return true
+		default:
+		}
+		// 队列已满, 阻塞等待
+		if q.timeout <= 0 {
+			q.ch <- kline
+			return true
+		}
+		timer := time.NewTimer(q.timeout)
+		defer timer.Stop()
+		select {
+		case q.ch <- kline:
+			return true
+		case <-timer.C:
+			q.drop(kline)
+			return false
+		}
+	}
+}
+
+func (q *Queue) drop(kline *OptionKline) {
+	queueMetrics.Add(q.name+".dropped", 1)
+	log.Debugf("[Queue][%s]queue is full, drop kline: %v", q.name, kline)
+}
+
+// coalesce策略下, 按写入顺序将各币种的最新数据转入消费队列, 关闭时转入剩余的数据后退出
+func (q *Queue) pump() {
+	defer close(q.stopped)
+	for {
+		closed := false
+		select {
+		case <-q.notify:
+		case <-q.closed:
+			closed = true
+		}
+		for {
+			q.mutex.Lock()
+			if len(q.keys) == 0 {
+				q.mutex.Unlock()
+				break
+			}
+			key := q.keys[0]
+			q.keys = q.keys[1:]
+			kline := q.pending[key]
+			delete(q.pending, key)
+			q.mutex.Unlock()
+			q.ch <- kline
+		}
+		if closed {
+			return
+		}
+	}
+}
+
+// 检查队列是否持续满载, 并更新指标
+func (q *Queue) check(now time.Time) {
+	common.SetMetric(queueMetrics, q.name+".len", int64(q.Len()))
+	if len(q.ch) < cap(q.ch) {
+		q.saturatedSince = time.Time{}
+		common.SetMetric(queueMetrics, q.name+".saturated", 0)
+		return
+	}
+	if q.saturatedSince.IsZero() {
+		q.saturatedSince = now
+	}
+	common.SetMetric(queueMetrics, q.name+".saturated", 1)
+	if now.Sub(q.saturatedSince) >= saturatedWarnAfter && now.Sub(q.lastWarn) >= saturatedWarnInterval {
+		q.lastWarn = now
+		log.Warnf("[Queue][%s]queue has been saturated for %v, policy: %s, size: %d, dropped: %d",
+			q.name, now.Sub(q.saturatedSince), q.policy, cap(q.ch), common.GetMetric(queueMetrics, q.name+".dropped"))
+	}
+}
+
+// 关闭队列, 关闭后不能再写入; 已写入的数据仍可被消费, 直到C()被关闭.
+// coalesce策略下等待剩余的数据(每个币种最多一条)转入消费队列, 需要有消费者在读取
+func (q *Queue) Close() {
+	queueMutex.Lock()
+	if queueMap[q.name] == q {
+		delete(queueMap, q.name)
+	}
+	queueMutex.Unlock()
+	close(q.closed)
+	<-q.stopped
+	close(q.ch)
+}
+
+// 定期检查所有队列的饱和状态
+func MonitorQueues() {
+	defer func() { go MonitorQueues() }()
+	defer common.CheckPanic("MonitorQueues", nil)
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	for now := range ticker.C {
+		queueMutex.Lock()
+		for _, q := range queueMap {
+			q.check(now)
+		}
+		queueMutex.Unlock()
+	}
+}
@@ -0,0 +1,143 @@
+package kline
+
+import (
+	"option-kline/common"
+	"testing"
+	"time"
+)
+
+func newTick(coinType string, t int64) *OptionKline {
+	return &OptionKline{CoinType: coinType, Open: "1.0", Close: "1.0", High: "1.0", Low: "1.0", Time: t}
+}
+
+func drain(q *Queue) (klineList []*OptionKline) {
+	for {
+		select {
+		case k := <-q.C():
+			klineList = append(klineList, k)
+		case <-time.After(50 * time.Millisecond):
+			return
+		}
+	}
+}
+
+func TestQueueDropNewest(t *testing.T) {
+	q := NewQueueWithPolicy("test.drop_newest", common.QUEUE_POLICY_DROP_NEWEST, 2, 0)
+	defer q.Close()
+	for i := int64(1); i <= 4; i++ {
+		q.Push(newTick("GT", i))
+	}
+	klineList := drain(q)
+	if len(klineList) != 2 || klineList[0].Time != 1 || klineList[1].Time != 2 {
+		t.Errorf("drop_newest should keep the first 2 klines, actual: %v", klineList)
+	}
+	if q.Dropped() != 2 {
+		t.Errorf("dropped should be 2, actual: %d", q.Dropped())
+	}
+}
+
+func TestQueueDropOldest(t *testing.T) {
+	q := NewQueueWithPolicy("test.drop_oldest", common.QUEUE_POLICY_DROP_OLDEST, 2, 0)
+	defer q.Close()
+	for i := int64(1); i <= 4; i++ {
+		if !q.Push(newTick("GT", i)) {
+			t.Errorf("drop_oldest should always accept new kline")
+		}
+	}
+	klineList := drain(q)
+	if len(klineList) != 2 || klineList[0].Time != 3 || klineList[1].Time != 4 {
+		t.Errorf("drop_oldest should keep the last 2 klines, actual: %v", klineList)
+	}
+	if q.Dropped() != 2 {
+		t.Errorf("dropped should be 2, actual: %d", q.Dropped())
+	}
+}
+
+func TestQueueBlockTimeout(t *testing.T) {
+	q := NewQueueWithPolicy("test.block", common.QUEUE_POLICY_BLOCK, 1, 50*time.Millisecond)
+	defer q.Close()
+	if !q.Push(newTick("GT", 1)) {
+		t.Fatal("push to empty queue should succeed")
+	}
+	begin := time.Now()
+	if q.Push(newTick("GT", 2)) {
+		t.Error("push to full queue should fail after timeout")
+	}
+	if time.Since(begin) < 50*time.Millisecond {
+		t.Error("push to full queue should block until timeout")
+	}
+
+	// 超时前被消费, 则写入成功
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		<-q.C()
+	}()
+	if !q.Push(newTick("GT", 3)) {
+		t.Error("push should succeed once the consumer catches up")
+	}
+	if q.Dropped() != 1 {
+		t.Errorf("dropped should be 1, actual: %d", q.Dropped())
+	}
+}
+
+func TestQueueCoalesce(t *testing.T) {
+	q := NewQueueWithPolicy("test.coalesce", common.QUEUE_POLICY_COALESCE, 1, 0)
+	defer q.Close()
+	// 消费者未读取时, 每个币种只保留最新一条
+	q.Push(newTick("GT", 1))
+	time.Sleep(20 * time.Millisecond)
+	for i := int64(2); i <= 5; i++ {
+		q.Push(newTick("GT", i))
+		q.Push(newTick("USDT", i))
+	}
+	klineList := drain(q)
+	latest := map[string]int64{}
+	for _, k := range klineList {
+		latest[k.CoinType] = k.Time
+	}
+	// 最多有一条数据正在转入消费队列而未被合并
+	if len(klineList) > 4 || klineList[0].Time != 1 || latest["GT"] != 5 || latest["USDT"] != 5 {
+		t.Errorf("coalesce should keep the latest kline of each coin type, actual: %v", klineList)
+	}
+}
+
+func TestQueueSaturated(t *testing.T) {
+	q := NewQueueWithPolicy("test.saturated", common.QUEUE_POLICY_DROP_NEWEST, 1, 0)
+	defer q.Close()
+	q.Push(newTick("GT", 1))
+	now := time.Now()
+	q.check(now)
+	if common.GetMetric(queueMetrics, "test.saturated.saturated") != 1 {
+		t.Error("full queue should be marked as saturated")
+	}
+	q.check(now.Add(saturatedWarnAfter))
+	if !q.lastWarn.Equal(now.Add(saturatedWarnAfter)) {
+		t.Error("saturated queue should warn after saturatedWarnAfter")
+	}
+	<-q.C()
+	q.check(now.Add(saturatedWarnAfter + time.Second))
+	if common.GetMetric(queueMetrics, "test.saturated.saturated") != 0 || !q.saturatedSince.IsZero() {
+		t.Error("queue should not be saturated after consumed")
+	}
+}
+
+// 关闭时仍转入合并中的数据
+func TestQueueCoalesceCloseKeepsPending(t *testing.T) {
+	q := NewQueueWithPolicy("test.coalesce.close", common.QUEUE_POLICY_COALESCE, 1, 0)
+	for _, coinType := range []string{"GT", "USDT", "BTC"} {
+		q.Push(newTick(coinType, 1))
+	}
+	klineList := []*OptionKline{}
+	done := make(chan struct{})
+	go func() {
+		for k := range q.C() {
+			klineList = append(klineList, k)
+		}
+		close(done)
+	}()
+	q.Close()
+	<-done
+	if len(klineList) != 3 {
+		t.Errorf("pending klines should be delivered on close, actual: %v", klineList)
+	}
+}
@@ -0,0 +1,122 @@
+package kline
+
+import (
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"option-kline/common"
+	"time"
+)
+
+const (
+	fillInterval = 500 * time.Millisecond // 补数据的检查间隔
+	restartDelay = time.Second            // 处理协程panic后的重启间隔
+)
+
+var (
+	workerMetrics = common.NewMetrics("worker")
+)
+
+// 币种K线处理协程: 独占该币种的K线缓存, 处理行情数据, 并在当前秒无数据时补数据;
+// 各币种互不影响, 某个币种处理缓慢或panic不会拖累其他币种.
+// K线时间由clock决定, 处理延迟等指标始终使用系统时间
+type Worker struct {
+	coinType  string
+	klineData *KLineData
+	inbox     *Queue // 行情数据
+	out       *Queue // 待保存并推送的K线
+	quit      chan struct{}
+	done      chan struct{}
+}
+
+func NewWorker(coinType string, adjuster Adjuster, clock common.Clock, out *Queue) *Worker {
+	return &Worker{
+		coinType:  coinType,
+		klineData: NewKLineData(coinType, adjuster, clock),
+		inbox:     NewQueue("worker." + coinType),
+		out:       out,
+		quit:      make(chan struct{}),
+		done:      make(chan struct{}),
+	}
+}
+
+func (w *Worker) CoinType() string {
+	return w.coinType
+}
+
+// 写入行情数据
+func (w *Worker) Push(kline *OptionKline) bool {
+	return w.inbox.Push(kline)
+}
+
+// 运行处理协程, panic后只重启当前币种的处理协程
+func (w *Worker) Run() {
+	defer close(w.done)
+	for !w.loop() {
+		workerMetrics.Add(w.coinType+".restarts", 1)
+		// 停止时立即重启, 处理完剩余的行情数据
+		select {
+		case <-time.After(restartDelay):
+		case <-w.quit:
+		}
+	}
+}
+
+// 处理行情数据及补数据, 行情队列关闭且已处理完时返回true; 发生panic时返回false
+func (w *Worker) loop() (stopped bool) {
+	defer common.CheckPanic(fmt.Sprintf("Worker.%s", w.coinType), nil)
+	ticker := time.NewTicker(fillInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case kline, ok := <-w.inbox.C():
+			if !ok {
+				return true
+			}
+			w.HandleTick(kline)
+		case <-ticker.C:
+			// 若当前秒无数据，则用上一秒数据
+			w.Fill()
+		}
+	}
+}
+
+// 处理一条行情数据
+func (w *Worker) HandleTick(kline *OptionKline) {
+	begin := time.Now()
+	if dstKline := w.klineData.DealCurrentKLine(kline); dstKline != nil {
+		w.emit(dstKline)
+	}
+	latency := time.Since(begin)
+	workerMetrics.Add(w.coinType+".ticks", 1)
+	workerMetrics.Add(w.coinType+".latency_us_total", latency.Nanoseconds()/1000)
+	common.SetMetric(workerMetrics, w.coinType+".latency_us", latency.Nanoseconds()/1000)
+	// 行情数据从产生到处理完成的延迟
+	if kline.LastUpdate > 0 {
+		common.SetMetric(workerMetrics, w.coinType+".delay_ms", begin.UnixNano()/1e6-kline.LastUpdate*1000)
+	}
+}
+
+// 补数据
+func (w *Worker) Fill() {
+	begin := time.Now()
+	dstKline := w.klineData.DealHistoryPrice()
+	if dstKline == nil {
+		return
+	}
+	w.emit(dstKline)
+	workerMetrics.Add(w.coinType+".fills", 1)
+	common.SetMetric(workerMetrics, w.coinType+".fill_latency_us", time.Since(begin).Nanoseconds()/1000)
+}
+
+func (w *Worker) emit(dstKline *OptionKline) {
+	if !w.out.Push(dstKline) {
+		log.Errorf("[Worker][%s]kline queue is full, drop kline: %v", w.coinType, dstKline)
+	}
+}
+
+// 停止处理协程: 不再接收行情数据, 等待已收到的行情处理完成, 调用前需停止写入
+func (w *Worker) Stop() {
+	w.inbox.Close()
+	close(w.quit)
+	<-w.done
+}
@@ -0,0 +1,92 @@
+package kline
+
+import (
+	"option-kline/common"
+	"testing"
+	"time"
+)
+
+func newTestWorker(clock common.Clock) (*Worker, *Queue) {
+	out := NewQueueWithPolicy("test.worker.out", common.QUEUE_POLICY_DROP_NEWEST, 100, 0)
+	return NewWorker("GT", nil, clock, out), out
+}
+
+func TestWorkerHandleTick(t *testing.T) {
+	clock := common.NewFakeClock(time.Unix(1000, 0))
+	w, out := newTestWorker(clock)
+	defer out.Close()
+
+	w.HandleTick(newTick("GT", 1000))
+	// 同一秒内的行情不再保存
+	w.HandleTick(newTick("GT", 1000))
+	clock.Advance(time.Second)
+	w.HandleTick(newTick("GT", 0))
+
+	klineList := drain(out)
+	if len(klineList) != 2 || klineList[0].Time != 1000 || klineList[1].Time != 1001 {
+		t.Errorf("worker should emit one kline per second, actual: %v", klineList)
+	}
+}
+
+func TestWorkerFill(t *testing.T) {
+	clock := common.NewFakeClock(time.Unix(1000, 0))
+	w, out := newTestWorker(clock)
+	defer out.Close()
+
+	// 无数据时不补数据
+	w.Fill()
+	w.HandleTick(newTick("GT", 1000))
+	// 当前秒已有数据时不补数据
+	w.Fill()
+	clock.Advance(500 * time.Millisecond)
+	w.Fill()
+	clock.Advance(500 * time.Millisecond)
+	w.Fill()
+	clock.Advance(3 * time.Second)
+	w.Fill()
+
+	klineList := drain(out)
+	if len(klineList) != 3 {
+		t.Fatalf("worker should fill 2 klines, actual: %v", klineList)
+	}
+	for idx, tm := range []int64{1000, 1001, 1004} {
+		if klineList[idx].Time != tm {
+			t.Errorf("unexpected kline time at %d, expected: %d, actual: %d", idx, tm, klineList[idx].Time)
+		}
+	}
+	if klineList[1].Origin != 0 || klineList[1].Open != klineList[0].Open {
+		t.Errorf("filled kline should copy the last price as synthetic data: %s", klineList[1])
+	}
+}
+
+func TestWorkerReplayClock(t *testing.T) {
+	clock := common.NewReplayClock(time.Time{})
+	w, out := newTestWorker(clock)
+	defer out.Close()
+
+	// 由行情时间推进时钟, 乱序的旧行情不会使时钟倒退
+	for _, tm := range []int64{2000, 2001, 1999, 2003} {
+		clock.Observe(time.Unix(tm, 0))
+		w.HandleTick(newTick("GT", tm))
+	}
+	klineList := drain(out)
+	if len(klineList) != 3 || klineList[1].Time != 2001 || klineList[2].Time != 2003 {
+		t.Errorf("replayed klines should follow tick time, actual: %v", klineList)
+	}
+}
+
+// 停止时处理完已收到的行情数据
+func TestWorkerStopDrainsInbox(t *testing.T) {
+	out := NewQueueWithPolicy("test.worker.stop.out", common.QUEUE_POLICY_DROP_NEWEST, 100, 0)
+	defer out.Close()
+	w := NewWorker("STOP", nil, common.NewFakeClock(time.Unix(1000, 0)), out)
+	before := common.GetMetric(workerMetrics, "STOP.ticks")
+	for i := 0; i < 50; i++ {
+		w.Push(newTick("STOP", 1000))
+	}
+	go w.Run()
+	w.Stop()
+	if ticks := common.GetMetric(workerMetrics, "STOP.ticks") - before; ticks != 50 {
+		t.Errorf("all received ticks should be handled before stop, actual: %d", ticks)
+	}
+}
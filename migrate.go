@@ -0,0 +1,76 @@
+package main
+
+import (
+	"fmt"
+	"github.com/jinzhu/gorm"
+	"option-kline/common"
+	"option-kline/migrations"
+	"option-kline/store"
+	"strconv"
+	"time"
+)
+
+const migrateUsage = "usage: option-kline migrate up | down [steps] | status"
+
+// 表结构变更: 未配置MySQL且使用sqlite存储时, 操作sqlite数据库文件
+func openMigrateDB() (*gorm.DB, error) {
+	if common.StoreDriver == common.STORE_SQLITE && common.DBCONF.Host == "" {
+		return store.OpenSQLite(common.StoreSQLitePath)
+	}
+	return common.NewGormDB(common.DBCONF)
+}
+
+func runMigrate(args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf(migrateUsage)
+	}
+	db, err := openMigrateDB()
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	switch args[0] {
+	case "up":
+		done, err := migrations.Up(db)
+		for _, m := range done {
+			fmt.Printf("applied %d %s\n", m.Version, m.Name)
+		}
+		if err != nil {
+			return err
+		}
+		if len(done) == 0 {
+			fmt.Printf("schema is up to date at %d\n", migrations.Latest())
+		}
+	case "down":
+		// 默认只回滚一个版本
+		steps := 1
+		if len(args) > 1 {
+			if steps, err = strconv.Atoi(args[1]); err != nil || steps <= 0 {
+				return fmt.Errorf("invalid steps: %s", args[1])
+			}
+		}
+		done, err := migrations.Down(db, steps)
+		for _, m := range done {
+			fmt.Printf("reverted %d %s\n", m.Version, m.Name)
+		}
+		return err
+	case "status":
+		statusList, err := migrations.StatusList(db)
+		if err != nil {
+			return err
+		}
+		current, _ := migrations.Current(db)
+		fmt.Printf("current: %d, latest: %d\n", current, migrations.Latest())
+		for _, s := range statusList {
+			appliedAt := "pending"
+			if s.Applied {
+				appliedAt = time.Unix(s.AppliedAt, 0).Format("2006-01-02 15:04:05")
+			}
+			fmt.Printf("%4d  %-32s %s\n", s.Version, s.Name, appliedAt)
+		}
+	default:
+		return fmt.Errorf(migrateUsage)
+	}
+	return nil
+}
@@ -0,0 +1,204 @@
+package migrations
+
+import (
+	"fmt"
+	"github.com/jinzhu/gorm"
+	log "github.com/sirupsen/logrus"
+	"sort"
+	"time"
+)
+
+// 数据库类型, 与gorm的dialect名称一致
+const (
+	DialectMySQL  = "mysql"
+	DialectSQLite = "sqlite3"
+)
+
+// 版本记录表
+const versionTable = "schema_migrations"
+
+// 基础版本: 记录已有的表, 不可回滚
+const BaseVersion = 1
+
+// 一次表结构变更: 按数据库类型提供升级及回滚语句
+// 已发布的变更不可修改, 需要调整表结构时追加新的版本
+type Migration struct {
+	Version int
+	Name    string
+	Up      map[string][]string
+	Down    map[string][]string
+}
+
+// 已执行的版本
+type SchemaMigration struct {
+	Version   int    `gorm:"column:version;primary_key;auto_increment:false"`
+	Name      string `gorm:"column:name"`
+	AppliedAt int64  `gorm:"column:applied_at"`
+}
+
+func (SchemaMigration) TableName() string {
+	return versionTable
+}
+
+// 版本状态
+type Status struct {
+	Version   int
+	Name      string
+	Applied   bool
+	AppliedAt int64
+}
+
+// 代码要求的最新版本
+func Latest() int {
+	if len(Migrations) == 0 {
+		return 0
+	}
+	return Migrations[len(Migrations)-1].Version
+}
+
+func dialect(db *gorm.DB) string {
+	return db.Dialect().GetName()
+}
+
+func ensureVersionTable(db *gorm.DB) error {
+	if err := db.AutoMigrate(&SchemaMigration{}).Error; err != nil {
+		return fmt.Errorf("failed to create %s: %s", versionTable, err)
+	}
+	return nil
+}
+
+// 已执行的版本, 版本记录表不存在时视为未执行任何版本
+func applied(db *gorm.DB) (map[int]SchemaMigration, error) {
+	if !db.HasTable(versionTable) {
+		return map[int]SchemaMigration{}, nil
+	}
+	records := []SchemaMigration{}
+	if err := db.Order("version").Find(&records).Error; err != nil {
+		return nil, fmt.Errorf("failed to query %s: %s", versionTable, err)
+	}
+	versions := make(map[int]SchemaMigration, len(records))
+	for _, r := range records {
+		versions[r.Version] = r
+	}
+	return versions, nil
+}
+
+// 数据库当前版本, 即已执行的最大版本
+func Current(db *gorm.DB) (int, error) {
+	versions, err := applied(db)
+	if err != nil {
+		return 0, err
+	}
+	current := 0
+	for version := range versions {
+		if version > current {
+			current = version
+		}
+	}
+	return current, nil
+}
+
+// 各版本的执行状态
+func StatusList(db *gorm.DB) ([]Status, error) {
+	versions, err := applied(db)
+	if err != nil {
+		return nil, err
+	}
+	statusList := make([]Status, 0, len(Migrations))
+	for _, m := range Migrations {
+		r, ok := versions[m.Version]
+		statusList = append(statusList, Status{Version: m.Version, Name: m.Name, Applied: ok, AppliedAt: r.AppliedAt})
+	}
+	return statusList, nil
+}
+
+func run(db *gorm.DB, m Migration, stmts map[string][]string) error {
+	sqlList, ok := stmts[dialect(db)]
+	if !ok {
+		return fmt.Errorf("migration %d %s does not support %s", m.Version, m.Name, dialect(db))
+	}
+	for _, sql := range sqlList {
+		if err := db.Exec(sql).Error; err != nil {
+			return fmt.Errorf("migration %d %s failed: %s, sql: %s", m.Version, m.Name, err, sql)
+		}
+	}
+	return nil
+}
+
+// 执行所有未执行的版本, 返回本次执行的版本
+// 注: MySQL的DDL语句会隐式提交事务, 执行失败时需人工确认表结构后重新执行
+func Up(db *gorm.DB) (done []Migration, err error) {
+	fn := "migrations.Up"
+	if err := ensureVersionTable(db); err != nil {
+		return nil, err
+	}
+	versions, err := applied(db)
+	if err != nil {
+		return nil, err
+	}
+	for _, m := range Migrations {
+		if _, ok := versions[m.Version]; ok {
+			continue
+		}
+		if err := run(db, m, m.Up); err != nil {
+			return done, err
+		}
+		record := &SchemaMigration{Version: m.Version, Name: m.Name, AppliedAt: time.Now().Unix()}
+		if err := db.Create(record).Error; err != nil {
+			return done, fmt.Errorf("failed to record migration %d: %s", m.Version, err)
+		}
+		log.Infof("[%s]applied migration %d %s", fn, m.Version, m.Name)
+		done = append(done, m)
+	}
+	return done, nil
+}
+
+// 按版本倒序回滚最近执行的steps个版本, 返回本次回滚的版本; 不可回滚至BaseVersion之前
+func Down(db *gorm.DB, steps int) (done []Migration, err error) {
+	fn := "migrations.Down"
+	versions, err := applied(db)
+	if err != nil {
+		return nil, err
+	}
+	revertible := 0
+	for version := range versions {
+		if version > BaseVersion {
+			revertible++
+		}
+	}
+	if steps > revertible {
+		return nil, fmt.Errorf("can not revert %d migrations, only %d applied after base version %d", steps, revertible, BaseVersion)
+	}
+	list := make([]Migration, len(Migrations))
+	copy(list, Migrations)
+	sort.Slice(list, func(i, j int) bool { return list[i].Version > list[j].Version })
+	for _, m := range list {
+		if len(done) >= steps {
+			break
+		}
+		if _, ok := versions[m.Version]; !ok {
+			continue
+		}
+		if err := run(db, m, m.Down); err != nil {
+			return done, err
+		}
+		if err := db.Delete(&SchemaMigration{Version: m.Version}).Error; err != nil {
+			return done, fmt.Errorf("failed to delete migration record %d: %s", m.Version, err)
+		}
+		log.Infof("[%s]reverted migration %d %s", fn, m.Version, m.Name)
+		done = append(done, m)
+	}
+	return done, nil
+}
+
+// 检查数据库版本与代码要求的版本是否一致, 启动时调用, 不一致时拒绝启动
+func Check(db *gorm.DB) error {
+	current, err := Current(db)
+	if err != nil {
+		return err
+	}
+	if current != Latest() {
+		return fmt.Errorf("schema version mismatch: database is at %d, code requires %d, run `migrate up`", current, Latest())
+	}
+	return nil
+}
@@ -0,0 +1,113 @@
+package migrations
+
+import (
+	"github.com/jinzhu/gorm"
+	_ "github.com/jinzhu/gorm/dialects/sqlite"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+)
+
+func openTestDB(t *testing.T) *gorm.DB {
+	dir, err := ioutil.TempDir("", "kline-migrations")
+	if err != nil {
+		t.Fatal(err)
+	}
+	db, err := gorm.Open("sqlite3", dir+"/kline.db")
+	if err != nil {
+		t.Fatalf("failed to open sqlite: %s", err)
+	}
+	t.Cleanup(func() {
+		db.Close()
+		os.RemoveAll(dir)
+	})
+	return db
+}
+
+func TestMigrateUpDown(t *testing.T) {
+	db := openTestDB(t)
+	if err := Check(db); err == nil {
+		t.Error("check should fail on an empty database")
+	}
+
+	done, err := Up(db)
+	if err != nil {
+		t.Fatalf("failed to migrate up: %s", err)
+	}
+	if len(done) != len(Migrations) {
+		t.Errorf("all migrations should be applied, actual: %d", len(done))
+	}
+	if err := Check(db); err != nil {
+		t.Errorf("check should pass after migrating up: %s", err)
+	}
+	for _, table := range []string{"option_kline", "option_order", "option_setting", "option_kline_1m", "option_kline_1d"} {
+		if !db.HasTable(table) {
+			t.Errorf("table %s should be created", table)
+		}
+	}
+	if err := db.Exec("INSERT INTO option_kline (coinType, time, rawOpen, hash) VALUES ('GT', 100, '1280.1', 'x')").Error; err != nil {
+		t.Fatal(err)
+	}
+	// 重复执行不做任何变更
+	if done, err = Up(db); err != nil || len(done) != 0 {
+		t.Errorf("up should be idempotent, applied: %d, err: %v", len(done), err)
+	}
+
+	if done, err = Down(db, 1); err != nil || len(done) != 1 || done[0].Version != Latest() {
+		t.Fatalf("the latest migration should be reverted, actual: %v, err: %v", done, err)
+	}
+	if current, _ := Current(db); current != Latest()-1 {
+		t.Errorf("unexpected version after down: %d", current)
+	}
+	if err := Check(db); err == nil {
+		t.Error("check should fail when schema is behind")
+	}
+	statusList, err := StatusList(db)
+	if err != nil {
+		t.Fatalf("failed to get status: %s", err)
+	}
+	if last := statusList[len(statusList)-1]; last.Applied || !statusList[0].Applied {
+		t.Errorf("unexpected status: %v", statusList)
+	}
+
+	// 回滚至版本2: 删除周期K线表
+	if done, err = Down(db, Latest()-3); err != nil || len(done) != Latest()-3 {
+		t.Fatalf("unexpected reverted migrations: %v, err: %v", done, err)
+	}
+	if current, _ := Current(db); current != 2 || db.HasTable("option_kline_1m") {
+		t.Errorf("interval tables should be dropped at version 2, actual version: %d", current)
+	}
+	// sqlite删除列时重建表, 保留数据及索引
+	count := 0
+	if err := db.Table("option_kline").Where("coinType = ? AND time = ?", "GT", 100).Count(&count).Error; err != nil || count != 1 {
+		t.Errorf("klines should be kept after dropping columns, count: %d, err: %v", count, err)
+	}
+	if db.Dialect().HasColumn("option_kline", "hash") || db.Dialect().HasColumn("option_kline", "rawOpen") {
+		t.Error("columns should be dropped")
+	}
+	if !db.Dialect().HasIndex("option_kline", "idx_option_kline_coin_time") {
+		t.Error("index should be rebuilt")
+	}
+	// 不可回滚基础版本, 超出时不回滚任何版本
+	if done, err = Down(db, 2); err == nil || len(done) != 0 {
+		t.Errorf("down below base version should be refused, actual: %d, err: %v", len(done), err)
+	}
+	if done, err = Down(db, 1); err != nil || len(done) != 1 {
+		t.Errorf("migrations after base version should be reverted, actual: %d, err: %v", len(done), err)
+	}
+	if current, _ := Current(db); current != BaseVersion || !db.HasTable("option_kline") || !db.HasTable("option_order") {
+		t.Errorf("base tables should be kept, actual version: %d", current)
+	}
+}
+
+// sqlite 3.35之前不支持DROP COLUMN
+func TestSQLiteWithoutDropColumn(t *testing.T) {
+	for _, m := range Migrations {
+		for _, sql := range append(append([]string{}, m.Up[DialectSQLite]...), m.Down[DialectSQLite]...) {
+			if strings.Contains(sql, "DROP COLUMN") {
+				t.Errorf("migration %d %s: %s", m.Version, m.Name, sql)
+			}
+		}
+	}
+}
@@ -0,0 +1,395 @@
+package migrations
+
+import (
+	"fmt"
+	"strings"
+)
+
+// 表结构版本, 按版本号升序排列
+var Migrations = []Migration{
+	{
+		// 已有数据库中的表保持不变, 仅记录版本; 订单及配置表不属于本服务, 不可回滚
+		Version: 1,
+		Name:    "create_base_tables",
+		Up: map[string][]string{
+			DialectMySQL: {
+				"CREATE TABLE IF NOT EXISTS `option_kline` (" +
+					"`id` BIGINT NOT NULL AUTO_INCREMENT, " +
+					"`coinType` VARCHAR(16) NOT NULL DEFAULT '', " +
+					"`open` VARCHAR(32) NOT NULL DEFAULT '', " +
+					"`close` VARCHAR(32) NOT NULL DEFAULT '', " +
+					"`high` VARCHAR(32) NOT NULL DEFAULT '', " +
+					"`low` VARCHAR(32) NOT NULL DEFAULT '', " +
+					"`time` BIGINT NOT NULL DEFAULT 0, " +
+					"`lastupdate` BIGINT NOT NULL DEFAULT 0, " +
+					"`origin` TINYINT UNSIGNED NOT NULL DEFAULT 0, " +
+					"PRIMARY KEY (`id`)" +
+					") ENGINE=InnoDB DEFAULT CHARSET=utf8",
+				"CREATE TABLE IF NOT EXISTS `option_order` (" +
+					"`id` BIGINT NOT NULL AUTO_INCREMENT, " +
+					"`userId` VARCHAR(64) NOT NULL DEFAULT '', " +
+					"`agentId` VARCHAR(64) NOT NULL DEFAULT '', " +
+					"`tokenType` VARCHAR(16) NOT NULL DEFAULT '', " +
+					"`coinType` VARCHAR(16) NOT NULL DEFAULT '', " +
+					"`type` VARCHAR(8) NOT NULL DEFAULT '', " +
+					"`amount` DECIMAL(32,8) NOT NULL DEFAULT 0, " +
+					"`agentAmount` DECIMAL(32,8) NOT NULL DEFAULT 0, " +
+					"`issueNumber` VARCHAR(32) NOT NULL DEFAULT '', " +
+					"`status` INT NOT NULL DEFAULT 0, " +
+					"`openTime` BIGINT NOT NULL DEFAULT 0, " +
+					"`result` INT NOT NULL DEFAULT 0, " +
+					"`createTime` BIGINT NOT NULL DEFAULT 0, " +
+					"`updateTime` BIGINT NOT NULL DEFAULT 0, " +
+					"`openPrice` VARCHAR(32) NOT NULL DEFAULT '', " +
+					"`closePrice` VARCHAR(32) NOT NULL DEFAULT '', " +
+					"`profit` DECIMAL(32,8) NOT NULL DEFAULT 0, " +
+					"`fee` DECIMAL(32,8) NOT NULL DEFAULT 0, " +
+					"`revenue` DECIMAL(32,8) NOT NULL DEFAULT 0, " +
+					"PRIMARY KEY (`id`)" +
+					") ENGINE=InnoDB DEFAULT CHARSET=utf8",
+				"CREATE TABLE IF NOT EXISTS `option_setting` (" +
+					"`id` BIGINT NOT NULL AUTO_INCREMENT, " +
+					"`type` VARCHAR(32) NOT NULL DEFAULT '', " +
+					"`keyName` VARCHAR(64) NOT NULL DEFAULT '', " +
+					"`value` VARCHAR(1024) NOT NULL DEFAULT '', " +
+					"`remarks` VARCHAR(255) NOT NULL DEFAULT '', " +
+					"`updateTime` BIGINT NOT NULL DEFAULT 0, " +
+					"PRIMARY KEY (`id`)" +
+					") ENGINE=InnoDB DEFAULT CHARSET=utf8",
+			},
+			DialectSQLite: {
+				"CREATE TABLE IF NOT EXISTS option_kline (" +
+					"id INTEGER PRIMARY KEY AUTOINCREMENT, " +
+					"coinType VARCHAR(16) NOT NULL DEFAULT '', " +
+					"open VARCHAR(32) NOT NULL DEFAULT '', " +
+					"close VARCHAR(32) NOT NULL DEFAULT '', " +
+					"high VARCHAR(32) NOT NULL DEFAULT '', " +
+					"low VARCHAR(32) NOT NULL DEFAULT '', " +
+					"time BIGINT NOT NULL DEFAULT 0, " +
+					"lastupdate BIGINT NOT NULL DEFAULT 0, " +
+					"origin TINYINT NOT NULL DEFAULT 0)",
+				"CREATE TABLE IF NOT EXISTS option_order (" +
+					"id INTEGER PRIMARY KEY AUTOINCREMENT, " +
+					"userId VARCHAR(64) NOT NULL DEFAULT '', " +
+					"agentId VARCHAR(64) NOT NULL DEFAULT '', " +
+					"tokenType VARCHAR(16) NOT NULL DEFAULT '', " +
+					"coinType VARCHAR(16) NOT NULL DEFAULT '', " +
+					"type VARCHAR(8) NOT NULL DEFAULT '', " +
+					"amount DECIMAL(32,8) NOT NULL DEFAULT 0, " +
+					"agentAmount DECIMAL(32,8) NOT NULL DEFAULT 0, " +
+					"issueNumber VARCHAR(32) NOT NULL DEFAULT '', " +
+					"status INT NOT NULL DEFAULT 0, " +
+					"openTime BIGINT NOT NULL DEFAULT 0, " +
+					"result INT NOT NULL DEFAULT 0, " +
+					"createTime BIGINT NOT NULL DEFAULT 0, " +
+					"updateTime BIGINT NOT NULL DEFAULT 0, " +
+					"openPrice VARCHAR(32) NOT NULL DEFAULT '', " +
+					"closePrice VARCHAR(32) NOT NULL DEFAULT '', " +
+					"profit DECIMAL(32,8) NOT NULL DEFAULT 0, " +
+					"fee DECIMAL(32,8) NOT NULL DEFAULT 0, " +
+					"revenue DECIMAL(32,8) NOT NULL DEFAULT 0)",
+				"CREATE TABLE IF NOT EXISTS option_setting (" +
+					"id INTEGER PRIMARY KEY AUTOINCREMENT, " +
+					"type VARCHAR(32) NOT NULL DEFAULT '', " +
+					"keyName VARCHAR(64) NOT NULL DEFAULT '', " +
+					"value VARCHAR(1024) NOT NULL DEFAULT '', " +
+					"remarks VARCHAR(255) NOT NULL DEFAULT '', " +
+					"updateTime BIGINT NOT NULL DEFAULT 0)",
+			},
+		},
+		Down: map[string][]string{
+			DialectMySQL:  {},
+			DialectSQLite: {},
+		},
+	},
+	{
+		// 按币种及时间查询K线, 按币种及开奖时间查询订单
+		Version: 2,
+		Name:    "add_query_indexes",
+		Up: map[string][]string{
+			DialectMySQL: {
+				"ALTER TABLE `option_kline` ADD INDEX `idx_option_kline_coin_time` (`coinType`, `time`)",
+				"ALTER TABLE `option_order` ADD INDEX `idx_option_order_coin_open_time` (`coinType`, `openTime`)",
+				"ALTER TABLE `option_setting` ADD INDEX `idx_option_setting_key_name` (`keyName`)",
+			},
+			DialectSQLite: {
+				"CREATE INDEX idx_option_kline_coin_time ON option_kline (coinType, time)",
+				"CREATE INDEX idx_option_order_coin_open_time ON option_order (coinType, openTime)",
+				"CREATE INDEX idx_option_setting_key_name ON option_setting (keyName)",
+			},
+		},
+		Down: map[string][]string{
+			DialectMySQL: {
+				"ALTER TABLE `option_setting` DROP INDEX `idx_option_setting_key_name`",
+				"ALTER TABLE `option_order` DROP INDEX `idx_option_order_coin_open_time`",
+				"ALTER TABLE `option_kline` DROP INDEX `idx_option_kline_coin_time`",
+			},
+			DialectSQLite: {
+				"DROP INDEX IF EXISTS idx_option_setting_key_name",
+				"DROP INDEX IF EXISTS idx_option_order_coin_open_time",
+				"DROP INDEX IF EXISTS idx_option_kline_coin_time",
+			},
+		},
+	},
+	{
+		// 分钟及以上周期的K线表, 每个币种每个周期一条
+		Version: 3,
+		Name:    "create_interval_kline_tables",
+		Up:      intervalTables([]string{"1m", "5m", "15m", "1h", "1d"}, true),
+		Down:    intervalTables([]string{"1m", "5m", "15m", "1h", "1d"}, false),
+	},
+	{
+		// MySQL分区键必须包含在主键中, 秒级K线表按time分区前需调整主键; sqlite无需变更
+		// 注: 回滚前需先取消分区: ALTER TABLE option_kline REMOVE PARTITIONING
+		Version: 4,
+		Name:    "option_kline_partition_key",
+		Up: map[string][]string{
+			DialectMySQL:  {"ALTER TABLE `option_kline` DROP PRIMARY KEY, ADD PRIMARY KEY (`id`, `time`)"},
+			DialectSQLite: {},
+		},
+		Down: map[string][]string{
+			DialectMySQL:  {"ALTER TABLE `option_kline` DROP PRIMARY KEY, ADD PRIMARY KEY (`id`)"},
+			DialectSQLite: {},
+		},
+	},
+	{
+		// 原始报价及来源: 推送的报价可能经过干预, 与行情源的报价对照
+		// sqlite回滚时重建表, 不依赖3.35才支持的DROP COLUMN
+		Version: 5,
+		Name:    "add_kline_provenance_columns",
+		Up:      provenanceColumns([]string{"", "1m", "5m", "15m", "1h", "1d"}, true),
+		Down:    provenanceColumns([]string{"", "1m", "5m", "15m", "1h", "1d"}, false),
+	},
+	{
+		// 秒级K线的哈希链, 旧数据为空
+		// sqlite回滚时重建表, 不依赖3.35才支持的DROP COLUMN
+		Version: 6,
+		Name:    "add_option_kline_hash_chain",
+		Up: map[string][]string{
+			DialectMySQL: {"ALTER TABLE `option_kline` ADD COLUMN `prevHash` CHAR(64) NOT NULL DEFAULT '', ADD COLUMN `hash` CHAR(64) NOT NULL DEFAULT ''"},
+			DialectSQLite: {
+				"ALTER TABLE option_kline ADD COLUMN prevHash CHAR(64) NOT NULL DEFAULT ''",
+				"ALTER TABLE option_kline ADD COLUMN hash CHAR(64) NOT NULL DEFAULT ''",
+			},
+		},
+		Down: map[string][]string{
+			DialectMySQL: {"ALTER TABLE `option_kline` DROP COLUMN `hash`, DROP COLUMN `prevHash`"},
+			DialectSQLite: sqliteRebuild("option_kline", append(sqliteColumns(sqliteKlineColumns), sqliteColumns(provenanceColumnList)...),
+				sqliteKlineIndexes),
+		},
+	},
+	{
+		// 每期开奖时刻的结算报价及行情源证据, 每个币种每期一条
+		Version: 7,
+		Name:    "create_settlement_price",
+		Up: map[string][]string{
+			DialectMySQL: {
+				"CREATE TABLE IF NOT EXISTS `settlement_price` (" +
+					"`id` BIGINT NOT NULL AUTO_INCREMENT, " +
+					"`coinType` VARCHAR(16) NOT NULL DEFAULT '', " +
+					"`issueTime` BIGINT NOT NULL DEFAULT 0, " +
+					"`price` VARCHAR(32) NOT NULL DEFAULT '', " +
+					"`rawPrice` VARCHAR(32) NOT NULL DEFAULT '', " +
+					"`source` VARCHAR(32) NOT NULL DEFAULT '', " +
+					"`tickIds` VARCHAR(255) NOT NULL DEFAULT '', " +
+					"`vendorTime` BIGINT NOT NULL DEFAULT 0, " +
+					"`origin` TINYINT UNSIGNED NOT NULL DEFAULT 0, " +
+					"`candleHash` CHAR(64) NOT NULL DEFAULT '', " +
+					"`createTime` BIGINT NOT NULL DEFAULT 0, " +
+					"PRIMARY KEY (`id`), " +
+					"UNIQUE KEY `uk_settlement_price_coin_issue_time` (`coinType`, `issueTime`)" +
+					") ENGINE=InnoDB DEFAULT CHARSET=utf8",
+			},
+			DialectSQLite: {
+				"CREATE TABLE IF NOT EXISTS settlement_price (" +
+					"id INTEGER PRIMARY KEY AUTOINCREMENT, " +
+					"coinType VARCHAR(16) NOT NULL DEFAULT '', " +
+					"issueTime BIGINT NOT NULL DEFAULT 0, " +
+					"price VARCHAR(32) NOT NULL DEFAULT '', " +
+					"rawPrice VARCHAR(32) NOT NULL DEFAULT '', " +
+					"source VARCHAR(32) NOT NULL DEFAULT '', " +
+					"tickIds VARCHAR(255) NOT NULL DEFAULT '', " +
+					"vendorTime BIGINT NOT NULL DEFAULT 0, " +
+					"origin TINYINT NOT NULL DEFAULT 0, " +
+					"candleHash CHAR(64) NOT NULL DEFAULT '', " +
+					"createTime BIGINT NOT NULL DEFAULT 0)",
+				"CREATE UNIQUE INDEX uk_settlement_price_coin_issue_time ON settlement_price (coinType, issueTime)",
+			},
+		},
+		Down: map[string][]string{
+			DialectMySQL:  {"DROP TABLE IF EXISTS `settlement_price`"},
+			DialectSQLite: {"DROP TABLE IF EXISTS settlement_price"},
+		},
+	},
+	{
+		// 动态配置变更记录, 只追加, 每条记录包含上一条记录的哈希
+		Version: 8,
+		Name:    "create_option_setting_log",
+		Up: map[string][]string{
+			DialectMySQL: {
+				"CREATE TABLE IF NOT EXISTS `option_setting_log` (" +
+					"`id` BIGINT NOT NULL AUTO_INCREMENT, " +
+					"`keyName` VARCHAR(64) NOT NULL DEFAULT '', " +
+					"`oldValue` VARCHAR(1024) NOT NULL DEFAULT '', " +
+					"`newValue` VARCHAR(1024) NOT NULL DEFAULT '', " +
+					"`updateTime` BIGINT NOT NULL DEFAULT 0, " +
+					"`appliedAt` BIGINT NOT NULL DEFAULT 0, " +
+					"`prevHash` CHAR(64) NOT NULL DEFAULT '', " +
+					"`hash` CHAR(64) NOT NULL DEFAULT '', " +
+					"PRIMARY KEY (`id`), " +
+					"KEY `idx_option_setting_log_key_name` (`keyName`)" +
+					") ENGINE=InnoDB DEFAULT CHARSET=utf8",
+			},
+			DialectSQLite: {
+				"CREATE TABLE IF NOT EXISTS option_setting_log (" +
+					"id INTEGER PRIMARY KEY AUTOINCREMENT, " +
+					"keyName VARCHAR(64) NOT NULL DEFAULT '', " +
+					"oldValue VARCHAR(1024) NOT NULL DEFAULT '', " +
+					"newValue VARCHAR(1024) NOT NULL DEFAULT '', " +
+					"updateTime BIGINT NOT NULL DEFAULT 0, " +
+					"appliedAt BIGINT NOT NULL DEFAULT 0, " +
+					"prevHash CHAR(64) NOT NULL DEFAULT '', " +
+					"hash CHAR(64) NOT NULL DEFAULT '')",
+				"CREATE INDEX idx_option_setting_log_key_name ON option_setting_log (keyName)",
+			},
+		},
+		Down: map[string][]string{
+			DialectMySQL:  {"DROP TABLE IF EXISTS `option_setting_log`"},
+			DialectSQLite: {"DROP TABLE IF EXISTS option_setting_log"},
+		},
+	},
+	{
+		// 每个币种每秒只有一条K线, 补录与实时写入并发时由数据库忽略重复写入
+		// 分区表的唯一索引必须包含分区键time; 已有重复K线时创建失败, 需先删除重复的K线
+		Version: 9,
+		Name:    "option_kline_unique_coin_time",
+		Up: map[string][]string{
+			DialectMySQL:  {"ALTER TABLE `option_kline` ADD UNIQUE INDEX `uk_option_kline_coin_time` (`coinType`, `time`)"},
+			DialectSQLite: {"CREATE UNIQUE INDEX uk_option_kline_coin_time ON option_kline (coinType, time)"},
+		},
+		Down: map[string][]string{
+			DialectMySQL:  {"ALTER TABLE `option_kline` DROP INDEX `uk_option_kline_coin_time`"},
+			DialectSQLite: {"DROP INDEX IF EXISTS uk_option_kline_coin_time"},
+		},
+	},
+}
+
+// sqlite秒级K线表版本1的列
+var sqliteKlineColumns = [][2]string{
+	{"id", "INTEGER PRIMARY KEY AUTOINCREMENT"},
+	{"coinType", "VARCHAR(16) NOT NULL DEFAULT ''"},
+	{"open", "VARCHAR(32) NOT NULL DEFAULT ''"},
+	{"close", "VARCHAR(32) NOT NULL DEFAULT ''"},
+	{"high", "VARCHAR(32) NOT NULL DEFAULT ''"},
+	{"low", "VARCHAR(32) NOT NULL DEFAULT ''"},
+	{"time", "BIGINT NOT NULL DEFAULT 0"},
+	{"lastupdate", "BIGINT NOT NULL DEFAULT 0"},
+	{"origin", "TINYINT NOT NULL DEFAULT 0"},
+}
+
+// sqlite秒级K线表版本2的索引
+var sqliteKlineIndexes = []string{"CREATE INDEX idx_option_kline_coin_time ON option_kline (coinType, time)"}
+
+// 周期K线表版本3的列
+var intervalColumns = [][2]string{
+	{"coinType", "VARCHAR(16) NOT NULL DEFAULT ''"},
+	{"open", "VARCHAR(32) NOT NULL DEFAULT ''"},
+	{"close", "VARCHAR(32) NOT NULL DEFAULT ''"},
+	{"high", "VARCHAR(32) NOT NULL DEFAULT ''"},
+	{"low", "VARCHAR(32) NOT NULL DEFAULT ''"},
+	{"time", "BIGINT NOT NULL DEFAULT 0"},
+	{"lastupdate", "BIGINT NOT NULL DEFAULT 0"},
+	{"origin", "TINYINT NOT NULL DEFAULT 0"},
+}
+
+// 版本5添加的原始报价及来源列
+var provenanceColumnList = [][2]string{
+	{"rawOpen", "VARCHAR(32) NOT NULL DEFAULT ''"},
+	{"rawClose", "VARCHAR(32) NOT NULL DEFAULT ''"},
+	{"rawHigh", "VARCHAR(32) NOT NULL DEFAULT ''"},
+	{"rawLow", "VARCHAR(32) NOT NULL DEFAULT ''"},
+	{"source", "VARCHAR(32) NOT NULL DEFAULT ''"},
+	{"vendorTime", "BIGINT NOT NULL DEFAULT 0"},
+}
+
+// 列定义: 列名 类型
+func sqliteColumns(columns [][2]string) []string {
+	defs := make([]string, 0, len(columns))
+	for _, col := range columns {
+		defs = append(defs, col[0]+" "+col[1])
+	}
+	return defs
+}
+
+// sqlite 3.35之前不支持DROP COLUMN(gorm 1.9.16依赖的go-sqlite3 v1.14.0为3.32),
+// 按删除列之后的表结构新建表, 复制保留的列, 删除旧表后改名并重建索引.
+// defs为列定义及表约束, 表约束(PRIMARY KEY等)之外的每项以列名开头
+func sqliteRebuild(table string, defs []string, indexes []string) []string {
+	names := []string{}
+	for _, def := range defs {
+		if !strings.HasPrefix(def, "PRIMARY KEY") {
+			names = append(names, strings.Fields(def)[0])
+		}
+	}
+	tmp := table + "_rebuild"
+	stmts := []string{
+		fmt.Sprintf("CREATE TABLE %s (%s)", tmp, strings.Join(defs, ", ")),
+		fmt.Sprintf("INSERT INTO %s (%s) SELECT %s FROM %s", tmp, strings.Join(names, ", "), strings.Join(names, ", "), table),
+		fmt.Sprintf("DROP TABLE %s", table),
+		fmt.Sprintf("ALTER TABLE %s RENAME TO %s", tmp, table),
+	}
+	return append(stmts, indexes...)
+}
+
+// K线表的原始报价及来源列, 周期为空表示秒级K线表option_kline
+func provenanceColumns(intervals []string, add bool) map[string][]string {
+	columns := provenanceColumnList
+	stmts := map[string][]string{}
+	for _, interval := range intervals {
+		table := "option_kline"
+		if interval != "" {
+			table += "_" + interval
+		}
+		// MySQL一条语句修改所有列, 避免多次重建表; sqlite每条语句只能添加一列, 删除列时重建表
+		alters := []string{}
+		for _, col := range columns {
+			if add {
+				alters = append(alters, fmt.Sprintf("ADD COLUMN `%s` %s", col[0], col[1]))
+				stmts[DialectSQLite] = append(stmts[DialectSQLite], fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s", table, col[0], col[1]))
+			} else {
+				alters = append(alters, fmt.Sprintf("DROP COLUMN `%s`", col[0]))
+			}
+		}
+		if !add && interval == "" {
+			stmts[DialectSQLite] = append(stmts[DialectSQLite], sqliteRebuild(table, sqliteColumns(sqliteKlineColumns), sqliteKlineIndexes)...)
+		} else if !add {
+			defs := append(sqliteColumns(intervalColumns), "PRIMARY KEY (coinType, time)")
+			stmts[DialectSQLite] = append(stmts[DialectSQLite], sqliteRebuild(table, defs, nil)...)
+		}
+		stmts[DialectMySQL] = append(stmts[DialectMySQL], fmt.Sprintf("ALTER TABLE `%s` %s", table, strings.Join(alters, ", ")))
+	}
+	return stmts
+}
+
+// 周期K线表的建表及删表语句
+func intervalTables(intervals []string, create bool) map[string][]string {
+	stmts := map[string][]string{}
+	for _, interval := range intervals {
+		table := "option_kline_" + interval
+		if !create {
+			stmts[DialectMySQL] = append(stmts[DialectMySQL], fmt.Sprintf("DROP TABLE IF EXISTS `%s`", table))
+			stmts[DialectSQLite] = append(stmts[DialectSQLite], fmt.Sprintf("DROP TABLE IF EXISTS %s", table))
+			continue
+		}
+		columns := []string{}
+		for _, col := range intervalColumns {
+			columns = append(columns, fmt.Sprintf("`%s` %s", col[0], col[1]))
+		}
+		columns = append(columns, "PRIMARY KEY (`coinType`, `time`)")
+		stmts[DialectMySQL] = append(stmts[DialectMySQL], fmt.Sprintf(
+			"CREATE TABLE IF NOT EXISTS `%s` (%s) ENGINE=InnoDB DEFAULT CHARSET=utf8", table, strings.Join(columns, ", ")))
+		stmts[DialectSQLite] = append(stmts[DialectSQLite], fmt.Sprintf(
+			"CREATE TABLE IF NOT EXISTS %s (%s)", table, strings.Replace(strings.Join(columns, ", "), "`", "", -1)))
+	}
+	return stmts
+}
@@ -0,0 +1,195 @@
+package monitor
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"math"
+	"net/http"
+	"option-kline/common"
+	"option-kline/kline"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	maxTicks     = 256             // 每个币种保留的行情数量上限
+	alertTimeout = 3 * time.Second // 告警请求超时
+)
+
+var (
+	monitorMetrics = common.NewMetrics("monitor")
+)
+
+// 偏差监控配置
+type Config struct {
+	Tolerance     float64            // 默认允许的偏差, 单位:%
+	ToleranceMap  map[string]float64 // 按币种指定的允许偏差
+	Block         bool               // 偏差超出时不推送该K线
+	Window        time.Duration      // 与最近Window内收到的行情比较
+	AlertURL      string             // 告警地址, 以POST JSON方式通知, 为空时只记录日志
+	AlertInterval time.Duration      // 每个币种的告警间隔
+}
+
+// 根据已加载的配置生成偏差监控配置
+func NewConfig() Config {
+	return Config{
+		Tolerance:     common.MonitorTolerance,
+		ToleranceMap:  common.MonitorToleranceMap,
+		Block:         common.MonitorBlock,
+		Window:        time.Duration(common.MonitorWindow) * time.Second,
+		AlertURL:      common.MonitorAlertURL,
+		AlertInterval: time.Duration(common.MonitorAlertInterval) * time.Second,
+	}
+}
+
+func (c Config) tolerance(coinType string) float64 {
+	if tolerance, ok := c.ToleranceMap[coinType]; ok {
+		return tolerance
+	}
+	return c.Tolerance
+}
+
+// 偏差告警
+type Alert struct {
+	CoinType  string  `json:"coinType"`
+	Time      int64   `json:"time"`      // K线时间
+	Price     string  `json:"price"`     // 推送的报价(开高低收中偏差最大的价格)
+	Source    float64 `json:"source"`    // 最接近的行情源报价
+	Deviation float64 `json:"deviation"` // 偏差, 单位:%
+	Tolerance float64 `json:"tolerance"` // 允许的偏差, 单位:%
+	Blocked   bool    `json:"blocked"`   // 是否已阻止推送
+}
+
+func (a Alert) String() string {
+	return fmt.Sprintf("%s at %d: published %s, source %v, deviation %.4f%% > %.4f%%, blocked: %v",
+		a.CoinType, a.Time, a.Price, a.Source, a.Deviation, a.Tolerance, a.Blocked)
+}
+
+type tickPrice struct {
+	price float64
+	at    time.Time // 收到行情的时间
+}
+
+// 价格偏差监控: 独立记录行情源的报价, 检查每条待推送的K线与行情源报价的偏差.
+// 不使用K线中的原始报价, 处理过程中任何对报价的修改都会被发现
+type Monitor struct {
+	conf      Config
+	clock     common.Clock
+	mutex     sync.Mutex
+	ticks     map[string][]tickPrice // 各币种最近收到的行情, 按收到的时间排列
+	lastAlert map[string]time.Time
+	notify    func(alert Alert) // 发送告警, 测试时可替换
+}
+
+func New(conf Config, clock common.Clock) *Monitor {
+	m := &Monitor{
+		conf:      conf,
+		clock:     clock,
+		ticks:     make(map[string][]tickPrice, 3),
+		lastAlert: make(map[string]time.Time, 3),
+	}
+	m.notify = m.postAlert
+	return m
+}
+
+// 记录行情源的报价, 在行情数据进入处理协程之前调用
+func (m *Monitor) ObserveTick(k *kline.OptionKline) {
+	price, err := strconv.ParseFloat(k.Close, 64)
+	if err != nil {
+		return
+	}
+	now := m.clock.Now()
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	ticks := append(m.ticks[k.CoinType], tickPrice{price: price, at: now})
+	// 删除窗口之外的行情, 至少保留最新的一条
+	idx := 0
+	for idx < len(ticks)-1 && (now.Sub(ticks[idx].at) > m.conf.Window || len(ticks)-idx > maxTicks) {
+		idx++
+	}
+	m.ticks[k.CoinType] = append(ticks[:0], ticks[idx:]...)
+}
+
+// 与最接近的行情源报价的偏差(%), 无行情时返回false
+func (m *Monitor) deviation(coinType string, price float64) (dev, source float64, ok bool) {
+	dev = math.MaxFloat64
+	for _, tick := range m.ticks[coinType] {
+		if tick.price <= 0 {
+			continue
+		}
+		if d := math.Abs(price-tick.price) / tick.price * 100; d < dev {
+			dev, source, ok = d, tick.price, true
+		}
+	}
+	return
+}
+
+// 检查待推送的K线, 返回是否允许推送
+func (m *Monitor) Check(k *kline.OptionKline) bool {
+	fn := "Monitor.Check"
+	monitorMetrics.Add(k.CoinType+".checked", 1)
+	tolerance := m.conf.tolerance(k.CoinType)
+	m.mutex.Lock()
+	var alert *Alert
+	// 开高低收任一价格偏差超出都会告警
+	for _, str := range []string{k.Open, k.High, k.Low, k.Close} {
+		price, err := strconv.ParseFloat(str, 64)
+		if err != nil {
+			continue
+		}
+		dev, source, ok := m.deviation(k.CoinType, price)
+		if !ok {
+			monitorMetrics.Add(k.CoinType+".no_source", 1)
+			break
+		}
+		common.SetMetric(monitorMetrics, k.CoinType+".deviation_ppm", int64(dev*1e4))
+		if dev > tolerance && (alert == nil || dev > alert.Deviation) {
+			alert = &Alert{CoinType: k.CoinType, Time: k.Time, Price: str, Source: source, Deviation: dev, Tolerance: tolerance}
+		}
+	}
+	if alert == nil {
+		m.mutex.Unlock()
+		return true
+	}
+	alert.Blocked = m.conf.Block
+	now := m.clock.Now()
+	notify := now.Sub(m.lastAlert[k.CoinType]) >= m.conf.AlertInterval
+	if notify {
+		m.lastAlert[k.CoinType] = now
+	}
+	m.mutex.Unlock()
+
+	monitorMetrics.Add(k.CoinType+".deviations", 1)
+	if alert.Blocked {
+		monitorMetrics.Add(k.CoinType+".blocked", 1)
+	}
+	log.Errorf("[%s]price deviation: %s", fn, alert)
+	if notify {
+		m.notify(*alert)
+	}
+	return !alert.Blocked
+}
+
+// 发送告警到配置的地址
+func (m *Monitor) postAlert(alert Alert) {
+	if m.conf.AlertURL == "" {
+		return
+	}
+	go func() {
+		fn := "Monitor.postAlert"
+		data, _ := json.Marshal(alert)
+		client := &http.Client{Timeout: alertTimeout}
+		res, err := client.Post(m.conf.AlertURL, "application/json", bytes.NewReader(data))
+		if err != nil {
+			log.Errorf("[%s]failed to send alert: %s", fn, err)
+			return
+		}
+		res.Body.Close()
+		if res.StatusCode >= 300 {
+			log.Errorf("[%s]failed to send alert, status: %s", fn, res.Status)
+		}
+	}()
+}
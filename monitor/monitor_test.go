@@ -0,0 +1,65 @@
+package monitor
+
+import (
+	"option-kline/common"
+	"option-kline/kline"
+	"testing"
+	"time"
+)
+
+func newKline(price string) *kline.OptionKline {
+	return &kline.OptionKline{CoinType: "GT", Open: price, High: price, Low: price, Close: price}
+}
+
+func TestMonitorCheck(t *testing.T) {
+	clock := common.NewFakeClock(time.Unix(1000, 0))
+	m := New(Config{Tolerance: 0.1, ToleranceMap: map[string]float64{"USDT": 1}, Block: true,
+		Window: 10 * time.Second, AlertInterval: time.Minute}, clock)
+	alerts := []Alert{}
+	m.notify = func(alert Alert) { alerts = append(alerts, alert) }
+
+	// 无行情时不阻止
+	if !m.Check(newKline("100")) {
+		t.Fatal("kline without source tick should be allowed")
+	}
+	m.ObserveTick(newKline("100"))
+	clock.Advance(time.Second)
+	m.ObserveTick(newKline("101"))
+	// 与窗口内任一行情一致即可
+	if !m.Check(newKline("100")) || !m.Check(newKline("101.1")) {
+		t.Fatal("kline within tolerance should be allowed")
+	}
+	if m.Check(newKline("102")) || m.Check(newKline("99")) {
+		t.Fatal("deviating kline should be blocked")
+	}
+	if len(alerts) != 1 || alerts[0].Source != 101 || !alerts[0].Blocked {
+		t.Fatalf("alerts should be throttled: %+v", alerts)
+	}
+
+	// 窗口之外的行情不再作为参考, 但保留最新的一条
+	clock.Advance(time.Minute)
+	m.ObserveTick(newKline("110"))
+	if m.Check(newKline("100")) || !m.Check(newKline("110")) {
+		t.Fatal("ticks out of window should be dropped")
+	}
+	if len(alerts) != 2 {
+		t.Fatalf("alert should be sent after interval: %+v", alerts)
+	}
+}
+
+// 只有最高价偏差超出时同样阻止推送
+func TestMonitorCheckHigh(t *testing.T) {
+	m := New(Config{Tolerance: 0.1, Block: true, Window: 10 * time.Second}, common.NewFakeClock(time.Unix(1000, 0)))
+	alerts := []Alert{}
+	m.notify = func(alert Alert) { alerts = append(alerts, alert) }
+	m.ObserveTick(newKline("100"))
+
+	k := newKline("100")
+	k.High = "105"
+	if m.Check(k) {
+		t.Fatal("kline with deviating high should be blocked")
+	}
+	if len(alerts) != 1 || alerts[0].Price != "105" {
+		t.Fatalf("alert should report the deviating high: %+v", alerts)
+	}
+}
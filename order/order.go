@@ -0,0 +1,56 @@
+package order
+
+import (
+	"github.com/jinzhu/gorm"
+)
+
+// 订单数据只用于结算核对等离线功能, K线处理、保存及推送不能依赖订单数据
+
+const TableName = "option_order"
+
+// db table: option_order
+type OptionOrder struct {
+	Id          int64  `gorm:"column:id" json:"id"`
+	UserId      string `gorm:"column:userId" json:"userId"`
+	AgentId     string `gorm:"column:agentId" json:"agentId"`
+	TokenType   string `gorm:"column:tokenType" json:"tokenType"`
+	CoinType    string `gorm:"column:coinType" json:"coinType"`
+	Type        string `gorm:"column:type" json:"type"` // 1:买涨 2:买跌
+	Amount      string `gorm:"column:amount" json:"amount"`
+	AgentAmount string `gorm:"column:agentAmount" json:"agentAmount"`
+	IssueNumber string `gorm:"column:issueNumber" json:"issueNumber"`
+	Status      int    `gorm:"column:status" json:"status"` // 订单状态，1：已开奖，0：未开奖
+	OpenTime    int64  `gorm:"column:openTime" json:"openTime"`
+	Result      int    `gorm:"column:result" json:"result"` // 1:赢， 2：输， 0：平
+	CreateTime  int64  `gorm:"column:createTime" json:"createTime"`
+	UpdateTime  int64  `gorm:"column:updateTime" json:"updateTime"`
+	OpenPrice   string `gorm:"column:openPrice" json:"openPrice"`
+	ClosePrice  string `gorm:"column:closePrice" json:"closePrice"`
+	Profit      string `gorm:"column:profit" json:"profit"`
+	Fee         string `gorm:"column:fee" json:"fee"`
+	Revenue     string `gorm:"column:revenue" json:"revenue"`
+}
+
+const (
+	PriceUp   = "1"
+	PriceDown = "2"
+	PriceDraw = "3"
+
+	// 用户下注结果
+	OptionResultWin  = 1
+	OptionResultLose = 2
+	OptionResultDraw = 3
+
+	// 期权订单状态
+	OptionOrderOpened    = 1
+	OptionOrderNotOpened = 0
+)
+
+// 查询开奖时间在[begin, end)内的订单, 按开奖时间及id排列
+func List(db *gorm.DB, coinType string, begin, end int64) (orderList []*OptionOrder, err error) {
+	if err = db.Table(TableName).Where("coinType = ? AND openTime >= ? AND openTime < ?", coinType, begin, end).
+		Order("openTime, id").Find(&orderList).Error; err != nil {
+		return nil, err
+	}
+	return
+}
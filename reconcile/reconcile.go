@@ -0,0 +1,163 @@
+package reconcile
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"option-kline/common"
+	"option-kline/kline"
+	"option-kline/store"
+	"strconv"
+	"strings"
+)
+
+const (
+	scanRows = 10000     // 每次查询的秒级K线数量上限
+	maxRange = 7 * 86400 // 接口单次查询的最大时长, 单位:秒
+)
+
+// 一个周期内推送报价与行情源原始报价的偏差, 由秒级K线计算
+type Deviation struct {
+	CoinType string  `json:"coinType"`
+	Time     int64   `json:"time"`     // 周期开始时间
+	Rows     int     `json:"rows"`     // 有原始报价的K线数量
+	NoRaw    int     `json:"noRaw"`    // 无原始报价的K线数量(旧数据)
+	Adjusted int     `json:"adjusted"` // 推送报价与原始报价不一致的K线数量
+	MaxAbs   float64 `json:"maxAbs"`   // 最大绝对偏差
+	MeanAbs  float64 `json:"meanAbs"`  // 平均绝对偏差
+	MaxPct   float64 `json:"maxPct"`   // 最大相对偏差, 单位:%
+}
+
+// 单条K线的偏差: 开高低收中与原始报价差值的最大值
+func deviation(k *kline.OptionKline) (abs, pct float64, err error) {
+	pairs := [][2]string{{k.Open, k.RawOpen}, {k.High, k.RawHigh}, {k.Low, k.RawLow}, {k.Close, k.RawClose}}
+	for _, pair := range pairs {
+		published, err := strconv.ParseFloat(pair[0], 64)
+		if err != nil {
+			return 0, 0, fmt.Errorf("invalid price %q of %s at %d", pair[0], k.CoinType, k.Time)
+		}
+		raw, err := strconv.ParseFloat(pair[1], 64)
+		if err != nil {
+			return 0, 0, fmt.Errorf("invalid raw price %q of %s at %d", pair[1], k.CoinType, k.Time)
+		}
+		diff := math.Abs(published - raw)
+		if diff > abs {
+			abs = diff
+		}
+		if raw != 0 && diff/raw*100 > pct {
+			pct = diff / raw * 100
+		}
+	}
+	return abs, pct, nil
+}
+
+// 按period统计[begin, end)内推送报价与原始报价的偏差, 按时间升序排列, 无K线的周期不返回
+func Run(s store.CandleStore, coinType string, period kline.Interval, begin, end int64) ([]Deviation, error) {
+	devs := []Deviation{}
+	var cur *Deviation
+	var total float64
+	finish := func() {
+		if cur != nil && cur.Rows > 0 {
+			cur.MeanAbs = total / float64(cur.Rows)
+		}
+	}
+	window := int64(scanRows) * kline.Interval1s.Seconds
+	for from := begin; from < end; from += window {
+		to := from + window
+		if to > end {
+			to = end
+		}
+		klineList, err := s.Range(kline.Interval1s, coinType, from, to)
+		if err != nil {
+			return nil, err
+		}
+		for _, k := range klineList {
+			t := period.Begin(k.Time)
+			if cur == nil || cur.Time != t {
+				finish()
+				devs = append(devs, Deviation{CoinType: coinType, Time: t})
+				cur, total = &devs[len(devs)-1], 0
+			}
+			if k.RawOpen == "" {
+				cur.NoRaw++
+				continue
+			}
+			abs, pct, err := deviation(k)
+			if err != nil {
+				return nil, err
+			}
+			cur.Rows++
+			total += abs
+			if abs > 0 {
+				cur.Adjusted++
+			}
+			cur.MaxAbs = math.Max(cur.MaxAbs, abs)
+			cur.MaxPct = math.Max(cur.MaxPct, pct)
+		}
+	}
+	finish()
+	return devs, nil
+}
+
+// 对账接口:
+// GET {ReconcilePath}?coin=GT,USDT&period=1m&from=时间&to=时间
+// period默认1m, 时间为unix秒或RFC3339, 单次最多查询7天
+type Handler struct {
+	store     store.CandleStore
+	coinTypes []string
+}
+
+func NewHandler(s store.CandleStore, coinTypes []string) *Handler {
+	return &Handler{store: s, coinTypes: coinTypes}
+}
+
+// 对账结果
+type Result struct {
+	Period     string      `json:"period"`
+	Begin      int64       `json:"begin"`
+	End        int64       `json:"end"`
+	Deviations []Deviation `json:"deviations"`
+}
+
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	coinTypes := h.coinTypes
+	if val := query.Get("coin"); val != "" {
+		coinTypes = strings.Split(strings.Replace(val, " ", "", -1), ",")
+	}
+	period := kline.Interval1m
+	var err error
+	if val := query.Get("period"); val != "" {
+		if period, err = kline.ParseInterval(val); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+	if query.Get("from") == "" || query.Get("to") == "" {
+		http.Error(w, "from and to are required", http.StatusBadRequest)
+		return
+	}
+	result := Result{Period: period.Name, Deviations: []Deviation{}}
+	if result.Begin, err = common.ParseTime(query.Get("from")); err == nil {
+		result.End, err = common.ParseTime(query.Get("to"))
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if result.Begin >= result.End || result.End-result.Begin > maxRange {
+		http.Error(w, fmt.Sprintf("invalid time range: [%d, %d), at most %d seconds", result.Begin, result.End, maxRange), http.StatusBadRequest)
+		return
+	}
+	for _, coinType := range coinTypes {
+		devs, err := Run(h.store, coinType, period, result.Begin, result.End)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		result.Deviations = append(result.Deviations, devs...)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(result)
+}
@@ -0,0 +1,55 @@
+package reconcile
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"option-kline/kline"
+	"option-kline/store"
+	"testing"
+)
+
+func newCandle(tm int64, published, raw string) *kline.OptionKline {
+	return &kline.OptionKline{CoinType: "GT", Open: published, High: published, Low: published, Close: published,
+		RawOpen: raw, RawHigh: raw, RawLow: raw, RawClose: raw, Time: tm, Source: kline.SourceForex, VendorTime: tm}
+}
+
+func TestReconcile(t *testing.T) {
+	s := store.NewMemoryStore()
+	s.Append(kline.Interval1s,
+		newCandle(60, "100", "100"),
+		newCandle(61, "101", "100"),
+		newCandle(62, "102", "100"),
+		newCandle(120, "100", ""), // 旧数据无原始报价
+		newCandle(180, "100", "100"),
+	)
+
+	h := NewHandler(s, []string{"GT"})
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", "/api/kline/reconcile?period=1m&from=60&to=240", nil))
+	if w.Code != 200 {
+		t.Fatalf("unexpected status %d: %s", w.Code, w.Body.String())
+	}
+	result := Result{}
+	if err := json.Unmarshal(w.Body.Bytes(), &result); err != nil {
+		t.Fatal(err)
+	}
+	devs := result.Deviations
+	if len(devs) != 3 {
+		t.Fatalf("unexpected deviations: %+v", devs)
+	}
+	if d := devs[0]; d.Time != 60 || d.Rows != 3 || d.Adjusted != 2 || d.MaxAbs != 2 || d.MeanAbs != 1 || d.MaxPct != 2 {
+		t.Fatalf("unexpected deviation: %+v", d)
+	}
+	if d := devs[1]; d.Time != 120 || d.Rows != 0 || d.NoRaw != 1 {
+		t.Fatalf("unexpected deviation: %+v", d)
+	}
+	if d := devs[2]; d.Time != 180 || d.Rows != 1 || d.Adjusted != 0 || d.MaxAbs != 0 {
+		t.Fatalf("unexpected deviation: %+v", d)
+	}
+
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", "/api/kline/reconcile?from=0&to=999999999", nil))
+	if w.Code != 400 {
+		t.Fatalf("range over 7 days should be rejected, status: %d", w.Code)
+	}
+}
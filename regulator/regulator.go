@@ -33,10 +33,7 @@ const (
 )
 
 var (
-	selectTop    = common.SelectRange  // 当前大盘最高点
-	selectBottom = -common.SelectRange // 当前大盘最低点
-	selectStep   = common.SelectStep   // 大盘浮动值
-	StateString  = map[int]string{
+	StateString = map[int]string{
 		stateNormal: "stateNormal",
 		stateDrop:   "stateDrop",
 		stateRise:   "stateRise",
@@ -48,6 +45,9 @@ var (
 type Section struct {
 	Top               int
 	Bottom            int
+	selectTop         int // 初始大盘最高点
+	selectBottom      int // 初始大盘最低点
+	selectStep        int // 大盘浮动值
 	StandLine         int
 	state             int
 	trim              int // 微调因子，再取随机数的时候，Top会减去该值，Bottom会加上该值
@@ -58,32 +58,33 @@ type Section struct {
 	touchBoundary     bool
 	sectionUpdateTime time.Time // 大盘边界扩张/收缩的时间点
 	randomNum         *rand.Rand
+	clock             common.Clock
 }
 
 // 当标准线触碰到顶/底线后，强制扩张大盘边界，防止用户盯着一条底线
 func (s *Section) expend() {
-	s.Top += selectStep
-	s.Bottom -= selectStep
-	s.sectionUpdateTime = time.Now()
+	s.Top += s.selectStep
+	s.Bottom -= s.selectStep
+	s.sectionUpdateTime = s.clock.Now()
 }
 
 // 尝试收缩边界
 func (s *Section) tryShrink() {
 	// 在初始大盘，不可再收缩
-	if s.Top == selectTop || s.Bottom == selectBottom {
+	if s.Top == s.selectTop || s.Bottom == s.selectBottom {
 		return
 	}
 
 	// 如果收缩以后，标准线发生了溢出，则不允许收缩
-	if s.StandLine >= s.Top-selectStep || s.StandLine <= s.Bottom+selectStep {
+	if s.StandLine >= s.Top-s.selectStep || s.StandLine <= s.Bottom+s.selectStep {
 		return
 	}
 
 	// 离上次扩张已经过去了10分钟，收缩一下大盘
-	if time.Now().After(s.sectionUpdateTime.Add(time.Minute * 10)) {
-		s.Top -= selectStep
-		s.Bottom += selectStep
-		s.sectionUpdateTime = time.Now()
+	if now := s.clock.Now(); now.After(s.sectionUpdateTime.Add(time.Minute * 10)) {
+		s.Top -= s.selectStep
+		s.Bottom += s.selectStep
+		s.sectionUpdateTime = now
 	}
 }
 
@@ -225,21 +226,36 @@ type Trim struct {
 	weight int
 }
 
-func NewRegulator(name string, trims []*Trim) *Regulator {
+// 创建报价调节器, 大盘边界使用当前的select_range/select_step配置; 需调用Start后才能调整报价
+func NewRegulator(name string, trims []*Trim, clock common.Clock) *Regulator {
 	return &Regulator{
 		section: &Section{
-			Top:       selectTop,
-			Bottom:    selectBottom,
-			state:     stateNormal,
-			trims:     trims,
-			name:      name,
-			randomNum: rand.New(rand.NewSource(time.Now().UnixNano())),
+			Top:          common.SelectRange,
+			Bottom:       -common.SelectRange,
+			selectTop:    common.SelectRange,
+			selectBottom: -common.SelectRange,
+			selectStep:   common.SelectStep,
+			state:        stateNormal,
+			trims:        trims,
+			name:         name,
+			randomNum:    rand.New(rand.NewSource(time.Now().UnixNano())),
+			clock:        clock,
 		},
 		request:  make(chan *AdJustRequest),
 		response: make(chan float64),
 	}
 }
 
+// 启动报价调节协程
+func (r *Regulator) Start() {
+	go r.workLoop()
+}
+
+// 停止报价调节协程, 停止后不可再调用AdjustPrice
+func (r *Regulator) Stop() {
+	close(r.request)
+}
+
 func (r *Regulator) AdjustPrice(req *AdJustRequest) float64 {
 	r.request <- req
 	return <-r.response
@@ -299,17 +315,20 @@ func (r *Regulator) workLoop() {
 	}
 }
 
-var (
-	RegulatorMap = make(map[string]*Regulator)
-)
+// 币种的默认微调因子
+func DefaultTrims(coinType string) []*Trim {
+	if coinType == "USDT" {
+		return []*Trim{{2, 10}, {5, 20}, {10, 8}}
+	}
+	return []*Trim{{10, 10}, {4, 6}, {8, 8}}
+}
 
-func init() {
-	for _, coinType := range common.CoinSupported.Load().([]string) {
-		trims := []*Trim{{10, 10}, {4, 6}, {8, 8}}
-		if coinType == "USDT" {
-			trims = []*Trim{{2, 10}, {5, 20}, {10, 8}}
-		}
-		RegulatorMap[coinType] = NewRegulator(coinType, trims)
-		go RegulatorMap[coinType].workLoop()
+// 为各币种创建并启动报价调节器
+func NewRegulatorMap(coinTypes []string, clock common.Clock) map[string]*Regulator {
+	regulatorMap := make(map[string]*Regulator, len(coinTypes))
+	for _, coinType := range coinTypes {
+		regulatorMap[coinType] = NewRegulator(coinType, DefaultTrims(coinType), clock)
+		regulatorMap[coinType].Start()
 	}
+	return regulatorMap
 }
@@ -4,13 +4,16 @@ import (
 	"bytes"
 	"fmt"
 	"io/ioutil"
+	"option-kline/common"
 	"testing"
 )
 
 func TestKline(t *testing.T) {
+	reg := NewRegulatorMap([]string{"GT"}, common.RealClock)["GT"]
+	defer reg.Stop()
 	ret := make([]float64, 0)
 	for i := 0; i < 1000; i++ {
-		ret = append(ret, RegulatorMap["GT"].AdjustPrice(&AdJustRequest{
+		ret = append(ret, reg.AdjustPrice(&AdJustRequest{
 			Price:     100.00,
 			Precision: 4,
 		}))
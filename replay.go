@@ -0,0 +1,89 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"github.com/jinzhu/gorm"
+	"option-kline/common"
+	"option-kline/replay"
+	"option-kline/settlement"
+	"option-kline/store"
+	"os"
+	"strconv"
+	"time"
+)
+
+// 按行情源原始报价重新计算结算报价并对照订单: option-kline replay -coin 币种 -date 日期 [选项]
+// 只读取数据, 发现差异时返回错误, 进程以非0状态退出
+func runReplay(args []string) error {
+	fs := flag.NewFlagSet("replay", flag.ContinueOnError)
+	coin := fs.String("coin", "", "coin type")
+	date := fs.String("date", "", "date, YYYY-MM-DD")
+	tz := fs.String("tz", "Local", "time zone of the date, e.g. Asia/Shanghai")
+	period := fs.String("period", strconv.FormatInt(common.SettlementPeriod, 10)+"s", "issue period (s/m/h/d)")
+	format := fs.String("format", "json", "report format: json, csv")
+	output := fs.String("o", "", "output file, default: stdout")
+	checkpointDir := fs.String("checkpoints", common.ChainCheckpointDir, "checkpoint dir to verify the hash chain against, empty to skip checkpoints")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *coin == "" || *date == "" {
+		fs.Usage()
+		return fmt.Errorf("usage: option-kline replay -coin coin -date YYYY-MM-DD [options]")
+	}
+	if *format != "json" && *format != "csv" {
+		return fmt.Errorf("format not supported: %s", *format)
+	}
+	loc, err := time.LoadLocation(*tz)
+	if err != nil {
+		return err
+	}
+	day, err := time.ParseInLocation("2006-01-02", *date, loc)
+	if err != nil {
+		return fmt.Errorf("invalid date %s, expected YYYY-MM-DD", *date)
+	}
+	seconds, err := common.ParseSeconds(*period)
+	if err != nil || seconds <= 0 {
+		return fmt.Errorf("invalid period: %s", *period)
+	}
+
+	s, closeStore, err := openReadOnlyStore()
+	if err != nil {
+		return err
+	}
+	defer closeStore()
+	// 订单与K线在同一数据库中, 内存存储无订单数据
+	var db *gorm.DB
+	if sqlStore, ok := s.(*store.SQLStore); ok {
+		db = sqlStore.DB()
+	}
+	opts := replay.DayOptions(*coin, day, seconds, loc)
+	if opts.Checkpoints, opts.PublicKey, err = loadCheckpoints(*checkpointDir); err != nil {
+		return err
+	}
+	report, err := replay.Run(s, settlement.NewStoreFor(s), db, opts)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintln(os.Stderr, "limit:", report.Limit)
+	out := os.Stdout
+	if *output != "" {
+		if out, err = os.Create(*output); err != nil {
+			return err
+		}
+		defer out.Close()
+	}
+	if *format == "csv" {
+		err = report.WriteCSV(out)
+	} else {
+		err = report.WriteJSON(out)
+	}
+	if err != nil {
+		return err
+	}
+	if n := len(report.Discrepancies); n > 0 {
+		return fmt.Errorf("%d discrepancies found in %d issues and %d orders", n, report.Issues, report.Orders)
+	}
+	fmt.Fprintf(os.Stderr, "0 discrepancies found in %d issues and %d orders\n", report.Issues, report.Orders)
+	return nil
+}
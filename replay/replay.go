@@ -0,0 +1,295 @@
+package replay
+
+import (
+	"crypto/ed25519"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"github.com/jinzhu/gorm"
+	"io"
+	"option-kline/chain"
+	"option-kline/common"
+	"option-kline/kline"
+	"option-kline/order"
+	"option-kline/settlement"
+	"option-kline/store"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// 差异类型
+const (
+	DiffNoSource        = "no_source"         // 开奖时刻之前无行情源原始报价, 无法重新计算
+	DiffKlineMissing    = "kline_missing"     // 开奖时刻无秒级K线
+	DiffKlinePrice      = "kline_price"       // 开奖时刻K线的开盘价与重新计算的结算报价不一致
+	DiffSettlementPrice = "settlement_price"  // settlement_price记录的结算报价不一致
+	DiffOrderOpenPrice  = "order_open_price"  // 订单的下单报价与下单时刻的原始报价不一致
+	DiffOrderClosePrice = "order_close_price" // 订单的开奖报价与重新计算的结算报价不一致
+	DiffOrderResult     = "order_result"      // 已开奖订单的输赢与按原始报价计算的结果不一致
+	DiffChain           = "chain"             // 哈希链检查发现问题, 该K线的原始报价不再使用
+)
+
+const (
+	scanRows = 10000 // 每次查询的秒级K线数量上限
+	lookback = 3600  // 向前查找行情的时长, 第一期的结算报价可能来自前一天的行情, 单位:秒
+)
+
+// 重新计算的配置
+type Options struct {
+	CoinType string
+	Begin    int64 // 重新计算开奖时间在[Begin, End)内的每一期
+	End      int64
+	Period   int64 // 每期时长, 单位:秒
+
+	Checkpoints []chain.Checkpoint // 核对哈希链的检查点, 为空时无法发现整条链被重写
+	PublicKey   ed25519.PublicKey  // 检查点签名的公钥, 为nil时不检查签名
+}
+
+// 某一日期的配置: 按loc中的自然日
+func DayOptions(coinType string, date time.Time, period int64, loc *time.Location) Options {
+	begin := time.Date(date.Year(), date.Month(), date.Day(), 0, 0, 0, 0, loc)
+	return Options{CoinType: coinType, Begin: begin.Unix(), End: begin.AddDate(0, 0, 1).Unix(), Period: period}
+}
+
+// 一处差异
+type Discrepancy struct {
+	Kind      string `json:"kind"`
+	IssueTime int64  `json:"issueTime"`
+	OrderId   int64  `json:"orderId,omitempty"`
+	Expected  string `json:"expected"` // 按原始报价重新计算的值
+	Actual    string `json:"actual"`   // 记录的值
+	Detail    string `json:"detail,omitempty"`
+}
+
+// 重新计算报告
+type Report struct {
+	CoinType      string         `json:"coinType"`
+	Begin         int64          `json:"begin"`
+	End           int64          `json:"end"`
+	Period        int64          `json:"period"`
+	Issues        int            `json:"issues"`      // 期数
+	Ticks         int            `json:"ticks"`       // 使用的原始行情数量
+	Orders        int            `json:"orders"`      // 检查的订单数量
+	Settlements   int            `json:"settlements"` // 检查的结算报价记录数量
+	Unchained     int            `json:"unchained"`   // 使用的原始行情中无哈希的数量
+	Chain         *chain.Summary `json:"chain"`       // 读取范围内的哈希链检查结果
+	Limit         string         `json:"limit"`       // 重新计算的局限
+	Discrepancies []Discrepancy  `json:"discrepancies"`
+}
+
+func (r *Report) add(d Discrepancy) {
+	r.Discrepancies = append(r.Discrepancies, d)
+}
+
+func (r *Report) WriteJSON(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(r)
+}
+
+func (r *Report) WriteCSV(w io.Writer) error {
+	cw := csv.NewWriter(w)
+	cw.Write([]string{"kind", "coinType", "issueTime", "orderId", "expected", "actual", "detail"})
+	for _, d := range r.Discrepancies {
+		cw.Write([]string{d.Kind, r.CoinType, strconv.FormatInt(d.IssueTime, 10), strconv.FormatInt(d.OrderId, 10),
+			d.Expected, d.Actual, d.Detail})
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// 行情源的一条原始报价
+type tick struct {
+	time       int64 // 所在秒
+	price      string
+	vendorTime int64
+}
+
+// 按时间升序排列的原始报价
+type tickList []tick
+
+// t时刻(含)之前最新的原始报价
+func (l tickList) at(t int64) (tick, bool) {
+	idx := sort.Search(len(l), func(i int) bool { return l[i].time > t })
+	if idx == 0 {
+		return tick{}, false
+	}
+	return l[idx-1], true
+}
+
+// 读取原始报价: 秒级K线中收到行情(origin = 1)且保存了原始报价的K线
+// 补充的K线及推送的报价都不使用, 结算报价只由行情源的报价决定.
+// 原始报价与推送的报价保存在同一行中, 并非独立的数据, 哈希链检查有问题的K线(untrusted)不使用
+func loadTicks(s store.CandleStore, coinType string, begin, end int64, untrusted map[int64]bool) (tickList, map[int64]*kline.OptionKline, int, error) {
+	ticks := tickList{}
+	klineMap := map[int64]*kline.OptionKline{}
+	unchained := 0
+	window := int64(scanRows) * kline.Interval1s.Seconds
+	for from := begin; from < end; from += window {
+		to := from + window
+		if to > end {
+			to = end
+		}
+		klineList, err := s.Range(kline.Interval1s, coinType, from, to)
+		if err != nil {
+			return nil, nil, 0, err
+		}
+		for _, k := range klineList {
+			if _, ok := klineMap[k.Time]; !ok {
+				klineMap[k.Time] = k
+			}
+			if k.Origin == 1 && k.RawOpen != "" && !untrusted[k.Time] {
+				ticks = append(ticks, tick{time: k.Time, price: k.RawOpen, vendorTime: k.VendorTime})
+				if k.Hash == "" {
+					unchained++
+				}
+			}
+		}
+	}
+	return ticks, klineMap, unchained, nil
+}
+
+// 说明重新计算的局限
+func limit(report *Report, checkpoints int) string {
+	str := fmt.Sprintf("raw prices are read from option_kline, the same rows as the published prices, not from an independent tick store; "+
+		"they are trusted only as far as the hash chain: %d of %d ticks used are unchained and unverified, %d chain issues found and those rows skipped",
+		report.Unchained, report.Ticks, len(report.Chain.Issues))
+	if checkpoints == 0 {
+		str += "; without checkpoints a rewrite of the whole chain is not detected"
+	}
+	return str
+}
+
+// 价格是否相等, 按数值比较
+func samePrice(a, b string) bool {
+	ret, err := common.BcCmp(a, b)
+	return err == nil && ret == 0
+}
+
+// 按下单及开奖报价计算订单结果
+func orderResult(o *order.OptionOrder, openPrice, closePrice string) (int, error) {
+	ret, err := common.BcCmp(closePrice, openPrice)
+	if err != nil {
+		return 0, err
+	}
+	trend := order.PriceDraw
+	if ret > 0 {
+		trend = order.PriceUp
+	} else if ret < 0 {
+		trend = order.PriceDown
+	}
+	switch {
+	case trend == order.PriceDraw:
+		return order.OptionResultDraw, nil
+	case trend == o.Type:
+		return order.OptionResultWin, nil
+	}
+	return order.OptionResultLose, nil
+}
+
+// 按行情源的原始报价重新计算每期的结算报价, 与秒级K线、结算报价记录及订单对照, 只读取数据.
+// settlements及db为nil时不检查结算报价记录及订单. 原始报价读取自秒级K线, 先检查读取范围内的哈希链,
+// 有问题的K线不使用, 报告中说明未链接的行情数量等局限
+func Run(s store.CandleStore, settlements settlement.Store, db *gorm.DB, opts Options) (*Report, error) {
+	if opts.Period <= 0 || opts.Begin >= opts.End {
+		return nil, fmt.Errorf("invalid options: %+v", opts)
+	}
+	report := &Report{CoinType: opts.CoinType, Begin: opts.Begin, End: opts.End, Period: opts.Period, Discrepancies: []Discrepancy{}}
+	// 使用原始报价之前先检查哈希链
+	var err error
+	if report.Chain, err = chain.Verify(s, opts.CoinType, opts.Begin-lookback, opts.End, opts.Checkpoints, opts.PublicKey); err != nil {
+		return nil, err
+	}
+	untrusted := map[int64]bool{}
+	for _, issue := range report.Chain.Issues {
+		untrusted[issue.Time] = true
+		report.add(Discrepancy{Kind: DiffChain, IssueTime: issue.Time, Detail: issue.Kind + ": " + issue.Detail})
+	}
+	ticks, klineMap, unchained, err := loadTicks(s, opts.CoinType, opts.Begin-lookback, opts.End, untrusted)
+	if err != nil {
+		return nil, err
+	}
+	report.Ticks = len(ticks)
+	report.Unchained = unchained
+	report.Limit = limit(report, report.Chain.Checkpoints)
+
+	settlementMap := map[int64]*settlement.Price{}
+	if settlements != nil {
+		priceList, err := settlements.Range(opts.CoinType, opts.Begin, opts.End)
+		if err != nil {
+			return nil, err
+		}
+		for _, p := range priceList {
+			settlementMap[p.IssueTime] = p
+		}
+	}
+	orderMap := map[int64][]*order.OptionOrder{}
+	if db != nil {
+		orderList, err := order.List(db, opts.CoinType, opts.Begin, opts.End)
+		if err != nil {
+			return nil, err
+		}
+		for _, o := range orderList {
+			orderMap[o.OpenTime] = append(orderMap[o.OpenTime], o)
+		}
+	}
+
+	first := opts.Begin - opts.Begin%opts.Period
+	if first < opts.Begin {
+		first += opts.Period
+	}
+	for issueTime := first; issueTime < opts.End; issueTime += opts.Period {
+		report.Issues++
+		orderList := orderMap[issueTime]
+		report.Orders += len(orderList)
+		expected, ok := ticks.at(issueTime)
+		if !ok {
+			report.add(Discrepancy{Kind: DiffNoSource, IssueTime: issueTime, Detail: fmt.Sprintf("%d orders", len(orderList))})
+			continue
+		}
+		if k, ok := klineMap[issueTime]; !ok {
+			report.add(Discrepancy{Kind: DiffKlineMissing, IssueTime: issueTime, Expected: expected.price})
+		} else if !samePrice(k.Open, expected.price) {
+			report.add(Discrepancy{Kind: DiffKlinePrice, IssueTime: issueTime, Expected: expected.price, Actual: k.Open,
+				Detail: fmt.Sprintf("source tick at %d, vendor time %d", expected.time, expected.vendorTime)})
+		}
+		if p, ok := settlementMap[issueTime]; ok {
+			report.Settlements++
+			if !samePrice(p.Price, expected.price) {
+				report.add(Discrepancy{Kind: DiffSettlementPrice, IssueTime: issueTime, Expected: expected.price, Actual: p.Price,
+					Detail: fmt.Sprintf("recorded ticks %s", p.TickIds)})
+			}
+		}
+		for _, o := range orderList {
+			if o.ClosePrice != "" && !samePrice(o.ClosePrice, expected.price) {
+				report.add(Discrepancy{Kind: DiffOrderClosePrice, IssueTime: issueTime, OrderId: o.Id,
+					Expected: expected.price, Actual: o.ClosePrice})
+			}
+			// 下单报价为下单时刻的报价
+			open, ok := ticks.at(o.CreateTime)
+			if !ok {
+				report.add(Discrepancy{Kind: DiffNoSource, IssueTime: issueTime, OrderId: o.Id,
+					Detail: fmt.Sprintf("no source tick before create time %d", o.CreateTime)})
+				continue
+			}
+			if o.OpenPrice != "" && !samePrice(o.OpenPrice, open.price) {
+				report.add(Discrepancy{Kind: DiffOrderOpenPrice, IssueTime: issueTime, OrderId: o.Id,
+					Expected: open.price, Actual: o.OpenPrice, Detail: fmt.Sprintf("create time %d", o.CreateTime)})
+			}
+			if o.Status != order.OptionOrderOpened {
+				continue
+			}
+			result, err := orderResult(o, open.price, expected.price)
+			if err != nil {
+				return nil, err
+			}
+			if result != o.Result {
+				report.add(Discrepancy{Kind: DiffOrderResult, IssueTime: issueTime, OrderId: o.Id,
+					Expected: strconv.Itoa(result), Actual: strconv.Itoa(o.Result),
+					Detail: fmt.Sprintf("type %s, open %s, close %s", o.Type, open.price, expected.price)})
+			}
+		}
+	}
+	return report, nil
+}
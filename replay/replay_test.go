@@ -0,0 +1,122 @@
+package replay
+
+import (
+	"io/ioutil"
+	"option-kline/chain"
+	"option-kline/kline"
+	"option-kline/order"
+	"option-kline/settlement"
+	"option-kline/store"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestReplay(t *testing.T) {
+	dir, err := ioutil.TempDir("", "replay")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	s, err := store.NewSQLiteStore(dir + "/kline.db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	for tm := int64(50); tm < 300; tm++ {
+		raw := "100"
+		if tm >= 170 {
+			raw = "101"
+		}
+		published := raw
+		if tm == 240 {
+			published = "105" // 开奖时刻的报价被干预
+		}
+		if tm == 180 {
+			continue
+		}
+		s.Append(kline.Interval1s, &kline.OptionKline{CoinType: "GT", Time: tm, Open: published, High: published, Low: published, Close: published,
+			RawOpen: raw, RawHigh: raw, RawLow: raw, RawClose: raw, Origin: 1, Source: kline.SourceForex, VendorTime: tm})
+	}
+	settlements := settlement.NewSQLStore(s.DB())
+	settlements.Save(&settlement.Price{CoinType: "GT", IssueTime: 120, Price: "100"})
+	settlements.Save(&settlement.Price{CoinType: "GT", IssueTime: 240, Price: "105"})
+	orders := []*order.OptionOrder{
+		{CoinType: "GT", Type: order.PriceUp, CreateTime: 150, OpenTime: 180, OpenPrice: "100", ClosePrice: "101.0",
+			Status: order.OptionOrderOpened, Result: order.OptionResultWin},
+		{CoinType: "GT", Type: order.PriceDown, CreateTime: 200, OpenTime: 240, OpenPrice: "101", ClosePrice: "105",
+			Status: order.OptionOrderOpened, Result: order.OptionResultLose},
+	}
+	for _, o := range orders {
+		if err := s.DB().Table(order.TableName).Create(o).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	report, err := Run(s, settlements, s.DB(), Options{CoinType: "GT", Begin: 100, End: 300, Period: 60})
+	if err != nil {
+		t.Fatal(err)
+	}
+	kinds := []string{}
+	for _, d := range report.Discrepancies {
+		kinds = append(kinds, d.Kind)
+	}
+	expected := "kline_missing,kline_price,settlement_price,order_close_price,order_result"
+	if strings.Join(kinds, ",") != expected || report.Issues != 3 || report.Orders != 2 || report.Settlements != 2 {
+		t.Fatalf("unexpected report: %v, %+v", kinds, report)
+	}
+	if d := report.Discrepancies[4]; d.OrderId != orders[1].Id || d.Expected != "3" || d.Actual != "2" {
+		t.Errorf("unexpected order result discrepancy: %+v", d)
+	}
+}
+
+// 哈希链检查有问题的K线不作为原始报价使用
+func TestReplayChain(t *testing.T) {
+	dir, err := ioutil.TempDir("", "replay")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	s, err := store.NewSQLiteStore(dir + "/kline.db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	c, err := chain.NewChain(s, []string{"GT"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for tm := int64(50); tm < 240; tm++ {
+		k := &kline.OptionKline{CoinType: "GT", Time: tm, Open: "100", High: "100", Low: "100", Close: "100",
+			RawOpen: "100", RawHigh: "100", RawLow: "100", RawClose: "100", Origin: 1, Source: kline.SourceForex, VendorTime: tm}
+		c.Link(k)
+		if err := s.Append(kline.Interval1s, k); err != nil {
+			t.Fatal(err)
+		}
+		c.Commit(k)
+	}
+	// 同时修改开奖时刻的推送报价及原始报价
+	if err := s.DB().Table(store.TableName(kline.Interval1s)).Where("time = ?", 180).
+		Updates(map[string]interface{}{"open": "99", "rawOpen": "99"}).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	report, err := Run(s, nil, nil, Options{CoinType: "GT", Begin: 120, End: 240, Period: 60})
+	if err != nil {
+		t.Fatal(err)
+	}
+	kinds := []string{}
+	for _, d := range report.Discrepancies {
+		kinds = append(kinds, d.Kind)
+	}
+	if strings.Join(kinds, ",") != "chain,kline_price" || report.Unchained != 0 || report.Ticks != 189 {
+		t.Fatalf("tampered row should be reported and skipped: %v, %+v", kinds, report)
+	}
+	if d := report.Discrepancies[1]; d.Expected != "100" || d.Actual != "99" {
+		t.Errorf("unexpected kline price discrepancy: %+v", d)
+	}
+	if !strings.Contains(report.Limit, "not from an independent tick store") {
+		t.Errorf("report should state the limit: %s", report.Limit)
+	}
+}
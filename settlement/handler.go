@@ -0,0 +1,68 @@
+package settlement
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"option-kline/common"
+	"strings"
+)
+
+const maxRange = 7 * 86400 // 接口单次查询的最大时长, 单位:秒
+
+// 结算报价查询接口:
+// GET {SettlementPath}?coin=GT,USDT&from=时间&to=时间
+// GET {SettlementPath}?coin=GT&issue=开奖时间
+// 时间为unix秒或RFC3339, 单次最多查询7天
+type Handler struct {
+	store     Store
+	coinTypes []string
+}
+
+func NewHandler(s Store, coinTypes []string) *Handler {
+	return &Handler{store: s, coinTypes: coinTypes}
+}
+
+// 查询结果
+type Result struct {
+	Begin  int64    `json:"begin"`
+	End    int64    `json:"end"`
+	Prices []*Price `json:"prices"`
+}
+
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	coinTypes := h.coinTypes
+	if val := query.Get("coin"); val != "" {
+		coinTypes = strings.Split(strings.Replace(val, " ", "", -1), ",")
+	}
+	result := Result{Prices: []*Price{}}
+	var err error
+	if val := query.Get("issue"); val != "" {
+		if result.Begin, err = common.ParseTime(val); err == nil {
+			result.End = result.Begin + 1
+		}
+	} else if query.Get("from") == "" || query.Get("to") == "" {
+		err = fmt.Errorf("issue or from and to are required")
+	} else if result.Begin, err = common.ParseTime(query.Get("from")); err == nil {
+		result.End, err = common.ParseTime(query.Get("to"))
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if result.Begin >= result.End || result.End-result.Begin > maxRange {
+		http.Error(w, fmt.Sprintf("invalid time range: [%d, %d), at most %d seconds", result.Begin, result.End, maxRange), http.StatusBadRequest)
+		return
+	}
+	for _, coinType := range coinTypes {
+		priceList, err := h.store.Range(coinType, result.Begin, result.End)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		result.Prices = append(result.Prices, priceList...)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(result)
+}
@@ -0,0 +1,189 @@
+package settlement
+
+import (
+	"fmt"
+	"github.com/jinzhu/gorm"
+	log "github.com/sirupsen/logrus"
+	"option-kline/common"
+	"option-kline/kline"
+	"option-kline/store"
+	"sort"
+	"sync"
+)
+
+// db table: settlement_price
+// 每期开奖时刻(openTime)的结算报价, 即该时刻保存及推送的秒级K线开盘价, 同时记录行情源的原始报价作为证据
+type Price struct {
+	Id         int64  `gorm:"column:id" json:"-"`
+	CoinType   string `gorm:"column:coinType" json:"coinType"`
+	IssueTime  int64  `gorm:"column:issueTime" json:"issueTime"`   // 开奖时间, 与option_order.openTime一致
+	Price      string `gorm:"column:price" json:"price"`           // 结算报价
+	RawPrice   string `gorm:"column:rawPrice" json:"rawPrice"`     // 行情源的原始报价
+	Source     string `gorm:"column:source" json:"source"`         // 行情源名称
+	TickIds    string `gorm:"column:tickIds" json:"tickIds"`       // 报价来源的行情, 逗号分隔, 见TickId
+	VendorTime int64  `gorm:"column:vendorTime" json:"vendorTime"` // 行情源的报价时间
+	Origin     uint8  `gorm:"column:origin" json:"origin"`         // 1: 该秒收到的行情, 0: 无行情时以上一条行情补充
+	CandleHash string `gorm:"column:candleHash" json:"candleHash"` // 对应K线的哈希, 未开启哈希链时为空
+	CreateTime int64  `gorm:"column:createTime" json:"createTime"`
+}
+
+const TableName = "settlement_price"
+
+// 行情的标识: 行情源:币种:行情源报价时间, 同一行情源每个币种每秒只取一条行情
+func TickId(k *kline.OptionKline) string {
+	return fmt.Sprintf("%s:%s:%d", k.Source, k.CoinType, k.VendorTime)
+}
+
+// 以开奖时刻保存的秒级K线生成结算报价
+func NewPrice(k *kline.OptionKline, createTime int64) *Price {
+	rawPrice := k.RawOpen
+	if rawPrice == "" {
+		rawPrice = k.Open
+	}
+	p := &Price{
+		CoinType:   k.CoinType,
+		IssueTime:  k.Time,
+		Price:      k.Open,
+		RawPrice:   rawPrice,
+		Source:     k.Source,
+		VendorTime: k.VendorTime,
+		Origin:     k.Origin,
+		CandleHash: k.Hash,
+		CreateTime: createTime,
+	}
+	if k.Source != "" {
+		p.TickIds = TickId(k)
+	}
+	return p
+}
+
+// 每期的开奖时间, 与下单时计算的openTime一致
+func IsIssueTime(t, period int64) bool {
+	return period > 0 && t%period == 0
+}
+
+// 结算报价存储, 每个币种每期只保存第一条
+type Store interface {
+	// 保存结算报价, 该期已有记录时返回false
+	Save(p *Price) (bool, error)
+	// 查询开奖时间在[begin, end)内的结算报价, 按时间升序排列
+	Range(coinType string, begin, end int64) ([]*Price, error)
+}
+
+// 与K线存储使用相同的数据库, 内存K线存储使用内存结算报价存储
+func NewStoreFor(s store.CandleStore) Store {
+	if sqlStore, ok := s.(*store.SQLStore); ok {
+		return NewSQLStore(sqlStore.DB())
+	}
+	return NewMemoryStore()
+}
+
+type SQLStore struct {
+	db *gorm.DB
+}
+
+func NewSQLStore(db *gorm.DB) *SQLStore {
+	return &SQLStore{db: db}
+}
+
+func (s *SQLStore) Save(p *Price) (bool, error) {
+	count := 0
+	if err := s.db.Table(TableName).Where("coinType = ? AND issueTime = ?", p.CoinType, p.IssueTime).
+		Count(&count).Error; err != nil {
+		return false, err
+	}
+	if count > 0 {
+		return false, nil
+	}
+	if err := s.db.Table(TableName).Create(p).Error; err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+func (s *SQLStore) Range(coinType string, begin, end int64) (priceList []*Price, err error) {
+	if err = s.db.Table(TableName).Where("coinType = ? AND issueTime >= ? AND issueTime < ?", coinType, begin, end).
+		Order("issueTime").Find(&priceList).Error; err != nil {
+		return nil, err
+	}
+	return
+}
+
+// 内存结算报价存储, 用于测试及内存K线存储
+type MemoryStore struct {
+	mutex  sync.RWMutex
+	prices map[string][]*Price
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{prices: make(map[string][]*Price, 3)}
+}
+
+func (s *MemoryStore) Save(p *Price) (bool, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	list := s.prices[p.CoinType]
+	idx := sort.Search(len(list), func(i int) bool { return list[i].IssueTime >= p.IssueTime })
+	if idx < len(list) && list[idx].IssueTime == p.IssueTime {
+		return false, nil
+	}
+	price := *p
+	list = append(list, nil)
+	copy(list[idx+1:], list[idx:])
+	list[idx] = &price
+	s.prices[p.CoinType] = list
+	return true, nil
+}
+
+func (s *MemoryStore) Range(coinType string, begin, end int64) ([]*Price, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	priceList := []*Price{}
+	for _, p := range s.prices[coinType] {
+		if p.IssueTime >= begin && p.IssueTime < end {
+			price := *p
+			priceList = append(priceList, &price)
+		}
+	}
+	return priceList, nil
+}
+
+var (
+	settlementMetrics = common.NewMetrics("settlement")
+)
+
+// 记录结算报价: 每条保存成功的秒级K线调用Record, 开奖时刻的K线写入结算报价表
+type Recorder struct {
+	store  Store
+	period int64
+	clock  common.Clock
+}
+
+func NewRecorder(s Store, period int64, clock common.Clock) *Recorder {
+	return &Recorder{store: s, period: period, clock: clock}
+}
+
+func (r *Recorder) Record(k *kline.OptionKline) {
+	fn := "Recorder.Record"
+	if !IsIssueTime(k.Time, r.period) {
+		return
+	}
+	saved, err := r.store.Save(NewPrice(k, r.clock.Now().Unix()))
+	if err != nil {
+		settlementMetrics.Add(k.CoinType+".failed", 1)
+		log.Errorf("[%s]failed to save settlement price of %s at %d: %s", fn, k.CoinType, k.Time, err)
+		return
+	}
+	if !saved {
+		log.Warnf("[%s]settlement price of %s at %d already exists, keep the first one", fn, k.CoinType, k.Time)
+		return
+	}
+	settlementMetrics.Add(k.CoinType+".recorded", 1)
+	common.SetMetric(settlementMetrics, k.CoinType+".last_issue", k.Time)
+}
+
+// 结算报价的字符串形式, 用于日志
+func (p Price) String() string {
+	return fmt.Sprintf("%s@%d: %s (raw %s, source %s, ticks %s, vendor time %d)",
+		p.CoinType, p.IssueTime, p.Price, p.RawPrice, p.Source, p.TickIds, p.VendorTime)
+}
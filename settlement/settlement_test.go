@@ -0,0 +1,52 @@
+package settlement
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"option-kline/common"
+	"option-kline/kline"
+	"testing"
+	"time"
+)
+
+func TestRecordAndQuery(t *testing.T) {
+	s := NewMemoryStore()
+	r := NewRecorder(s, 60, common.NewFakeClock(time.Unix(1546065000, 0)))
+	for tm := int64(1546064998); tm <= 1546065061; tm++ {
+		k := &kline.OptionKline{CoinType: "GT", Time: tm, Open: "1280.10", RawOpen: "1280.00",
+			Origin: 1, Source: kline.SourceForex, VendorTime: tm - 1}
+		r.Record(k)
+	}
+	// 同一期只保留第一条
+	r.Record(&kline.OptionKline{CoinType: "GT", Time: 1546065000, Open: "1300"})
+
+	h := NewHandler(s, []string{"GT", "USDT"})
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", "/api/settlement/price?from=1546065000&to=1546065120", nil))
+	if w.Code != 200 {
+		t.Fatalf("unexpected status %d: %s", w.Code, w.Body.String())
+	}
+	result := Result{}
+	if err := json.Unmarshal(w.Body.Bytes(), &result); err != nil {
+		t.Fatal(err)
+	}
+	if len(result.Prices) != 2 {
+		t.Fatalf("one settlement price per issue expected: %+v", result.Prices)
+	}
+	p := result.Prices[0]
+	if p.IssueTime != 1546065000 || p.Price != "1280.10" || p.RawPrice != "1280.00" ||
+		p.TickIds != "forex:GT:1546064999" || p.VendorTime != 1546064999 || p.CreateTime != 1546065000 {
+		t.Errorf("unexpected settlement price: %+v", p)
+	}
+
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", "/api/settlement/price?coin=GT&issue=1546065060", nil))
+	if err := json.Unmarshal(w.Body.Bytes(), &result); err != nil || len(result.Prices) != 1 || result.Prices[0].IssueTime != 1546065060 {
+		t.Errorf("unexpected result of issue query: %s", w.Body.String())
+	}
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", "/api/settlement/price?coin=GT", nil))
+	if w.Code != 400 {
+		t.Errorf("time range should be required, status: %d", w.Code)
+	}
+}
@@ -0,0 +1,78 @@
+package sink
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"option-kline/kline"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// 将K线以JSON-lines格式写入本地文件, 每个币种每天一个文件
+type FileSink struct {
+	dir      string
+	coinType string
+	date     string
+	file     *os.File
+	writer   *bufio.Writer
+}
+
+func NewFileSink(dir, coinType string) *FileSink {
+	return &FileSink{
+		dir:      dir,
+		coinType: coinType,
+	}
+}
+
+func (s *FileSink) Name() string {
+	return SinkFile
+}
+
+// 文件名: {dir}/kline_{coinType}_{yyyymmdd}.jsonl
+func (s *FileSink) FileName(date string) string {
+	return filepath.Join(s.dir, fmt.Sprintf("kline_%s_%s.jsonl", s.coinType, date))
+}
+
+func (s *FileSink) open(date string) error {
+	if err := s.Close(); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(s.dir, 0755); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(s.FileName(date), os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	s.file, s.writer, s.date = f, bufio.NewWriter(f), date
+	return nil
+}
+
+func (s *FileSink) Publish(klineData *kline.OptionKline) error {
+	date := time.Unix(klineData.Time, 0).Format("20060102")
+	if s.file == nil || date != s.date {
+		if err := s.open(date); err != nil {
+			return err
+		}
+	}
+	data, err := json.Marshal(klineData)
+	if err != nil {
+		return err
+	}
+	if _, err = s.writer.Write(append(data, '\n')); err != nil {
+		return err
+	}
+	return s.writer.Flush()
+}
+
+func (s *FileSink) Close() error {
+	if s.file == nil {
+		return nil
+	}
+	s.writer.Flush()
+	err := s.file.Close()
+	s.file, s.writer, s.date = nil, nil, ""
+	return err
+}
@@ -0,0 +1,78 @@
+package sink
+
+import (
+	"option-kline/kline"
+	"sync"
+	"time"
+)
+
+// 将K线保存在内存中, 用于测试
+type MemorySink struct {
+	klineList []*kline.OptionKline
+	events    []Event
+	mutex     sync.Mutex
+}
+
+// 已推送的事件
+type Event struct {
+	Type string
+	Data interface{}
+}
+
+func NewMemorySink() *MemorySink {
+	return &MemorySink{}
+}
+
+func (s *MemorySink) Name() string {
+	return SinkMemory
+}
+
+func (s *MemorySink) Publish(klineData *kline.OptionKline) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	k := *klineData
+	s.klineList = append(s.klineList, &k)
+	return nil
+}
+
+func (s *MemorySink) PublishEvent(eventType string, data interface{}) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.events = append(s.events, Event{Type: eventType, Data: data})
+	return nil
+}
+
+// 获取已推送的事件
+func (s *MemorySink) Events() []Event {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return append([]Event{}, s.events...)
+}
+
+// 获取已推送的K线
+func (s *MemorySink) Klines() []*kline.OptionKline {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return append([]*kline.OptionKline{}, s.klineList...)
+}
+
+// 等待推送的K线数量达到n, 超时返回false
+func (s *MemorySink) Wait(n int, timeout time.Duration) bool {
+	deadline := time.Now().Add(timeout)
+	for {
+		s.mutex.Lock()
+		count := len(s.klineList)
+		s.mutex.Unlock()
+		if count >= n {
+			return true
+		}
+		if time.Now().After(deadline) {
+			return false
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func (s *MemorySink) Close() error {
+	return nil
+}
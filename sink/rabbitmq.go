@@ -0,0 +1,140 @@
+package sink
+
+import (
+	"encoding/json"
+	"errors"
+	log "github.com/sirupsen/logrus"
+	"github.com/streadway/amqp"
+	"option-kline/kline"
+	"time"
+)
+
+var (
+	errReconnectTooFrequent = errors.New("reconnect too frequently")
+)
+
+// 通过RabbitMQ推送K线
+type RabbitMqSink struct {
+	url         string
+	exchange    string
+	routingKeys []string
+	conn        *amqp.Connection
+	ch          *amqp.Channel
+	lastDial    time.Time
+}
+
+func NewRabbitMqSink(url, exchange string, routingKeys []string) *RabbitMqSink {
+	return &RabbitMqSink{
+		url:         url,
+		exchange:    exchange,
+		routingKeys: routingKeys,
+	}
+}
+
+func (s *RabbitMqSink) Name() string {
+	return SinkRabbitMq
+}
+
+func (s *RabbitMqSink) connect() error {
+	fn := "RabbitMqSink.connect"
+	// 连接失败后1秒内不再重连
+	if time.Since(s.lastDial) < time.Second {
+		return errReconnectTooFrequent
+	}
+	s.lastDial = time.Now()
+	conn, err := amqp.Dial(s.url)
+	if err != nil {
+		log.Errorf("[%s]Failed to connect to RabbitMQ, err: %s, rabbitmqUrl: %s", fn, err, s.url)
+		return err
+	}
+	ch, err := conn.Channel()
+	if err != nil {
+		conn.Close()
+		log.Errorf("[%s]Failed to open a RabbitMQ channel: %s", fn, err)
+		return err
+	}
+	err = ch.ExchangeDeclare(
+		s.exchange, // name
+		"direct",   // type
+		true,       // durable
+		false,      // auto-deleted
+		false,      // internal
+		false,      // no-wait
+		nil,        // arguments
+	)
+	if err != nil {
+		ch.Close()
+		conn.Close()
+		log.Errorf("[%s]Failed to declare a RabbitMQ exchange: %s", fn, err)
+		return err
+	}
+	s.conn, s.ch = conn, ch
+	return nil
+}
+
+func (s *RabbitMqSink) Publish(klineData *kline.OptionKline) error {
+	return s.publish("kline", "kline_"+klineData.CoinType, klineData)
+}
+
+// 推送事件消息, 如配置变更
+func (s *RabbitMqSink) PublishEvent(eventType string, data interface{}) error {
+	return s.publish(eventType, eventType, data)
+}
+
+func (s *RabbitMqSink) publish(bodyType, eventType string, data interface{}) error {
+	fn := "RabbitMqSink.Publish"
+	if s.ch == nil {
+		if err := s.connect(); err != nil {
+			return err
+		}
+	}
+	mqBody := MqBody{
+		Type: bodyType,
+		Data: data,
+	}
+	body, err := json.Marshal(mqBody)
+	if err != nil {
+		return err
+	}
+	mqMsg := RabbitMqMsg{
+		AppId:     "option",
+		EventType: eventType,
+		Body:      string(body),
+	}
+	msg, err := json.Marshal(mqMsg)
+	if err != nil {
+		return err
+	}
+	for _, routingKey := range s.routingKeys {
+		err = s.ch.Publish(
+			s.exchange, // exchange
+			routingKey, // routingKey
+			true,       // mandatory
+			false,      // immediate
+			amqp.Publishing{
+				ContentType:  "text/json",
+				Body:         msg,
+				DeliveryMode: amqp.Persistent,
+			})
+		if err != nil {
+			// 连接异常, 下次推送时重连
+			s.Close()
+			return err
+		}
+		log.Infof(" [%s]Succeeded to send msg: %s", fn, msg)
+	}
+	return nil
+}
+
+func (s *RabbitMqSink) Close() error {
+	if s.ch != nil {
+		s.ch.Close()
+		s.ch = nil
+	}
+	if s.conn != nil {
+		err := s.conn.Close()
+		s.conn = nil
+		return err
+	}
+	return nil
+}
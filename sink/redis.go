@@ -0,0 +1,44 @@
+package sink
+
+import (
+	"option-kline/kline"
+)
+
+// 通过redis pub/sub推送K线, 推送前写入K线缓存; publish为false时只写入缓存
+type RedisSink struct {
+	cache   *kline.RedisCache
+	publish bool
+}
+
+func NewRedisSink(cache *kline.RedisCache) *RedisSink {
+	return &RedisSink{cache: cache, publish: true}
+}
+
+// 只写入K线缓存, 用于未配置redis推送目标的币种.
+// 与其他推送目标一样在独立的缓冲队列中写入, redis缓慢时不会阻塞K线保存及推送
+func NewRedisCacheSink(cache *kline.RedisCache) *RedisSink {
+	return &RedisSink{cache: cache}
+}
+
+func (s *RedisSink) Name() string {
+	if !s.publish {
+		return SinkRedisCache
+	}
+	return SinkRedis
+}
+
+// 写入缓存失败时仍然推送
+func (s *RedisSink) Publish(klineData *kline.OptionKline) error {
+	cacheErr := s.cache.CacheKLine(klineData)
+	if !s.publish {
+		return cacheErr
+	}
+	if err := s.cache.PublishKLine(klineData); err != nil {
+		return err
+	}
+	return cacheErr
+}
+
+func (s *RedisSink) Close() error {
+	return nil
+}
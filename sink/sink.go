@@ -0,0 +1,200 @@
+package sink
+
+import (
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"option-kline/common"
+	"option-kline/kline"
+	"sync"
+	"sync/atomic"
+)
+
+// 推送目标类型
+const (
+	SinkRabbitMq  = "rabbitmq"
+	SinkRedis     = "redis"
+	SinkWebSocket = "websocket"
+	SinkFile      = "file"
+	SinkMemory    = "memory"
+
+	SinkRedisCache = "redis_cache" // 只写入redis K线缓存, 不可配置, 由App为未配置redis推送目标的币种添加
+)
+
+// K线推送目标
+type Sink interface {
+	Name() string
+	Publish(kline *kline.OptionKline) error
+	Close() error
+}
+
+// 事件推送目标, 推送K线以外的消息
+type EventPublisher interface {
+	PublishEvent(eventType string, data interface{}) error
+	Close() error
+}
+
+// 推送消息格式
+type RabbitMqMsg struct {
+	AppId     string `json:"appId"`
+	EventType string `json:"eventType"`
+	Body      string `json:"body"`
+}
+
+type MqBody struct {
+	Type string      `json:"type"`
+	Data interface{} `json:"data"`
+}
+
+// 推送目标构造函数
+type Factory func(name, coinType string) (Sink, error)
+
+// 根据名称创建推送目标, redis推送目标通过cache推送
+func NewSink(name, coinType string, cache *kline.RedisCache) (Sink, error) {
+	switch name {
+	case SinkRabbitMq:
+		return NewRabbitMqSink(common.RabbitMqUrl, common.PushExchange, common.PushRoutineKeyList), nil
+	case SinkRedis:
+		if cache == nil {
+			return nil, fmt.Errorf("redis cache is not initialized")
+		}
+		return NewRedisSink(cache), nil
+	case SinkWebSocket:
+		return DefaultHub, nil
+	case SinkFile:
+		return NewFileSink(common.PublishFileDir, coinType), nil
+	case SinkMemory:
+		return NewMemorySink(), nil
+	}
+	return nil, fmt.Errorf("sink not supported: %s", name)
+}
+
+// 带缓冲的推送目标: 每个推送目标拥有独立的缓冲队列和协程, 慢速或故障的推送目标不会影响其他推送目标
+type BufferedSink struct {
+	sink     Sink
+	coinType string
+	queue    *kline.Queue
+	done     chan struct{}
+	failed   int64 // 推送失败的数量
+}
+
+func NewBufferedSink(sink Sink, coinType string, queue *kline.Queue) *BufferedSink {
+	b := &BufferedSink{
+		sink:     sink,
+		coinType: coinType,
+		queue:    queue,
+		done:     make(chan struct{}),
+	}
+	go b.run()
+	return b
+}
+
+// 写入缓冲队列, 队列已满时按队列策略处理
+func (b *BufferedSink) Push(kline *kline.OptionKline) bool {
+	return b.queue.Push(kline)
+}
+
+// 缓冲队列已满而丢弃的数量
+func (b *BufferedSink) Dropped() int64 {
+	return b.queue.Dropped()
+}
+
+func (b *BufferedSink) Failed() int64 {
+	return atomic.LoadInt64(&b.failed)
+}
+
+func (b *BufferedSink) run() {
+	for !b.consume() {
+	}
+	close(b.done)
+}
+
+// 消费缓冲队列, 返回true表示队列已关闭; 发生panic时返回false, 由run重新消费
+func (b *BufferedSink) consume() (closed bool) {
+	fn := "BufferedSink.consume"
+	defer common.CheckPanic(fn, nil)
+	for klineData := range b.queue.C() {
+		if err := b.sink.Publish(klineData); err != nil {
+			atomic.AddInt64(&b.failed, 1)
+			log.Errorf("[%s][%s][%s]Failed to publish kline, err: %s, data: %v", fn, b.sink.Name(), b.coinType, err, klineData)
+			continue
+		}
+	}
+	return true
+}
+
+// 关闭缓冲队列, 等待剩余数据推送完成后关闭推送目标
+func (b *BufferedSink) Close() error {
+	b.queue.Close()
+	<-b.done
+	return b.sink.Close()
+}
+
+// 按币种将K线分发给各推送目标
+type Dispatcher struct {
+	sinks map[string][]*BufferedSink
+	mutex sync.RWMutex
+}
+
+func NewDispatcher() *Dispatcher {
+	return &Dispatcher{
+		sinks: make(map[string][]*BufferedSink, 10),
+	}
+}
+
+// 为币种添加推送目标, 缓冲队列使用sink队列配置
+func (d *Dispatcher) Add(coinType string, sink Sink) *BufferedSink {
+	return d.AddWithQueue(coinType, sink, kline.NewQueue("sink."+sink.Name()+"."+coinType))
+}
+
+// 为币种添加推送目标, 并指定缓冲队列
+func (d *Dispatcher) AddWithQueue(coinType string, sink Sink, queue *kline.Queue) *BufferedSink {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	b := NewBufferedSink(sink, coinType, queue)
+	d.sinks[coinType] = append(d.sinks[coinType], b)
+	return b
+}
+
+// 根据配置为所有币种创建推送目标
+func (d *Dispatcher) AddFromConfig(coinTypes []string, newSink Factory) error {
+	for _, coinType := range coinTypes {
+		for _, name := range common.GetPublishSinks(coinType) {
+			s, err := newSink(name, coinType)
+			if err != nil {
+				return err
+			}
+			d.Add(coinType, s)
+			log.Infof("[Dispatcher]add sink %s for %s", name, coinType)
+		}
+	}
+	return nil
+}
+
+// 推送K线, 不会阻塞调用方
+func (d *Dispatcher) Publish(kline *kline.OptionKline) {
+	d.mutex.RLock()
+	defer d.mutex.RUnlock()
+	for _, b := range d.sinks[kline.CoinType] {
+		b.Push(kline)
+	}
+}
+
+func (d *Dispatcher) Close() {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	closed := make(map[Sink]bool)
+	for coinType, sinks := range d.sinks {
+		for _, b := range sinks {
+			b.queue.Close()
+			<-b.done
+			// 多个币种可能共用同一个推送目标
+			if !closed[b.sink] {
+				closed[b.sink] = true
+				if err := b.sink.Close(); err != nil {
+					log.Errorf("[Dispatcher]failed to close sink %s for %s: %s", b.sink.Name(), coinType, err)
+				}
+			}
+		}
+	}
+	d.sinks = make(map[string][]*BufferedSink, 10)
+}
@@ -0,0 +1,166 @@
+package sink
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"github.com/gorilla/websocket"
+	"io/ioutil"
+	"net/http/httptest"
+	"option-kline/common"
+	"option-kline/kline"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+// 推送时阻塞, 模拟慢速推送目标
+type blockSink struct {
+	release chan struct{}
+}
+
+func (s *blockSink) Name() string { return "block" }
+func (s *blockSink) Publish(k *kline.OptionKline) error {
+	<-s.release
+	return nil
+}
+func (s *blockSink) Close() error { return nil }
+
+// 推送时panic或返回错误, 模拟故障推送目标
+type faultSink struct {
+	count int
+}
+
+func (s *faultSink) Name() string { return "fault" }
+func (s *faultSink) Publish(k *kline.OptionKline) error {
+	s.count++
+	if s.count%2 == 0 {
+		panic("fault sink panic")
+	}
+	return errors.New("fault sink error")
+}
+func (s *faultSink) Close() error { return nil }
+
+func newKline(coinType string, t int64) *kline.OptionKline {
+	return &kline.OptionKline{CoinType: coinType, Open: "1.0", Close: "1.0", High: "1.0", Low: "1.0", Time: t}
+}
+
+func TestDispatcherIsolation(t *testing.T) {
+	d := NewDispatcher()
+	slow := &blockSink{release: make(chan struct{})}
+	mem := NewMemorySink()
+	slowBuffer := d.AddWithQueue("GT", slow, kline.NewQueueWithPolicy("test.slow", common.QUEUE_POLICY_DROP_NEWEST, 2, 0))
+	faultBuffer := d.AddWithQueue("GT", &faultSink{}, kline.NewQueueWithPolicy("test.fault", common.QUEUE_POLICY_BLOCK, 100, 0))
+	d.AddWithQueue("GT", mem, kline.NewQueueWithPolicy("test.mem.GT", common.QUEUE_POLICY_BLOCK, 100, 0))
+	other := NewMemorySink()
+	d.AddWithQueue("USDT", other, kline.NewQueueWithPolicy("test.mem.USDT", common.QUEUE_POLICY_BLOCK, 100, 0))
+
+	done := make(chan struct{})
+	go func() {
+		for i := int64(0); i < 50; i++ {
+			d.Publish(newKline("GT", i))
+			d.Publish(newKline("USDT", i))
+		}
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("Publish should not be blocked by a slow sink")
+	}
+
+	if !mem.Wait(50, time.Second) || !other.Wait(50, time.Second) {
+		t.Fatalf("healthy sinks should receive all klines, GT: %d, USDT: %d", len(mem.Klines()), len(other.Klines()))
+	}
+	for _, k := range other.Klines() {
+		if k.CoinType != "USDT" {
+			t.Errorf("USDT sink received kline of %s", k.CoinType)
+		}
+	}
+	if slowBuffer.Dropped() == 0 {
+		t.Error("slow sink should drop klines when its buffer is full")
+	}
+	deadline := time.Now().Add(time.Second)
+	for faultBuffer.Failed() < 25 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if faultBuffer.Failed() != 25 {
+		t.Errorf("fault sink should keep consuming after panic, failed: %d", faultBuffer.Failed())
+	}
+
+	close(slow.release)
+	d.Close()
+}
+
+func TestFileSink(t *testing.T) {
+	dir, err := ioutil.TempDir("", "kline-sink")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	s := NewFileSink(dir, "GT")
+	day := time.Date(2019, 1, 2, 23, 59, 59, 0, time.Local).Unix()
+	for _, tm := range []int64{day - 1, day, day + 1} {
+		if err := s.Publish(newKline("GT", tm)); err != nil {
+			t.Fatalf("Publish failed: %s", err)
+		}
+	}
+	s.Close()
+
+	f, err := os.Open(s.FileName("20190102"))
+	if err != nil {
+		t.Fatalf("failed to open kline file: %s", err)
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	lines := 0
+	for scanner.Scan() {
+		k := kline.OptionKline{}
+		if err := json.Unmarshal(scanner.Bytes(), &k); err != nil || k.CoinType != "GT" {
+			t.Errorf("invalid kline line: %s", scanner.Text())
+		}
+		lines++
+	}
+	if lines != 2 {
+		t.Errorf("there should be 2 klines in 20190102 file, actual: %d", lines)
+	}
+	if _, err := os.Stat(s.FileName("20190103")); err != nil {
+		t.Errorf("kline file should rotate by day: %s", err)
+	}
+}
+
+func TestHub(t *testing.T) {
+	hub := NewHub()
+	server := httptest.NewServer(hub)
+	defer server.Close()
+
+	url := "ws" + strings.TrimPrefix(server.URL, "http") + "?coinType=USDT"
+	conn, _, err := websocket.DefaultDialer.Dial(url, nil)
+	if err != nil {
+		t.Fatalf("failed to dial websocket: %s", err)
+	}
+	defer conn.Close()
+	for hub.Count() == 0 {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	hub.Publish(newKline("GT", 1))
+	hub.Publish(newKline("USDT", 2))
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	_, data, err := conn.ReadMessage()
+	if err != nil {
+		t.Fatalf("failed to read message: %s", err)
+	}
+	body := struct {
+		Type string            `json:"type"`
+		Data kline.OptionKline `json:"data"`
+	}{}
+	if err := json.Unmarshal(data, &body); err != nil {
+		t.Fatalf("failed to unmarshal message: %s", err)
+	}
+	if body.Type != "kline" || body.Data.CoinType != "USDT" || body.Data.Time != 2 {
+		t.Errorf("client should only receive subscribed coin type, actual: %s", data)
+	}
+}
@@ -0,0 +1,165 @@
+package sink
+
+import (
+	"encoding/json"
+	"github.com/gorilla/websocket"
+	log "github.com/sirupsen/logrus"
+	"net/http"
+	"option-kline/kline"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	wsWriteTimeout = 5 * time.Second
+	wsSendBuffer   = 100
+)
+
+var (
+	DefaultHub = NewHub()
+	upgrader   = websocket.Upgrader{
+		ReadBufferSize:  1024,
+		WriteBufferSize: 1024,
+		CheckOrigin: func(r *http.Request) bool {
+			return true
+		},
+	}
+)
+
+// websocket客户端
+type wsClient struct {
+	conn      *websocket.Conn
+	coinTypes []string // 订阅的币种, 为空表示订阅所有币种
+	send      chan []byte
+	once      sync.Once
+}
+
+func (c *wsClient) accept(coinType string) bool {
+	if len(c.coinTypes) == 0 {
+		return true
+	}
+	for _, ct := range c.coinTypes {
+		if ct == coinType {
+			return true
+		}
+	}
+	return false
+}
+
+func (c *wsClient) close() {
+	c.once.Do(func() {
+		close(c.send)
+	})
+}
+
+func (c *wsClient) writeLoop() {
+	defer c.conn.Close()
+	for data := range c.send {
+		c.conn.SetWriteDeadline(time.Now().Add(wsWriteTimeout))
+		if err := c.conn.WriteMessage(websocket.TextMessage, data); err != nil {
+			log.Debugf("[wsClient.writeLoop]failed to write message to %s: %s", c.conn.RemoteAddr(), err)
+			return
+		}
+	}
+	c.conn.WriteMessage(websocket.CloseMessage, []byte{})
+}
+
+// 通过websocket向客户端推送K线, 客户端通过参数coinType=GT,USDT订阅指定币种
+type Hub struct {
+	clients map[*wsClient]bool
+	mutex   sync.RWMutex
+}
+
+func NewHub() *Hub {
+	return &Hub{
+		clients: make(map[*wsClient]bool),
+	}
+}
+
+func (h *Hub) Name() string {
+	return SinkWebSocket
+}
+
+func (h *Hub) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	fn := "Hub.ServeHTTP"
+	conn, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		log.Errorf("[%s]failed to upgrade websocket: %s", fn, err)
+		return
+	}
+	client := &wsClient{
+		conn: conn,
+		send: make(chan []byte, wsSendBuffer),
+	}
+	if val := strings.Replace(r.URL.Query().Get("coinType"), " ", "", -1); val != "" {
+		client.coinTypes = strings.Split(val, ",")
+	}
+	h.mutex.Lock()
+	h.clients[client] = true
+	h.mutex.Unlock()
+	log.Infof("[%s]websocket client connected: %s, coinTypes: %v", fn, conn.RemoteAddr(), client.coinTypes)
+
+	go client.writeLoop()
+	// 客户端不需要发送数据, 读取失败说明连接已断开
+	for {
+		if _, _, err := conn.ReadMessage(); err != nil {
+			break
+		}
+	}
+	h.remove(client)
+	log.Infof("[%s]websocket client disconnected: %s", fn, conn.RemoteAddr())
+}
+
+func (h *Hub) remove(client *wsClient) {
+	h.mutex.Lock()
+	delete(h.clients, client)
+	h.mutex.Unlock()
+	client.close()
+}
+
+// 客户端数量
+func (h *Hub) Count() int {
+	h.mutex.RLock()
+	defer h.mutex.RUnlock()
+	return len(h.clients)
+}
+
+func (h *Hub) Publish(klineData *kline.OptionKline) error {
+	data, err := json.Marshal(MqBody{
+		Type: "kline",
+		Data: klineData,
+	})
+	if err != nil {
+		return err
+	}
+	slowClients := []*wsClient{}
+	h.mutex.RLock()
+	for client := range h.clients {
+		if !client.accept(klineData.CoinType) {
+			continue
+		}
+		select {
+		case client.send <- data:
+		default:
+			// 客户端接收太慢, 断开连接
+			slowClients = append(slowClients, client)
+		}
+	}
+	h.mutex.RUnlock()
+	for _, client := range slowClients {
+		log.Warnf("[Hub.Publish]websocket client is too slow, disconnect: %s", client.conn.RemoteAddr())
+		h.remove(client)
+	}
+	return nil
+}
+
+func (h *Hub) Close() error {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	for client := range h.clients {
+		delete(h.clients, client)
+		client.close()
+	}
+	return nil
+}
@@ -0,0 +1,171 @@
+package store
+
+import (
+	"option-kline/kline"
+	"sort"
+	"sync"
+)
+
+// 内存K线存储, 用于测试及无需持久化的部署
+type MemoryStore struct {
+	mutex  sync.RWMutex
+	tables map[string]map[string][]*kline.OptionKline // 表名 -> 币种 -> 按时间升序排列的K线
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		tables: make(map[string]map[string][]*kline.OptionKline, len(kline.IntervalList)),
+	}
+}
+
+func (s *MemoryStore) Append(interval kline.Interval, klineList ...*kline.OptionKline) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	table, ok := s.tables[TableName(interval)]
+	if !ok {
+		table = make(map[string][]*kline.OptionKline, 10)
+		s.tables[TableName(interval)] = table
+	}
+	for _, k := range klineList {
+		candle := *k
+		list := table[k.CoinType]
+		// 通常按时间顺序写入, 直接追加
+		idx := len(list)
+		if idx > 0 && list[idx-1].Time > k.Time {
+			idx = sort.Search(len(list), func(i int) bool { return list[i].Time > k.Time })
+		}
+		list = append(list, nil)
+		copy(list[idx+1:], list[idx:])
+		list[idx] = &candle
+		table[k.CoinType] = list
+	}
+	return nil
+}
+
+func (s *MemoryStore) AppendMissing(interval kline.Interval, klineList ...*kline.OptionKline) (int64, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	table, ok := s.tables[TableName(interval)]
+	if !ok {
+		table = make(map[string][]*kline.OptionKline, 10)
+		s.tables[TableName(interval)] = table
+	}
+	skipped := int64(0)
+	for _, k := range klineList {
+		list := table[k.CoinType]
+		idx := sort.Search(len(list), func(i int) bool { return list[i].Time >= k.Time })
+		if idx < len(list) && list[idx].Time == k.Time {
+			skipped++
+			continue
+		}
+		candle := *k
+		list = append(list, nil)
+		copy(list[idx+1:], list[idx:])
+		list[idx] = &candle
+		table[k.CoinType] = list
+	}
+	return skipped, nil
+}
+
+func (s *MemoryStore) Upsert(interval kline.Interval, klineList ...*kline.OptionKline) (int64, error) {
+	s.mutex.Lock()
+	if interval.Name == kline.Interval1s.Name {
+		timeMap := make(map[string]map[int64]bool, 3)
+		for _, k := range klineList {
+			if timeMap[k.CoinType] == nil {
+				timeMap[k.CoinType] = make(map[int64]bool, len(klineList))
+			}
+			timeMap[k.CoinType][k.Time] = true
+		}
+		for coinType, times := range timeMap {
+			for _, old := range s.tables[TableName(interval)][coinType] {
+				if old.Hash != "" && times[old.Time] {
+					s.mutex.Unlock()
+					return 0, ErrChainedKline
+				}
+			}
+		}
+	}
+	replaced := int64(0)
+	for _, k := range klineList {
+		list := s.tables[TableName(interval)][k.CoinType]
+		kept := list[:0]
+		for _, old := range list {
+			if old.Time == k.Time {
+				replaced++
+				continue
+			}
+			kept = append(kept, old)
+		}
+		if list != nil {
+			s.tables[TableName(interval)][k.CoinType] = kept
+		}
+	}
+	s.mutex.Unlock()
+	return replaced, s.Append(interval, klineList...)
+}
+
+func (s *MemoryStore) Range(interval kline.Interval, coinType string, begin, end int64) ([]*kline.OptionKline, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	list := s.tables[TableName(interval)][coinType]
+	from := sort.Search(len(list), func(i int) bool { return list[i].Time >= begin })
+	to := sort.Search(len(list), func(i int) bool { return list[i].Time >= end })
+	if from >= to {
+		return nil, nil
+	}
+	return copyKlines(list[from:to]), nil
+}
+
+func (s *MemoryStore) Latest(interval kline.Interval, coinType string, n int) ([]*kline.OptionKline, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	if n <= 0 {
+		return nil, nil
+	}
+	list := s.tables[TableName(interval)][coinType]
+	if len(list) > n {
+		list = list[len(list)-n:]
+	}
+	return copyKlines(list), nil
+}
+
+func (s *MemoryStore) Earliest(interval kline.Interval, coinType string) (*kline.OptionKline, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	list := s.tables[TableName(interval)][coinType]
+	if len(list) == 0 {
+		return nil, nil
+	}
+	candle := *list[0]
+	return &candle, nil
+}
+
+func (s *MemoryStore) DeleteBefore(interval kline.Interval, coinType string, t int64) (int64, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	table := s.tables[TableName(interval)]
+	deleted := int64(0)
+	for key, list := range table {
+		if coinType != "" && key != coinType {
+			continue
+		}
+		idx := sort.Search(len(list), func(i int) bool { return list[i].Time >= t })
+		deleted += int64(idx)
+		table[key] = append([]*kline.OptionKline{}, list[idx:]...)
+	}
+	return deleted, nil
+}
+
+func (s *MemoryStore) Close() error {
+	return nil
+}
+
+func copyKlines(list []*kline.OptionKline) []*kline.OptionKline {
+	klineList := make([]*kline.OptionKline, 0, len(list))
+	for _, k := range list {
+		candle := *k
+		klineList = append(klineList, &candle)
+	}
+	return klineList
+}
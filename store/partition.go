@@ -0,0 +1,140 @@
+package store
+
+import (
+	"database/sql"
+	"fmt"
+	"option-kline/common"
+	"option-kline/kline"
+	"option-kline/migrations"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// 最后一个分区, 存放超出已创建分区范围的数据
+const partitionMax = "pmax"
+
+// 按天分区, 仅MySQL支持
+type Partitioner interface {
+	// 创建至now之后daysAhead天的分区, 删除上界不晚于cutoff的分区, 返回删除的分区
+	RotatePartitions(interval kline.Interval, now, cutoff int64, daysAhead int) ([]string, error)
+}
+
+// 分区: 存放time小于Bound且不小于上一分区Bound的数据
+type Partition struct {
+	Name  string
+	Bound int64
+}
+
+// 当天分区的分区名及上界(次日0点, UTC)
+func dayPartition(t int64) Partition {
+	begin := t - t%common.DAY_SECONDS
+	return Partition{
+		Name:  "p" + time.Unix(begin, 0).UTC().Format("20060102"),
+		Bound: begin + common.DAY_SECONDS,
+	}
+}
+
+// 需要新建的分区: 从已有分区的最大上界至now之后daysAhead天
+func missingPartitions(existing []Partition, now int64, daysAhead int) []Partition {
+	maxBound := int64(0)
+	for _, p := range existing {
+		if p.Bound > maxBound {
+			maxBound = p.Bound
+		}
+	}
+	partitions := []Partition{}
+	for day := 0; day <= daysAhead; day++ {
+		p := dayPartition(now + int64(day)*common.DAY_SECONDS)
+		if p.Bound > maxBound {
+			partitions = append(partitions, p)
+		}
+	}
+	return partitions
+}
+
+// 可删除的分区: 上界不晚于cutoff, 即分区内所有数据都已过期
+func expiredPartitions(existing []Partition, cutoff int64) []Partition {
+	partitions := []Partition{}
+	for _, p := range existing {
+		if p.Bound <= cutoff {
+			partitions = append(partitions, p)
+		}
+	}
+	return partitions
+}
+
+func partitionDefs(partitions []Partition) string {
+	defs := make([]string, 0, len(partitions)+1)
+	for _, p := range partitions {
+		defs = append(defs, fmt.Sprintf("PARTITION %s VALUES LESS THAN (%d)", p.Name, p.Bound))
+	}
+	defs = append(defs, fmt.Sprintf("PARTITION %s VALUES LESS THAN MAXVALUE", partitionMax))
+	return strings.Join(defs, ", ")
+}
+
+// 已有的分区, 按上界升序排列, 不包含pmax; 表未分区时partitioned为false
+func (s *SQLStore) partitions(table string) (partitions []Partition, partitioned bool, err error) {
+	rows, err := s.db.Raw("SELECT PARTITION_NAME, PARTITION_DESCRIPTION FROM information_schema.PARTITIONS "+
+		"WHERE TABLE_SCHEMA = DATABASE() AND TABLE_NAME = ?", table).Rows()
+	if err != nil {
+		return nil, false, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var name, desc sql.NullString
+		if err := rows.Scan(&name, &desc); err != nil {
+			return nil, false, err
+		}
+		if !name.Valid {
+			continue
+		}
+		partitioned = true
+		bound, err := strconv.ParseInt(desc.String, 10, 64)
+		if err != nil {
+			// MAXVALUE
+			continue
+		}
+		partitions = append(partitions, Partition{Name: name.String, Bound: bound})
+	}
+	sort.Slice(partitions, func(i, j int) bool { return partitions[i].Bound < partitions[j].Bound })
+	return partitions, partitioned, rows.Err()
+}
+
+func (s *SQLStore) RotatePartitions(interval kline.Interval, now, cutoff int64, daysAhead int) ([]string, error) {
+	if name := s.db.Dialect().GetName(); name != migrations.DialectMySQL {
+		return nil, fmt.Errorf("partition is not supported by %s", name)
+	}
+	table := TableName(interval)
+	existing, partitioned, err := s.partitions(table)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query partitions of %s: %s", table, err)
+	}
+	if !partitioned {
+		// 首次分区: 已有数据均在当天及之前, 全部归入当天分区
+		sqlStr := fmt.Sprintf("ALTER TABLE `%s` PARTITION BY RANGE (`time`) (%s)", table,
+			partitionDefs(missingPartitions(nil, now, daysAhead)))
+		if err := s.db.Exec(sqlStr).Error; err != nil {
+			return nil, fmt.Errorf("failed to partition %s: %s", table, err)
+		}
+		return nil, nil
+	}
+	if partitions := missingPartitions(existing, now, daysAhead); len(partitions) > 0 {
+		sqlStr := fmt.Sprintf("ALTER TABLE `%s` REORGANIZE PARTITION %s INTO (%s)", table, partitionMax, partitionDefs(partitions))
+		if err := s.db.Exec(sqlStr).Error; err != nil {
+			return nil, fmt.Errorf("failed to add partitions to %s: %s", table, err)
+		}
+	}
+	dropped := []string{}
+	for _, p := range expiredPartitions(existing, cutoff) {
+		dropped = append(dropped, p.Name)
+	}
+	if len(dropped) > 0 {
+		sqlStr := fmt.Sprintf("ALTER TABLE `%s` DROP PARTITION %s", table, strings.Join(dropped, ", "))
+		if err := s.db.Exec(sqlStr).Error; err != nil {
+			return nil, fmt.Errorf("failed to drop partitions of %s: %s", table, err)
+		}
+	}
+	return dropped, nil
+}
@@ -0,0 +1,238 @@
+package store
+
+import (
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"option-kline/common"
+	"option-kline/kline"
+)
+
+const (
+	downsampleDelay   = 60   // 周期结束后等待秒级K线保存完成的时间, 单位:秒
+	downsampleRows    = 3600 // 每次查询的源K线数量上限
+	downsampleWindows = 100  // 每次执行每个周期最多查询的次数, 历史数据较多时分多次完成
+)
+
+// 保留策略配置
+type RetentionConfig struct {
+	Keep               map[string]int64 // 周期名称 -> 保留时长(秒), 未配置的周期永久保留
+	Partition          bool             // 按天对秒级K线表分区(仅MySQL)
+	PartitionDaysAhead int              // 提前创建的分区天数
+}
+
+// 根据已加载的配置生成保留策略配置
+func NewRetentionConfig() RetentionConfig {
+	return RetentionConfig{
+		Keep:               common.RetentionKeep,
+		Partition:          common.PartitionEnabled,
+		PartitionDaysAhead: common.PartitionDaysAhead,
+	}
+}
+
+// 数据保留: 将较小周期的K线聚合为较大周期的K线, 删除过期数据
+// 较小周期的K线在聚合完成前不会被删除
+type Retention struct {
+	store     CandleStore
+	coinTypes []string
+	conf      RetentionConfig
+	clock     common.Clock
+}
+
+func NewRetention(store CandleStore, coinTypes []string, conf RetentionConfig, clock common.Clock) (*Retention, error) {
+	for name := range conf.Keep {
+		if _, err := kline.ParseInterval(name); err != nil {
+			return nil, fmt.Errorf("invalid retention: %s", err)
+		}
+	}
+	if conf.Partition {
+		if _, ok := store.(Partitioner); !ok {
+			return nil, fmt.Errorf("partition is not supported by the kline store")
+		}
+	}
+	return &Retention{
+		store:     store,
+		coinTypes: coinTypes,
+		conf:      conf,
+		clock:     clock,
+	}, nil
+}
+
+// 聚合的源周期: 能整除该周期的最大的较小周期, 如: 1m <- 1s, 5m <- 1m, 1d <- 1h
+func SourceInterval(interval kline.Interval) (kline.Interval, bool) {
+	for idx := len(kline.IntervalList) - 1; idx >= 0; idx-- {
+		source := kline.IntervalList[idx]
+		if source.Seconds < interval.Seconds && interval.Seconds%source.Seconds == 0 {
+			return source, true
+		}
+	}
+	return kline.Interval{}, false
+}
+
+// 执行一次保留策略
+func (r *Retention) Run() {
+	fn := "Retention.Run"
+	now := r.clock.Now().Unix()
+	// 各周期可删除数据的时间上限, 所有币种中的最小值用于删除分区
+	partitionCutoff := int64(-1)
+	for _, coinType := range r.coinTypes {
+		safe := r.downsample(coinType, now)
+		for _, interval := range kline.IntervalList {
+			keep, ok := r.conf.Keep[interval.Name]
+			if !ok {
+				continue
+			}
+			cutoff := now - keep
+			if s, ok := safe[interval.Name]; ok && s < cutoff {
+				cutoff = s
+			}
+			if interval.Name == kline.Interval1s.Name && (partitionCutoff < 0 || cutoff < partitionCutoff) {
+				partitionCutoff = cutoff
+			}
+			deleted, err := r.store.DeleteBefore(interval, coinType, cutoff)
+			if err != nil {
+				log.Errorf("[%s]failed to delete %s klines of %s: %s", fn, interval, coinType, err)
+				continue
+			}
+			if deleted > 0 {
+				log.Infof("[%s]deleted %d %s klines of %s before %d", fn, deleted, interval, coinType, cutoff)
+			}
+		}
+	}
+
+	if r.conf.Partition {
+		// 未配置秒级K线保留时长时只创建分区
+		if partitionCutoff < 0 {
+			partitionCutoff = 0
+		}
+		dropped, err := r.store.(Partitioner).RotatePartitions(kline.Interval1s, now, partitionCutoff, r.conf.PartitionDaysAhead)
+		if err != nil {
+			log.Errorf("[%s]failed to rotate partitions: %s", fn, err)
+		} else if len(dropped) > 0 {
+			log.Infof("[%s]dropped partitions: %v", fn, dropped)
+		}
+	}
+}
+
+// 按周期从小到大依次聚合, 返回各源周期已聚合到的时间, 早于该时间的源K线可以删除
+func (r *Retention) downsample(coinType string, now int64) map[string]int64 {
+	fn := "Retention.downsample"
+	// 各周期已完成的时间: 秒级K线在保存延迟之前的数据已完整
+	done := map[string]int64{kline.Interval1s.Name: now - downsampleDelay}
+	safe := make(map[string]int64, len(kline.IntervalList))
+	for _, interval := range kline.IntervalList {
+		source, ok := SourceInterval(interval)
+		if !ok {
+			continue
+		}
+		limit := done[source.Name]
+		next, err := r.Downsample(coinType, interval, source, interval.Begin(limit))
+		if err != nil {
+			log.Errorf("[%s]failed to downsample %s klines of %s: %s", fn, interval, coinType, err)
+		}
+		done[interval.Name] = next
+		if s, ok := safe[source.Name]; !ok || next < s {
+			safe[source.Name] = next
+		}
+	}
+	return safe
+}
+
+// 将源周期的K线聚合为interval周期的K线, 只聚合limit之前的周期
+// 从已有的最新K线之后继续聚合, 返回尚未聚合的第一个周期的开始时间
+func (r *Retention) Downsample(coinType string, interval, source kline.Interval, limit int64) (int64, error) {
+	latest, err := r.store.Latest(interval, coinType, 1)
+	if err != nil {
+		return 0, err
+	}
+	var next int64
+	if len(latest) > 0 {
+		next = latest[0].Time + interval.Seconds
+	} else {
+		first, err := r.store.Earliest(source, coinType)
+		if err != nil {
+			return 0, err
+		}
+		// 无源数据, 无需保留
+		if first == nil {
+			return limit, nil
+		}
+		next = interval.Begin(first.Time)
+	}
+
+	window := downsampleRows * source.Seconds
+	window -= window % interval.Seconds
+	if window < interval.Seconds {
+		window = interval.Seconds
+	}
+	for i := 0; i < downsampleWindows && next < limit; i++ {
+		end := next + window
+		if end > limit {
+			end = limit
+		}
+		klineList, err := r.store.Range(source, coinType, next, end)
+		if err != nil {
+			return next, err
+		}
+		if candles := Aggregate(interval, klineList); len(candles) > 0 {
+			if err := r.store.Append(interval, candles...); err != nil {
+				return next, err
+			}
+		}
+		next = end
+	}
+	return next, nil
+}
+
+// 将按时间升序排列的K线聚合为interval周期的K线
+func Aggregate(interval kline.Interval, klineList []*kline.OptionKline) []*kline.OptionKline {
+	candles := []*kline.OptionKline{}
+	var cur *kline.OptionKline
+	for _, k := range klineList {
+		t := interval.Begin(k.Time)
+		if cur == nil || cur.Time != t {
+			candle := *k
+			candle.Time = t
+			candle.PrevHash, candle.Hash = "", ""
+			cur = &candle
+			candles = append(candles, cur)
+			continue
+		}
+		kline.MergeKLine(cur, k)
+	}
+	return candles
+}
+
+// 重新聚合[begin, end)所在周期的K线, 用于补录秒级K线后更新较大周期的K线
+// 只更新保留任务已聚合过的周期, 之后的周期由保留任务聚合
+func Resample(s CandleStore, coinType string, begin, end int64) error {
+	for _, interval := range kline.IntervalList {
+		source, ok := SourceInterval(interval)
+		if !ok {
+			continue
+		}
+		latest, err := s.Latest(interval, coinType, 1)
+		if err != nil {
+			return err
+		}
+		if len(latest) == 0 {
+			continue
+		}
+		from, to := interval.Begin(begin), interval.Begin(end-1)+interval.Seconds
+		if done := latest[0].Time + interval.Seconds; to > done {
+			to = done
+		}
+		if from >= to {
+			continue
+		}
+		klineList, err := s.Range(source, coinType, from, to)
+		if err != nil {
+			return err
+		}
+		if candles := Aggregate(interval, klineList); len(candles) > 0 {
+			if _, err := s.Upsert(interval, candles...); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,112 @@
+package store
+
+import (
+	"fmt"
+	"option-kline/common"
+	"option-kline/kline"
+	"testing"
+	"time"
+)
+
+// 写入[begin, end)内每秒一条的K线, 价格逐秒上涨
+func seedSeconds(t *testing.T, s CandleStore, coinType string, begin, end int64) {
+	klineList := []*kline.OptionKline{}
+	for tm := begin; tm < end; tm++ {
+		k := newCandle(coinType, tm, fmt.Sprintf("%d.5", 1000+tm-begin))
+		k.Origin = 1
+		klineList = append(klineList, k)
+	}
+	if err := s.Append(kline.Interval1s, klineList...); err != nil {
+		t.Fatalf("failed to append: %s", err)
+	}
+}
+
+func count(t *testing.T, s CandleStore, interval kline.Interval, coinType string) int {
+	klineList, err := s.Range(interval, coinType, 0, 1<<40)
+	if err != nil {
+		t.Fatalf("failed to query range: %s", err)
+	}
+	return len(klineList)
+}
+
+func TestRetentionDownsample(t *testing.T) {
+	begin := int64(1546041600) // 2018-12-29 00:00:00 UTC
+	s := NewMemoryStore()
+	seedSeconds(t, s, "GT", begin, begin+2*3600)
+	clock := common.NewFakeClock(time.Unix(begin+3*3600, 0))
+	conf := RetentionConfig{Keep: map[string]int64{"1s": 5400}}
+	r, err := NewRetention(s, []string{"GT"}, conf, clock)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.Run()
+
+	for _, expected := range []struct {
+		interval kline.Interval
+		n        int
+	}{{kline.Interval1m, 120}, {kline.Interval5m, 24}, {kline.Interval15m, 8}, {kline.Interval1h, 2}, {kline.Interval1d, 0}} {
+		if n := count(t, s, expected.interval, "GT"); n != expected.n {
+			t.Errorf("there should be %d %s klines, actual: %d", expected.n, expected.interval, n)
+		}
+	}
+	klineList, _ := s.Latest(kline.Interval1h, "GT", 2)
+	if k := klineList[0]; k.Time != begin || k.Open != "1000.5" || k.Close != "4599.5" || k.High != "4599.5" || k.Low != "1000.5" || k.Origin != 1 {
+		t.Errorf("unexpected 1h kline: %s", k)
+	}
+	// 秒级K线保留1.5小时
+	if n := count(t, s, kline.Interval1s, "GT"); n != 1800 {
+		t.Errorf("there should be 1800 1s klines left, actual: %d", n)
+	}
+
+	// 再次执行时从已聚合的位置继续, 不重复聚合
+	seedSeconds(t, s, "GT", begin+2*3600, begin+3*3600)
+	clock.Advance(time.Hour)
+	r.Run()
+	if n := count(t, s, kline.Interval1m, "GT"); n != 180 {
+		t.Errorf("there should be 180 1m klines, actual: %d", n)
+	}
+	if n := count(t, s, kline.Interval1h, "GT"); n != 3 {
+		t.Errorf("there should be 3 1h klines, actual: %d", n)
+	}
+}
+
+func TestRetentionKeepUndownsampled(t *testing.T) {
+	now := int64(1546065000)
+	s := NewMemoryStore()
+	seedSeconds(t, s, "GT", now-600, now)
+	// 秒级K线立即过期, 但尚未聚合的数据不能删除
+	r, err := NewRetention(s, []string{"GT"}, RetentionConfig{Keep: map[string]int64{"1s": 0}}, common.NewFakeClock(time.Unix(now, 0)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.Run()
+	first, _ := s.Earliest(kline.Interval1s, "GT")
+	if first == nil || first.Time != kline.Interval1m.Begin(now-downsampleDelay) {
+		t.Errorf("klines that are not downsampled should be kept, earliest: %v", first)
+	}
+
+	if _, err := NewRetention(s, []string{"GT"}, RetentionConfig{Keep: map[string]int64{"2s": 1}}, common.RealClock); err == nil {
+		t.Error("unsupported interval should be rejected")
+	}
+	if _, err := NewRetention(s, []string{"GT"}, RetentionConfig{Partition: true}, common.RealClock); err == nil {
+		t.Error("memory store should not support partition")
+	}
+}
+
+func TestPartitions(t *testing.T) {
+	now := int64(1546065000) // 2018-12-29 06:30:00 UTC
+	day := int64(common.DAY_SECONDS)
+	partitions := missingPartitions(nil, now, 2)
+	if len(partitions) != 3 || partitions[0].Name != "p20181229" || partitions[2].Name != "p20181231" ||
+		partitions[0].Bound != now-now%day+day {
+		t.Errorf("unexpected partitions: %v", partitions)
+	}
+	// 已存在的分区不重复创建
+	if partitions = missingPartitions(partitions[:2], now, 2); len(partitions) != 1 || partitions[0].Name != "p20181231" {
+		t.Errorf("unexpected partitions: %v", partitions)
+	}
+	existing := []Partition{dayPartition(now - 2*day), dayPartition(now - day), dayPartition(now)}
+	if expired := expiredPartitions(existing, now-day); len(expired) != 1 || expired[0].Name != "p20181227" {
+		t.Errorf("unexpected expired partitions: %v", expired)
+	}
+}
@@ -0,0 +1,209 @@
+package store
+
+import (
+	"fmt"
+	"github.com/jinzhu/gorm"
+	_ "github.com/jinzhu/gorm/dialects/sqlite"
+	"option-kline/kline"
+	"option-kline/migrations"
+	"os"
+	"path/filepath"
+)
+
+// 基于gorm的K线存储, 支持MySQL及SQLite
+type SQLStore struct {
+	db    *gorm.DB
+	owned bool // 是否由存储创建并负责关闭连接
+}
+
+// 使用已有的数据库连接(MySQL), 关闭存储时不关闭连接
+func NewSQLStore(db *gorm.DB) *SQLStore {
+	return &SQLStore{db: db}
+}
+
+// 打开SQLite数据库文件, 由调用方负责关闭
+func OpenSQLite(path string) (*gorm.DB, error) {
+	if dir := filepath.Dir(path); dir != "" {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return nil, err
+		}
+	}
+	db, err := gorm.Open("sqlite3", path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open sqlite %s: %s", path, err)
+	}
+	db.SingularTable(true)
+	// sqlite不支持并发写入
+	db.DB().SetMaxOpenConns(1)
+	return db, nil
+}
+
+// 以只读方式打开已存在的SQLite数据库文件, 由调用方负责关闭
+func OpenSQLiteReadOnly(path string) (*gorm.DB, error) {
+	if _, err := os.Stat(path); err != nil {
+		return nil, fmt.Errorf("failed to open sqlite %s: %s", path, err)
+	}
+	db, err := gorm.Open("sqlite3", "file:"+path+"?mode=ro")
+	if err != nil {
+		return nil, fmt.Errorf("failed to open sqlite %s: %s", path, err)
+	}
+	db.SingularTable(true)
+	return db, nil
+}
+
+// 打开SQLite数据库文件, 本地文件由存储自行管理, 打开时自动执行表结构变更
+func NewSQLiteStore(path string) (*SQLStore, error) {
+	db, err := OpenSQLite(path)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := migrations.Up(db); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return &SQLStore{db: db, owned: true}, nil
+}
+
+func (s *SQLStore) DB() *gorm.DB {
+	return s.db
+}
+
+// 写入K线的表, 哈希链只记录在秒级K线表中
+func insertTable(db *gorm.DB, interval kline.Interval) *gorm.DB {
+	db = db.Table(TableName(interval))
+	if interval.Name != kline.Interval1s.Name {
+		db = db.Omit(kline.ChainColumns...)
+	}
+	return db
+}
+
+func (s *SQLStore) Append(interval kline.Interval, klineList ...*kline.OptionKline) error {
+	if len(klineList) == 1 {
+		return insertTable(s.db, interval).Create(klineList[0]).Error
+	}
+	tx := s.db.Begin()
+	for _, k := range klineList {
+		if err := insertTable(tx, interval).Create(k).Error; err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	return tx.Commit().Error
+}
+
+// 依赖币种及时间的唯一索引(版本9), 已存在时由数据库忽略写入
+func (s *SQLStore) AppendMissing(interval kline.Interval, klineList ...*kline.OptionKline) (int64, error) {
+	modifier := "IGNORE"
+	if s.db.Dialect().GetName() == "sqlite3" {
+		modifier = "OR IGNORE"
+	}
+	skipped := int64(0)
+	tx := s.db.Begin()
+	for _, k := range klineList {
+		db := insertTable(tx, interval).Set("gorm:insert_modifier", modifier).Create(k)
+		if db.Error != nil {
+			tx.Rollback()
+			return 0, db.Error
+		}
+		if db.RowsAffected == 0 {
+			skipped++
+		}
+	}
+	return skipped, tx.Commit().Error
+}
+
+func (s *SQLStore) Upsert(interval kline.Interval, klineList ...*kline.OptionKline) (int64, error) {
+	timeMap := make(map[string][]int64, 3)
+	for _, k := range klineList {
+		timeMap[k.CoinType] = append(timeMap[k.CoinType], k.Time)
+	}
+	replaced := int64(0)
+	tx := s.db.Begin()
+	for coinType, times := range timeMap {
+		// 分批删除, 避免IN条件过长
+		for len(times) > 0 {
+			n := len(times)
+			if n > 500 {
+				n = 500
+			}
+			if interval.Name == kline.Interval1s.Name {
+				chained := 0
+				if err := tx.Table(TableName(interval)).Where("coinType = ? AND time IN (?) AND hash <> ''", coinType, times[:n]).
+					Count(&chained).Error; err != nil {
+					tx.Rollback()
+					return 0, err
+				}
+				if chained > 0 {
+					tx.Rollback()
+					return 0, ErrChainedKline
+				}
+			}
+			db := tx.Table(TableName(interval)).Where("coinType = ? AND time IN (?)", coinType, times[:n]).Delete(&kline.OptionKline{})
+			if db.Error != nil {
+				tx.Rollback()
+				return 0, db.Error
+			}
+			replaced += db.RowsAffected
+			times = times[n:]
+		}
+	}
+	for _, k := range klineList {
+		if err := insertTable(tx, interval).Create(k).Error; err != nil {
+			tx.Rollback()
+			return 0, err
+		}
+	}
+	return replaced, tx.Commit().Error
+}
+
+func (s *SQLStore) Range(interval kline.Interval, coinType string, begin, end int64) (klineList []*kline.OptionKline, err error) {
+	err = s.db.Table(TableName(interval)).Where("coinType = ? AND time >= ? AND time < ?", coinType, begin, end).
+		Order("time").Find(&klineList).Error
+	return
+}
+
+func (s *SQLStore) Latest(interval kline.Interval, coinType string, n int) (klineList []*kline.OptionKline, err error) {
+	if n <= 0 {
+		return
+	}
+	if err = s.db.Table(TableName(interval)).Where("coinType = ?", coinType).
+		Order("time desc").Limit(n).Find(&klineList).Error; err != nil {
+		return nil, err
+	}
+	reverse(klineList)
+	return
+}
+
+func (s *SQLStore) Earliest(interval kline.Interval, coinType string) (*kline.OptionKline, error) {
+	klineList := []*kline.OptionKline{}
+	if err := s.db.Table(TableName(interval)).Where("coinType = ?", coinType).
+		Order("time").Limit(1).Find(&klineList).Error; err != nil {
+		return nil, err
+	}
+	if len(klineList) == 0 {
+		return nil, nil
+	}
+	return klineList[0], nil
+}
+
+func (s *SQLStore) DeleteBefore(interval kline.Interval, coinType string, t int64) (int64, error) {
+	db := s.db.Table(TableName(interval)).Where("time < ?", t)
+	if coinType != "" {
+		db = db.Where("coinType = ?", coinType)
+	}
+	db = db.Delete(&kline.OptionKline{})
+	return db.RowsAffected, db.Error
+}
+
+func (s *SQLStore) Close() error {
+	if s.owned {
+		return s.db.Close()
+	}
+	return nil
+}
+
+func reverse(klineList []*kline.OptionKline) {
+	for i, j := 0, len(klineList)-1; i < j; i, j = i+1, j-1 {
+		klineList[i], klineList[j] = klineList[j], klineList[i]
+	}
+}
@@ -0,0 +1,60 @@
+package store
+
+import (
+	"errors"
+	"fmt"
+	"github.com/jinzhu/gorm"
+	"option-kline/common"
+	"option-kline/kline"
+)
+
+// K线存储: 每个周期一张表, 时间为K线所在周期的开始时间(unix秒)
+type CandleStore interface {
+	// 追加K线
+	Append(interval kline.Interval, klineList ...*kline.OptionKline) error
+	// 追加K线, 跳过相同币种及时间的已有K线(包括并发写入的K线), 返回跳过的数量
+	AppendMissing(interval kline.Interval, klineList ...*kline.OptionKline) (int64, error)
+	// 写入K线, 替换相同币种及时间的已有K线, 返回被替换的数量
+	// 秒级K线中已链接(有哈希)的K线不可替换, 此时不写入任何K线并返回ErrChainedKline
+	Upsert(interval kline.Interval, klineList ...*kline.OptionKline) (int64, error)
+	// 查询[begin, end)内的K线, 按时间升序排列
+	Range(interval kline.Interval, coinType string, begin, end int64) ([]*kline.OptionKline, error)
+	// 查询最新的n条K线, 按时间升序排列
+	Latest(interval kline.Interval, coinType string, n int) ([]*kline.OptionKline, error)
+	// 查询最早的K线, 无数据时返回nil
+	Earliest(interval kline.Interval, coinType string) (*kline.OptionKline, error)
+	// 删除早于t的K线, coinType为空时删除所有币种, 返回删除的数量
+	DeleteBefore(interval kline.Interval, coinType string, t int64) (int64, error)
+	Close() error
+}
+
+// 替换已链接的秒级K线会破坏哈希链
+var ErrChainedKline = errors.New("chained kline can not be replaced")
+
+// 周期对应的表名: 秒级K线为option_kline, 其他周期为option_kline_{周期}
+func TableName(interval kline.Interval) string {
+	if interval.Name == kline.Interval1s.Name {
+		return "option_kline"
+	}
+	return "option_kline_" + interval.Name
+}
+
+// 根据配置创建K线存储, mysql存储使用db连接
+func NewStoreFromConfig(db *gorm.DB) (CandleStore, error) {
+	switch common.StoreDriver {
+	case common.STORE_MYSQL:
+		if db == nil {
+			return nil, fmt.Errorf("mysql store requires db config")
+		}
+		return NewSQLStore(db), nil
+	case common.STORE_SQLITE:
+		s, err := NewSQLiteStore(common.StoreSQLitePath)
+		if err != nil {
+			return nil, err
+		}
+		return s, nil
+	case common.STORE_MEMORY:
+		return NewMemoryStore(), nil
+	}
+	return nil, fmt.Errorf("store driver not supported: %s", common.StoreDriver)
+}
@@ -0,0 +1,117 @@
+package store
+
+import (
+	"io/ioutil"
+	"option-kline/kline"
+	"os"
+	"testing"
+)
+
+func newCandle(coinType string, t int64, price string) *kline.OptionKline {
+	return &kline.OptionKline{CoinType: coinType, Open: price, Close: price, High: price, Low: price, Time: t, LastUpdate: t}
+}
+
+// 各存储实现共用的测试用例
+func testCandleStore(t *testing.T, s CandleStore) {
+	begin := int64(1546065000)
+	// 乱序写入
+	for _, i := range []int64{0, 1, 2, 4, 3} {
+		if err := s.Append(kline.Interval1s, newCandle("GT", begin+i, "1280.1")); err != nil {
+			t.Fatalf("failed to append: %s", err)
+		}
+	}
+	if err := s.Append(kline.Interval1s, newCandle("USDT", begin, "6.88"), newCandle("USDT", begin+1, "6.89")); err != nil {
+		t.Fatalf("failed to append: %s", err)
+	}
+	if err := s.Append(kline.Interval1m, newCandle("GT", begin-begin%60, "1280.1")); err != nil {
+		t.Fatalf("failed to append: %s", err)
+	}
+
+	klineList, err := s.Range(kline.Interval1s, "GT", begin+1, begin+4)
+	if err != nil {
+		t.Fatalf("failed to query range: %s", err)
+	}
+	if len(klineList) != 3 || klineList[0].Time != begin+1 || klineList[2].Time != begin+3 {
+		t.Errorf("range should be [begin, end) in ascending order, actual: %v", klineList)
+	}
+
+	klineList, err = s.Latest(kline.Interval1s, "GT", 2)
+	if err != nil {
+		t.Fatalf("failed to query latest: %s", err)
+	}
+	if len(klineList) != 2 || klineList[0].Time != begin+3 || klineList[1].Time != begin+4 {
+		t.Errorf("latest klines should be in ascending order, actual: %v", klineList)
+	}
+	if klineList, _ = s.Latest(kline.Interval1s, "BTC", 2); len(klineList) != 0 {
+		t.Errorf("there should be no klines for BTC, actual: %v", klineList)
+	}
+	if first, err := s.Earliest(kline.Interval1s, "GT"); err != nil || first == nil || first.Time != begin {
+		t.Errorf("unexpected earliest kline: %v, err: %v", first, err)
+	}
+	if first, _ := s.Earliest(kline.Interval1s, "BTC"); first != nil {
+		t.Errorf("there should be no earliest kline for BTC, actual: %v", first)
+	}
+	if klineList, _ = s.Latest(kline.Interval1m, "GT", 10); len(klineList) != 1 {
+		t.Errorf("intervals should be stored separately, actual: %v", klineList)
+	}
+
+	// 替换已有的K线, 写入新的K线
+	replaced, err := s.Upsert(kline.Interval1s, newCandle("GT", begin+4, "1281.1"), newCandle("GT", begin+5, "1281.2"))
+	if err != nil || replaced != 1 {
+		t.Errorf("1 kline should be replaced, actual: %d, err: %v", replaced, err)
+	}
+	klineList, _ = s.Latest(kline.Interval1s, "GT", 3)
+	if len(klineList) != 3 || klineList[1].Open != "1281.1" || klineList[2].Time != begin+5 {
+		t.Errorf("unexpected klines after upsert: %v", klineList)
+	}
+	// 已链接的秒级K线不可替换, 同批的K线也不写入
+	chained := newCandle("GT", begin+6, "1281.3")
+	chained.Hash = "0011"
+	if err := s.Append(kline.Interval1s, chained); err != nil {
+		t.Fatalf("failed to append: %s", err)
+	}
+	if _, err := s.Upsert(kline.Interval1s, newCandle("GT", begin+7, "1281.4"), newCandle("GT", begin+6, "1281.4")); err != ErrChainedKline {
+		t.Errorf("chained kline should not be replaced, err: %v", err)
+	}
+	if klineList, _ = s.Latest(kline.Interval1s, "GT", 1); len(klineList) != 1 || klineList[0].Open != "1281.3" {
+		t.Errorf("unexpected klines after refused upsert: %v", klineList)
+	}
+
+	// 只写入缺失的K线
+	skipped, err := s.AppendMissing(kline.Interval1s, newCandle("GT", begin+5, "1282.1"), newCandle("GT", begin+8, "1282.1"))
+	if err != nil || skipped != 1 {
+		t.Errorf("1 existing kline should be skipped, actual: %d, err: %v", skipped, err)
+	}
+	if klineList, _ = s.Range(kline.Interval1s, "GT", begin+5, begin+9); len(klineList) != 3 || klineList[0].Open != "1281.2" {
+		t.Errorf("unexpected klines after append missing: %v", klineList)
+	}
+
+	deleted, err := s.DeleteBefore(kline.Interval1s, "GT", begin+2)
+	if err != nil || deleted != 2 {
+		t.Errorf("2 klines should be deleted, actual: %d, err: %v", deleted, err)
+	}
+	if klineList, _ = s.Range(kline.Interval1s, "GT", 0, begin+10); len(klineList) != 6 {
+		t.Errorf("6 klines should be left, actual: %v", klineList)
+	}
+	if deleted, _ = s.DeleteBefore(kline.Interval1s, "", begin+10); deleted != 8 {
+		t.Errorf("klines of all coin types should be deleted, actual: %d", deleted)
+	}
+}
+
+func TestMemoryStore(t *testing.T) {
+	testCandleStore(t, NewMemoryStore())
+}
+
+func TestSQLiteStore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "kline-store")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	s, err := NewSQLiteStore(dir + "/kline.db")
+	if err != nil {
+		t.Fatalf("failed to open sqlite store: %s", err)
+	}
+	defer s.Close()
+	testCandleStore(t, s)
+}
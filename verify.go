@@ -0,0 +1,104 @@
+package main
+
+import (
+	"crypto/ed25519"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"option-kline/chain"
+	"option-kline/common"
+	"os"
+	"strings"
+)
+
+// 检查秒级K线哈希链: option-kline verify -from 时间 -to 时间 [选项]
+// 发现问题时返回错误, 进程以非0状态退出
+func runVerify(args []string) error {
+	fs := flag.NewFlagSet("verify", flag.ContinueOnError)
+	from := fs.String("from", "", "begin time, unix seconds or RFC3339")
+	to := fs.String("to", "", "end time (exclusive), unix seconds or RFC3339")
+	coins := fs.String("coin", "", "coin types, comma separated, default: all supported")
+	checkpointDir := fs.String("checkpoints", common.ChainCheckpointDir, "checkpoint dir, empty to skip checkpoints")
+	output := fs.String("o", "", "write the JSON report to this file, default: stdout")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *from == "" || *to == "" {
+		fs.Usage()
+		return fmt.Errorf("usage: option-kline verify -from time -to time [options]")
+	}
+	begin, err := common.ParseTime(*from)
+	if err != nil {
+		return err
+	}
+	end, err := common.ParseTime(*to)
+	if err != nil {
+		return err
+	}
+	coinTypes := common.CoinSupported.Load().([]string)
+	if *coins != "" {
+		coinTypes = strings.Split(strings.Replace(*coins, " ", "", -1), ",")
+	}
+
+	checkpoints, publicKey, err := loadCheckpoints(*checkpointDir)
+	if err != nil {
+		return err
+	}
+
+	s, closeStore, err := openReadOnlyStore()
+	if err != nil {
+		return err
+	}
+	defer closeStore()
+	summaries := []*chain.Summary{}
+	issues := 0
+	for _, coinType := range coinTypes {
+		summary, err := chain.Verify(s, coinType, begin, end, checkpoints, publicKey)
+		if err != nil {
+			return err
+		}
+		for _, issue := range summary.Issues {
+			fmt.Fprintln(os.Stderr, issue)
+		}
+		issues += len(summary.Issues)
+		summaries = append(summaries, summary)
+	}
+	out := os.Stdout
+	if *output != "" {
+		if out, err = os.Create(*output); err != nil {
+			return err
+		}
+		defer out.Close()
+	}
+	enc := json.NewEncoder(out)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(summaries); err != nil {
+		return err
+	}
+	if issues > 0 {
+		return fmt.Errorf("%d issues found", issues)
+	}
+	fmt.Fprintln(os.Stderr, "0 issues found")
+	return nil
+}
+
+// 读取dir中的检查点及按配置校验签名的公钥, dir为空时不读取检查点, 未配置密钥时公钥为nil
+func loadCheckpoints(dir string) (checkpoints []chain.Checkpoint, publicKey ed25519.PublicKey, err error) {
+	if common.ChainVerifyKey != "" {
+		if publicKey, err = chain.ParsePublicKey(common.ChainVerifyKey); err != nil {
+			return nil, nil, err
+		}
+	} else if common.ChainSignKey != "" {
+		privateKey, err := chain.ParsePrivateKey(common.ChainSignKey)
+		if err != nil {
+			return nil, nil, err
+		}
+		publicKey = privateKey.Public().(ed25519.PublicKey)
+	}
+	if dir != "" {
+		if checkpoints, err = chain.ReadCheckpoints(dir); err != nil {
+			return nil, nil, err
+		}
+	}
+	return checkpoints, publicKey, nil
+}